@@ -0,0 +1,260 @@
+// Renders a debug scene or a saved world to an image without opening a window
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/chewxy/math32"
+	ren "github.com/zheskett/go-voxel/internal/render"
+	"github.com/zheskett/go-voxel/internal/scenes"
+	te "github.com/zheskett/go-voxel/internal/tensor"
+	vxl "github.com/zheskett/go-voxel/internal/voxel"
+)
+
+func main() {
+	scene := flag.Int("scene", 0, "Debug scene to render: "+scenes.Menu)
+	world := flag.String("world", "", "Saved world to render instead of a debug scene")
+	width := flag.Int("width", 400, "Image width")
+	height := flag.Int("height", 300, "Image height")
+	out := flag.String("o", "render.png", "Output image, .png or .hdr")
+	hdr := flag.String("hdr", "", "Optional second output image, .png or .hdr. HDR files get the linear colors before tone mapping")
+	x := flag.Float64("x", 16, "Camera x")
+	y := flag.Float64("y", 4, "Camera y")
+	z := flag.Float64("z", 16, "Camera z")
+	pitch := flag.Float64("pitch", 45, "Camera pitch in degrees")
+	yaw := flag.Float64("yaw", 0, "Camera yaw in degrees")
+	fov := flag.Float64("fov", 90, "Camera field of view in degrees")
+	projection := flag.String("projection", "perspective",
+		"Projection: perspective, orthographic, isometric, dimetric, panorama or cubemap. Isometric and dimetric ignore -pitch and -yaw")
+	orthoHeight := flag.Float64("ortho-height", 64, "Height of orthographic views in voxels")
+	aperture := flag.Float64("aperture", 0, "Lens radius for depth of field, needs -samples")
+	focus := flag.Float64("focus", 16, "Distance to the plane in focus")
+	dist := flag.Float64("dist", 0, "Render distance, 0 uses the scene's default")
+	threads := flag.Int("threads", 0, "Render threads, 0 uses one per CPU")
+	stats := flag.Bool("stats", false, "Print tile timing stats")
+	samples := flag.Int("samples", 0, "Progressive samples per pixel for anti-aliasing and soft shadows, 0 renders once")
+	tonemap := flag.String("tonemap", "aces", "Tone mapper: clamp, reinhard, aces or filmic")
+	exposure := flag.Float64("exposure", 0, "Exposure in stops")
+	autoExposure := flag.Bool("auto-exposure", false, "Expose for the average brightness of the image")
+	bloom := flag.Bool("bloom", false, "Let bright pixels bleed into their surroundings")
+	ao := flag.String("ao", "vertex", "Ambient occlusion: none, vertex or traced")
+	aoSamples := flag.Int("ao-samples", 8, "Rays per pixel for traced ambient occlusion")
+	aoRadius := flag.Float64("ao-radius", 4, "Length of traced ambient occlusion rays")
+	pathtrace := flag.Bool("pathtrace", false, "Path trace the image as a reference, with -samples samples per pixel (64 if not set)")
+	bounces := flag.Int("bounces", 8, "Diffuse bounces per path when path tracing")
+	gi := flag.Bool("gi", false, "Flood bounce light off lit surfaces through the world")
+	reflections := flag.Int("reflections", 4, "Reflections and refractions followed off metal and through glass")
+	sky := flag.String("sky", "solid", "Sky: solid, gradient, physical, or a .png or .hdr equirectangular image")
+	fog := flag.Float64("fog", 0, "Fog density, the fraction of light lost per voxel")
+	fogHeight := flag.Float64("fog-height", 0, "Height fog is -fog thick at")
+	fogFalloff := flag.Float64("fog-falloff", 0, "How quickly fog thins out going up, 0 is the same everywhere")
+	fade := flag.Float64("fade", 0.1, "Fraction of the render distance distant voxels fade into the sky over")
+	aovs := flag.String("aovs", "", "Comma separated extra outputs to save next to -o: depth, normal, albedo, position, voxel and material")
+	denoise := flag.Bool("denoise", false, "Smooth out the noise of -samples and -pathtrace renders")
+	post := flag.String("post", "", "Comma separated post effects to run after tone mapping: fxaa, outline, grade, vignette, pixelate and dither")
+	lut := flag.String("lut", "", ".cube LUT for the grade effect")
+	pixelSize := flag.Int("pixel-size", 4, "Block size of the pixelate effect")
+	ditherLevels := flag.Int("dither-levels", 4, "Shades per channel of the dither effect")
+	outlineVoxels := flag.Bool("outline-voxels", false, "Have the outline effect outline every voxel")
+	debugView := flag.String("debug", "off", "Debug view to render instead: off, steps, normals, depth, lighting or cache")
+	maxSteps := flag.Int("max-steps", 256, "Step count the steps heatmap tops out at")
+	grid := flag.Bool("grid", false, "Draw the voxel grid over the image")
+	chunks := flag.Bool("chunks", false, "Draw world chunk boundaries over the image")
+	emissiveLights := flag.Bool("emissive-lights", false, "Turn glowing voxels with Light set into lights")
+	flag.Parse()
+
+	pipeline := ren.HDRPipelineInit()
+	tm, ok := ren.ParseToneMapper(*tonemap)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown tone mapper: %v\n", *tonemap)
+		os.Exit(1)
+	}
+	pipeline.ToneMap = tm
+	pipeline.Exposure = float32(*exposure)
+	pipeline.AutoExposure = *autoExposure
+	pipeline.Bloom = *bloom
+	aoMode, ok := ren.ParseAOMode(*ao)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown ambient occlusion mode: %v\n", *ao)
+		os.Exit(1)
+	}
+	env := ren.EnvironmentInit()
+	if mode, ok := ren.ParseSkyMode(*sky); ok && mode != ren.SkyImage {
+		env.Sky = mode
+	} else {
+		skybox, err := ren.LoadSkybox(*sky)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		env.Sky, env.Skybox = ren.SkyImage, skybox
+	}
+	env.Fog.Density = float32(*fog)
+	env.Fog.Height = float32(*fogHeight)
+	env.Fog.Falloff = float32(*fogFalloff)
+	env.Fade = float32(*fade)
+
+	stack := ren.PostStackInit()
+	if *post != "" {
+		// The effects only change the tone mapped bytes, HDR files would come out without them
+		for _, path := range []string{*out, *hdr} {
+			if strings.EqualFold(filepath.Ext(path), ".hdr") {
+				fmt.Fprintf(os.Stderr, "-post can't be used with .hdr output: %v\n", path)
+				os.Exit(1)
+			}
+		}
+		for _, name := range strings.Split(*post, ",") {
+			if !stack.Enable(strings.TrimSpace(name), true) {
+				fmt.Fprintf(os.Stderr, "Unknown post effect: %v\n", name)
+				os.Exit(1)
+			}
+		}
+	}
+	if *lut != "" {
+		table, err := ren.LoadCubeLUT(*lut)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		stack.Effect("grade").(*ren.ColorGrade).LUT = table
+	}
+	stack.Effect("pixelate").(*ren.Pixelate).Size = *pixelSize
+	stack.Effect("dither").(*ren.Dither).Levels = *ditherLevels
+	stack.Effect("outline").(*ren.Outline).VoxelBorders = *outlineVoxels
+
+	dbg := ren.DebugInit()
+	view, ok := ren.ParseDebugView(*debugView)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Unknown debug view: %v\n", *debugView)
+		os.Exit(1)
+	}
+	dbg.View = view
+	dbg.MaxSteps = *maxSteps
+	dbg.Grid = *grid
+	dbg.Chunks = *chunks
+
+	ren.SetRenderThreads(*threads)
+
+	var vox vxl.Voxels
+	renderDist := float32(256.0)
+	if *world != "" {
+		var err error
+		vox, err = vxl.LoadPath(*world)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	} else {
+		vox = vxl.VoxelsInit(256, 256, 256)
+		renderDist = scenes.Build(*scene, &vox)
+	}
+	if *emissiveLights {
+		vox.Lights = append(vox.Lights, vox.EmissiveLights()...)
+	}
+	if *gi {
+		vox.EnablePropagation()
+	}
+	if *dist > 0 {
+		renderDist = float32(*dist)
+	}
+
+	cam := ren.CameraInit()
+	cam.Fov = float32(*fov)
+	cam.Pos = te.Vec3(float32(*x), float32(*y), float32(*z))
+	cam.RenderDistance = renderDist
+	cam.SetRotationFPS(float32(*pitch)*math32.Pi/180.0, float32(*yaw)*math32.Pi/180.0)
+	switch *projection {
+	case "isometric":
+		cam.SetIsometric()
+	case "dimetric":
+		cam.SetDimetric()
+	default:
+		proj, ok := ren.ParseProjection(*projection)
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Unknown projection: %v\n", *projection)
+			os.Exit(1)
+		}
+		cam.Projection = proj
+	}
+	cam.OrthoHeight = float32(*orthoHeight)
+	cam.Aperture = float32(*aperture)
+	cam.FocusDistance = float32(*focus)
+	cam.Ambient.Mode = aoMode
+	cam.Ambient.Samples = *aoSamples
+	cam.Ambient.Radius = float32(*aoRadius)
+	cam.MaxReflections = *reflections
+	cam.Environment = env
+
+	var pix ren.Pixels
+	if dbg.View != ren.DebugOff {
+		pix = ren.RenderHeadlessDebug(&cam, &vox, *width, *height, &dbg)
+	} else if *pathtrace {
+		pt := ren.PathTracerInit()
+		pt.MaxBounces = *bounces
+		if *samples <= 0 {
+			*samples = 64
+		}
+		pix = ren.RenderHeadlessPathTraced(&cam, &vox, *width, *height, *samples, pt)
+	} else if *samples > 0 {
+		pix = ren.RenderHeadlessProgressive(&cam, &vox, *width, *height, *samples)
+	} else {
+		pix = ren.RenderHeadless(&cam, &vox, *width, *height)
+	}
+	// Everything after the render runs on the scheduler too, and would replace its stats
+	frame := ren.DefaultScheduler().Stats()
+	var buffers ren.AOVs
+	if *denoise || *aovs != "" || *post != "" || dbg.HasOverlay() {
+		buffers = ren.AOVsInit(*width, *height)
+		cam.RenderAOVs(&vox, &buffers)
+	}
+	// Debug views are already final
+	if dbg.View == ren.DebugOff {
+		if *denoise {
+			dn := ren.DenoiserInit()
+			dn.Apply(&cam, &vox, &pix, &buffers)
+		}
+		pipeline.Resolve(&pix)
+		if err := stack.Apply(&pix, &buffers); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	dbg.Overlay(&cam, &pix, &buffers)
+	if *stats {
+		fmt.Printf("%v tiles on %v threads in %v, %v stolen\n",
+			len(frame.Tiles), ren.DefaultScheduler().Threads(), frame.Elapsed, frame.Steals)
+		if slowest, ok := frame.Slowest(); ok {
+			fmt.Printf("Slowest tile at (%v, %v) took %v\n", slowest.X, slowest.Y, slowest.Duration)
+		}
+	}
+	for _, path := range []string{*out, *hdr} {
+		if path == "" {
+			continue
+		}
+		if err := pix.SaveImage(path); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+
+	if *aovs == "" {
+		return
+	}
+	ext := filepath.Ext(*out)
+	for _, name := range strings.Split(*aovs, ",") {
+		kind, ok := ren.ParseAOV(strings.TrimSpace(name))
+		if !ok {
+			fmt.Fprintf(os.Stderr, "Unknown output: %v\n", name)
+			os.Exit(1)
+		}
+		img := buffers.Image(kind)
+		if err := img.SaveImage(strings.TrimSuffix(*out, ext) + "_" + kind.String() + ext); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/zheskett/go-voxel/internal/scenes"
 	te "github.com/zheskett/go-voxel/internal/tensor"
 	vxl "github.com/zheskett/go-voxel/internal/voxel"
+	win "github.com/zheskett/go-voxel/internal/window"
 )
 
 func init() {
@@ -19,30 +20,16 @@ func init() {
 
 func main() {
 	vox := vxl.VoxelsInit(256, 256, 256)
-	renderDist := float32(256.0)
 	var scene int
-	fmt.Printf("Enter 1 for the big scene, 2 for room, 3 for big bunny, 4 for .vox objects, anything else for small scene\n")
+	fmt.Printf("Enter %v\n", scenes.Menu)
 	fmt.Scanln(&scene)
-	switch scene {
-	case 1:
-		scenes.VoxelDebugSceneBig(&vox)
-	case 2:
-		scenes.VoxelDebugEmptyScene(&vox)
-	case 3:
-		scenes.VoxelDebugSceneHugeBunny(&vox)
-		renderDist = 560.0
-	case 4:
-		scenes.VoxelDebugSceneTrees(&vox)
-		renderDist = 560.0
-	default:
-		scenes.VoxelDebugSceneSmall(&vox)
-	}
-	rm, window := ren.RenderManagerInit()
+	renderDist := scenes.Build(scene, &vox)
+	rm, window := win.RenderManagerInit()
 	cam := ren.CameraInit()
 	cam.Movespeed = 20
 	cam.Lookspeed = 0.005
 	cam.Fov = 90
-	cam.Aspect = float32(rm.Pixels.Width) / float32(rm.Pixels.Height)
+	cam.Aspect = rm.Aspect()
 	cam.Pos = te.Vec3(16, 4, 16)
 	cam.RenderDistance = renderDist
 
@@ -51,8 +38,16 @@ func main() {
 	engine.Window = window
 	engine.Camera = cam
 	engine.Voxels = vox
+	engine.Journal = vxl.JournalInit(&engine.Voxels)
 	engine.Framedata = ren.FrameDataInit()
+	engine.Progressive = ren.ProgressiveInit()
+	engine.PathTracer = ren.PathTracerInit()
+	engine.Denoiser = ren.DenoiserInit()
+	engine.HDR = ren.HDRPipelineInit()
+	engine.Post = ren.PostStackInit()
+	engine.Debug = ren.DebugInit()
 	engine.SetScrollCallback()
+	engine.SetKeyCallback()
 
 	for {
 		engine.UpdateInputs()
@@ -1,36 +1,83 @@
 package engine
 
 import (
+	"fmt"
 	"os"
 
 	"github.com/go-gl/glfw/v3.3/glfw"
 	"github.com/zheskett/go-voxel/internal/render"
 	"github.com/zheskett/go-voxel/internal/voxel"
+	"github.com/zheskett/go-voxel/internal/window"
 )
 
 const (
 	moveSpeedInc = 1.0
+	// Render scale change per - or = press
+	renderScaleInc = 0.125
+	// Exposure change per Page Up or Page Down press, in stops
+	exposureInc = 0.5
+	// Frame time dynamic resolution aims for, in seconds
+	dynamicFrameTarget = 1.0 / 30.0
+	// Fog density F11 turns on
+	fogDensity = 0.01
 )
 
 type Engine struct {
-	Renderer  *render.RenderManager
+	Renderer  *window.RenderManager
 	Window    *glfw.Window
 	Camera    render.Camera
 	Voxels    voxel.Voxels
+	Journal   *voxel.Journal
 	Framedata render.FrameData
+
+	// Accumulates samples while the view is still, toggled with F3
+	Progressive        render.Progressive
+	ProgressiveEnabled bool
+	// Reference renderer, F9 has Progressive use it
+	PathTracer render.PathTracer
+
+	// Cleans up the noise of the progressive and path traced renders, toggled with F1
+	Denoiser       render.Denoiser
+	DenoiseEnabled bool
+
+	// Tone maps the float render into the window's bytes
+	HDR render.HDRPipeline
+	// Effects run over the tone mapped frame, the number keys toggle them in order
+	Post render.PostStack
+	// V cycles debug views in place of the shaded frame, G and C toggle the grid and chunk overlays
+	Debug render.Debug
 }
 
 func (eng *Engine) UpdateInputs() {
 	eng.Framedata.Update()
 	eng.Framedata.ReportFps()
-	// render.UpdateCamInputGLFW(&eng.Camera, eng.Window, &eng.Framedata)
-	render.UpdateCamInputGLFWFPS(&eng.Camera, eng.Window, &eng.Framedata)
-	eng.Voxels.UpdateInputs(eng.Window, eng.Camera.Pos, eng.Camera.Fvec)
+	eng.Renderer.UpdateScale(eng.Framedata.Deltat)
+	// window.UpdateCamInputGLFW(&eng.Camera, eng.Window, &eng.Framedata)
+	window.UpdateCamInputGLFWFPS(&eng.Camera, eng.Window, &eng.Framedata)
+	eng.Journal.ApplyPending()
+	window.UpdateEditInputs(eng.Journal, &eng.Voxels, eng.Window, eng.Camera.Pos, eng.Camera.Fvec)
 }
 
 func (eng *Engine) UpdateRender() {
-	eng.Renderer.Pixels.FillPixels(render.BackgroundRed, render.BackgroundGreen, render.BackgroundBlue)
-	eng.Camera.RenderVoxels(&eng.Voxels, &eng.Renderer.Pixels)
+	eng.Camera.Aspect = eng.Renderer.Aspect()
+	pix := &eng.Renderer.Pixels
+	if eng.Debug.View != render.DebugOff {
+		eng.Debug.Render(&eng.Camera, &eng.Voxels, pix)
+	} else {
+		if eng.ProgressiveEnabled {
+			eng.Progressive.Render(&eng.Camera, &eng.Voxels, pix)
+		} else {
+			eng.Camera.RenderVoxels(&eng.Voxels, pix)
+		}
+		if eng.DenoiseEnabled {
+			eng.Denoiser.Apply(&eng.Camera, &eng.Voxels, pix, pix.AOVs())
+		}
+		eng.HDR.Resolve(pix)
+		if err := eng.Post.Apply(pix, pix.AOVs()); err != nil {
+			fmt.Println(err)
+		}
+	}
+	eng.Debug.Overlay(&eng.Camera, pix, pix.AOVs())
 	eng.Renderer.Render(eng.Window)
 }
 
@@ -47,3 +94,115 @@ func (eng *Engine) SetScrollCallback() {
 		eng.Camera.Movespeed = max(eng.Camera.Movespeed+float32(yoff)*moveSpeedInc, 0)
 	})
 }
+
+// Ctrl+Z to undo and Ctrl+Y or Ctrl+Shift+Z to redo voxel edits. F1 toggles the denoiser,
+// - and = change the render scale, F2 toggles dynamic resolution and F3 progressive rendering.
+// F4 cycles tone mappers, F5 toggles auto exposure, F6 bloom, Page Up and Page Down change exposure.
+// F7 cycles ambient occlusion modes, F8 toggles bounce light and F9 path traces progressively.
+// F10 cycles skies, F11 toggles fog and F12 cycles projections. 1 to 9 toggle post effects.
+// V cycles debug views, G toggles the voxel grid overlay and C the chunk overlay
+func (eng *Engine) SetKeyCallback() {
+	eng.Window.SetKeyCallback(func(w *glfw.Window, key glfw.Key, _ int, action glfw.Action, mods glfw.ModifierKey) {
+		if action == glfw.Release {
+			return
+		}
+		if mods&glfw.ModControl == 0 {
+			eng.handleRenderKey(key)
+			return
+		}
+		switch {
+		case key == glfw.KeyZ && mods&glfw.ModShift == 0:
+			eng.Journal.Undo()
+		case key == glfw.KeyZ, key == glfw.KeyY:
+			eng.Journal.Redo()
+		}
+	})
+}
+
+func (eng *Engine) handleRenderKey(key glfw.Key) {
+	scaler := &eng.Renderer.Scaler
+	switch key {
+	case glfw.KeyF1:
+		eng.DenoiseEnabled = !eng.DenoiseEnabled
+		eng.Renderer.Pixels.EnableAOVs()
+		eng.Denoiser.Reset()
+	case glfw.KeyMinus:
+		eng.Renderer.SetScale(scaler.Scale - renderScaleInc)
+	case glfw.KeyEqual:
+		eng.Renderer.SetScale(scaler.Scale + renderScaleInc)
+	case glfw.KeyF2:
+		if scaler.Target > 0 {
+			scaler.Target = 0
+		} else {
+			scaler.Target = dynamicFrameTarget
+		}
+	case glfw.KeyF3:
+		eng.ProgressiveEnabled = !eng.ProgressiveEnabled
+		eng.Progressive.Reset()
+	case glfw.KeyF4:
+		eng.HDR.ToneMap = eng.HDR.ToneMap.Next()
+		fmt.Printf("Tone mapping: %v\n", eng.HDR.ToneMap)
+	case glfw.KeyF5:
+		eng.HDR.AutoExposure = !eng.HDR.AutoExposure
+	case glfw.KeyF6:
+		eng.HDR.Bloom = !eng.HDR.Bloom
+	case glfw.KeyF7:
+		eng.Camera.Ambient.Mode = eng.Camera.Ambient.Mode.Next()
+		fmt.Printf("Ambient occlusion: %v\n", eng.Camera.Ambient.Mode)
+	case glfw.KeyF8:
+		if eng.Voxels.Propagation() == nil {
+			eng.Voxels.EnablePropagation()
+		} else {
+			eng.Voxels.DisablePropagation()
+		}
+	case glfw.KeyF9:
+		if eng.Progressive.PathTrace == nil {
+			eng.Progressive.PathTrace = &eng.PathTracer
+			eng.ProgressiveEnabled = true
+		} else {
+			eng.Progressive.PathTrace = nil
+		}
+	case glfw.KeyF10:
+		env := &eng.Camera.Environment
+		env.Sky = env.Sky.Next()
+		if env.Sky == render.SkyImage && env.Skybox == nil {
+			env.Sky = env.Sky.Next()
+		}
+		fmt.Printf("Sky: %v\n", env.Sky)
+	case glfw.KeyF11:
+		if eng.Camera.Environment.Fog.Density > 0 {
+			eng.Camera.Environment.Fog.Density = 0
+		} else {
+			eng.Camera.Environment.Fog.Density = fogDensity
+		}
+	case glfw.KeyF12:
+		eng.Camera.Projection = eng.Camera.Projection.Next()
+		fmt.Printf("Projection: %v\n", eng.Camera.Projection)
+	case glfw.Key1, glfw.Key2, glfw.Key3, glfw.Key4, glfw.Key5, glfw.Key6, glfw.Key7, glfw.Key8, glfw.Key9:
+		i := int(key - glfw.Key1)
+		if i >= len(eng.Post.Stages) {
+			return
+		}
+		stage := &eng.Post.Stages[i]
+		stage.Enabled = !stage.Enabled
+		// Outlines find edges from the AOVs
+		eng.Renderer.Pixels.EnableAOVs()
+		fmt.Printf("Post effect %v: %v\n", stage.Effect.Name(), stage.Enabled)
+	case glfw.KeyV:
+		eng.Debug.View = eng.Debug.View.Next()
+		// Debug views draw over the pixels the accumulated renders build on
+		eng.Progressive.Reset()
+		eng.Denoiser.Reset()
+		fmt.Printf("Debug view: %v\n", eng.Debug.View)
+	case glfw.KeyG:
+		eng.Debug.Grid = !eng.Debug.Grid
+		eng.Renderer.Pixels.EnableAOVs()
+	case glfw.KeyC:
+		eng.Debug.Chunks = !eng.Debug.Chunks
+		eng.Renderer.Pixels.EnableAOVs()
+	case glfw.KeyPageUp:
+		eng.HDR.Exposure += exposureInc
+	case glfw.KeyPageDown:
+		eng.HDR.Exposure -= exposureInc
+	}
+}
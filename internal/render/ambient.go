@@ -0,0 +1,171 @@
+package render
+
+import (
+	"github.com/chewxy/math32"
+	te "github.com/zheskett/go-voxel/internal/tensor"
+	vxl "github.com/zheskett/go-voxel/internal/voxel"
+)
+
+type AOMode int
+
+const (
+	AONone AOMode = iota
+	// Darkens face corners by how many of the neighboring voxels are filled, nearly free
+	AOVertex
+	// Sends short rays over the hemisphere above the hit, slower but sees further
+	AOTraced
+	aoModeCount
+)
+
+func (mode AOMode) String() string {
+	switch mode {
+	case AONone:
+		return "none"
+	case AOVertex:
+		return "vertex"
+	case AOTraced:
+		return "traced"
+	}
+	return "unknown"
+}
+
+// AO mode F7 switches to, from none to vertex to traced and back to none
+func (mode AOMode) Next() AOMode {
+	return (mode + 1) % aoModeCount
+}
+
+// AO mode for an -ao value, false for anything but none, vertex and traced
+func ParseAOMode(name string) (AOMode, bool) {
+	for mode := range aoModeCount {
+		if mode.String() == name {
+			return mode, true
+		}
+	}
+	return AONone, false
+}
+
+// Ambient is light that comes from everywhere, so surfaces facing away
+// from every light aren't pure black. Ambient occlusion darkens it in corners,
+// along with the bounce light from Voxels.EnablePropagation
+type Ambient struct {
+	Color te.Vector3 // Linear, added before the voxel's color is applied
+	Mode  AOMode
+
+	// Length and number of rays for AOTraced
+	Radius  float32
+	Samples int
+}
+
+func AmbientInit() Ambient {
+	return Ambient{
+		Color:   te.Vec3(0.035, 0.04, 0.05),
+		Mode:    AOVertex,
+		Radius:  4,
+		Samples: 8,
+	}
+}
+
+// Ambient and propagated light reaching the hit, after occlusion. Safe to call on
+// a nil Ambient, which gives the propagated light alone
+func (amb *Ambient) Light(vox *vxl.Voxels, hit vxl.RayHit) te.Vector3 {
+	return amb.occludedLight(vox, hit, sampleShift(hit.Position))
+}
+
+func (amb *Ambient) occludedLight(vox *vxl.Voxels, hit vxl.RayHit, shift [2]float32) te.Vector3 {
+	// Sampled half a voxel out so it's the light in front of the face
+	light := vox.IndirectLight(hit.Position.Add(hit.Normal.Mul(0.5)))
+	if amb == nil {
+		return light
+	}
+	light = light.Add(amb.Color)
+	if light.Max() <= 0 {
+		return light
+	}
+	return light.Mul(amb.Occlusion(vox, hit, shift))
+}
+
+// Fraction of ambient light that reaches the hit, 1 out in the open and 0 fully enclosed.
+// shift picks the traced rays, see lightSamplePoint
+func (amb *Ambient) Occlusion(vox *vxl.Voxels, hit vxl.RayHit, shift [2]float32) float32 {
+	switch amb.Mode {
+	case AOVertex:
+		return VertexOcclusion(vox, hit)
+	case AOTraced:
+		return amb.tracedOcclusion(vox, hit, shift)
+	}
+	return 1
+}
+
+// Classic per corner voxel AO. Each corner of the hit face looks at the two edge
+// neighbors and the corner neighbor in front of the face, then the hit interpolates
+// between its four corners
+func VertexOcclusion(vox *vxl.Voxels, hit vxl.RayHit) float32 {
+	n := [3]int{int(hit.Normal.X), int(hit.Normal.Y), int(hit.Normal.Z)}
+	front := [3]int{hit.IntPos[0] + n[0], hit.IntPos[1] + n[1], hit.IntPos[2] + n[2]}
+	local := hit.Position.Sub(te.Vec3(float32(hit.IntPos[0]), float32(hit.IntPos[1]), float32(hit.IntPos[2])))
+	uvw := [3]float32{local.X, local.Y, local.Z}
+
+	// The two axes along the face, same as RayHit.FaceUV
+	var a, b int
+	switch {
+	case n[0] != 0:
+		a, b = 2, 1
+	case n[1] != 0:
+		a, b = 0, 2
+	default:
+		a, b = 0, 1
+	}
+	filled := func(da, db int) int {
+		p := front
+		p[a] += da
+		p[b] += db
+		if vox.Surrounds(p[0], p[1], p[2]) && vox.Presence.Get(vox.Index(p[0], p[1], p[2])) {
+			return 1
+		}
+		return 0
+	}
+
+	corner := [2][2]float32{}
+	for i := range 2 {
+		for j := range 2 {
+			da, db := 2*i-1, 2*j-1
+			side1, side2 := filled(da, 0), filled(0, db)
+			if side1 == 1 && side2 == 1 {
+				// Both edges filled hides the corner completely
+				continue
+			}
+			corner[i][j] = float32(3-side1-side2-filled(da, db)) / 3
+		}
+	}
+
+	u := math32.Min(math32.Max(uvw[a], 0), 1)
+	v := math32.Min(math32.Max(uvw[b], 0), 1)
+	bottom := corner[0][0]*(1-u) + corner[1][0]*u
+	top := corner[0][1]*(1-u) + corner[1][1]*u
+	return bottom*(1-v) + top*v
+}
+
+func (amb *Ambient) tracedOcclusion(vox *vxl.Voxels, hit vxl.RayHit, shift [2]float32) float32 {
+	samples := max(amb.Samples, 1)
+	open := 0
+	for i := range samples {
+		u, v := lightSamplePoint(i, shift)
+		ray := vxl.Ray{
+			Origin: hit.Position,
+			Dir:    cosineHemisphere(hit.Normal, u, v),
+			Tmax:   amb.Radius,
+		}
+		if !vox.Occluded(ray) {
+			open++
+		}
+	}
+	return float32(open) / float32(samples)
+}
+
+// Direction over the hemisphere around normal, more of them near the normal like a diffuse surface sees
+func cosineHemisphere(normal te.Vector3, u, v float32) te.Vector3 {
+	a, b := normal.Perpendiculars()
+	r, theta := math32.Sqrt(u), 2*math32.Pi*v
+	up := math32.Sqrt(math32.Max(0, 1-u))
+	return a.Mul(r * math32.Cos(theta)).Add(b.Mul(r * math32.Sin(theta))).Add(normal.Mul(up)).Normalized()
+}
@@ -0,0 +1,59 @@
+package render
+
+import (
+	"testing"
+
+	te "github.com/zheskett/go-voxel/internal/tensor"
+)
+
+// TestAmbientOcclusionCorner expects the floor next to a wall to be darker
+// than open floor with both kinds of AO, and to be unaffected with AO off.
+func TestAmbientOcclusionCorner(t *testing.T) {
+	vox := testWorld()
+	for y := range vox.Y {
+		for z := range vox.Z {
+			vox.SetVoxel(10, y, z, 200, 200, 200)
+		}
+	}
+	corner := floorHit(t, &vox, 11.05, 16.5)
+	open := floorHit(t, &vox, 20.5, 16.5)
+
+	amb := AmbientInit()
+	for _, mode := range []AOMode{AOVertex, AOTraced} {
+		amb.Mode = mode
+		amb.Samples = 32
+		shift := [2]float32{0.3, 0.6}
+		if got := amb.Occlusion(&vox, open, shift); got != 1 {
+			t.Errorf("%v: expected open floor to be unoccluded, got %v", mode, got)
+		}
+		if got := amb.Occlusion(&vox, corner, shift); got >= 0.9 || got <= 0 {
+			t.Errorf("%v: expected floor by the wall to be partly occluded, got %v", mode, got)
+		}
+	}
+
+	amb.Mode = AONone
+	if got := amb.Occlusion(&vox, corner, [2]float32{}); got != 1 {
+		t.Errorf("Expected no occlusion with AO off, got %v", got)
+	}
+}
+
+// TestAmbientLightsUnlitFaces expects a face no light can see to get the ambient color.
+func TestAmbientLightsUnlitFaces(t *testing.T) {
+	vox := testWorld()
+	vox.Lights = vox.Lights[:0]
+	hit := floorHit(t, &vox, 20.5, 16.5)
+	amb := AmbientInit()
+	if got := GetPixelShading(&vox, hit, 100, nil); got.Max() != 0 {
+		t.Errorf("Expected black without ambient light, got %v", got)
+	}
+	if got := GetPixelShading(&vox, hit, 100, &amb); got != amb.Color {
+		t.Errorf("Expected ambient color %v, got %v", amb.Color, got)
+	}
+	if got := GetVoxelShading(&vox, hit, 100, &amb); got != amb.Color {
+		t.Errorf("Expected ambient color %v per voxel, got %v", amb.Color, got)
+	}
+	amb.Color = te.Vec3Zero()
+	if got := GetPixelShading(&vox, hit, 100, &amb); got.Max() != 0 {
+		t.Errorf("Expected black with a zero ambient color, got %v", got)
+	}
+}
@@ -0,0 +1,227 @@
+package render
+
+import (
+	"github.com/chewxy/math32"
+	te "github.com/zheskett/go-voxel/internal/tensor"
+	vxl "github.com/zheskett/go-voxel/internal/voxel"
+)
+
+// AOV is one of the extra outputs AOVs keep for every pixel
+type AOV int
+
+const (
+	AOVDepth    AOV = iota // Linear depth, the distance in front of the camera along its view direction
+	AOVNormal              // World space normal of the hit face
+	AOVAlbedo              // Linear color of the hit voxel, before lighting
+	AOVPosition            // World space hit point
+	AOVVoxel               // Coordinates of the hit voxel
+	AOVMaterial            // Material of the hit voxel
+	aovCount
+)
+
+func (aov AOV) String() string {
+	switch aov {
+	case AOVDepth:
+		return "depth"
+	case AOVNormal:
+		return "normal"
+	case AOVAlbedo:
+		return "albedo"
+	case AOVPosition:
+		return "position"
+	case AOVVoxel:
+		return "voxel"
+	case AOVMaterial:
+		return "material"
+	}
+	return "unknown"
+}
+
+// Output for one of the comma separated names -aovs takes, false if it names none
+func ParseAOV(name string) (AOV, bool) {
+	for aov := range aovCount {
+		if aov.String() == name {
+			return aov, true
+		}
+	}
+	return AOVDepth, false
+}
+
+// AOVs hold what the camera ray of every pixel hit, filled in alongside the color by
+// RenderVoxels and Progressive. See Pixels.EnableAOVs
+type AOVs struct {
+	Width  int
+	Height int
+
+	depth    []float32 // Camera.viewDepth of the hit, +Inf for misses
+	normal   []float32 // 3 floats per pixel, like the rest
+	albedo   []float32
+	position []float32
+	voxel    []int32 // -1 for misses
+	material []vxl.MaterialID
+}
+
+// AOVSample is everything AOVs know about one pixel
+type AOVSample struct {
+	Hit      bool
+	Depth    float32 // +Inf for misses
+	Normal   te.Vector3
+	Albedo   te.Vector3
+	Position te.Vector3
+	Voxel    [3]int
+	Material vxl.MaterialID
+}
+
+func AOVsInit(width, height int) AOVs {
+	aov := AOVs{
+		Width:    width,
+		Height:   height,
+		depth:    make([]float32, width*height),
+		normal:   make([]float32, width*height*3),
+		albedo:   make([]float32, width*height*3),
+		position: make([]float32, width*height*3),
+		voxel:    make([]int32, width*height*3),
+		material: make([]vxl.MaterialID, width*height),
+	}
+	aov.Clear()
+	return aov
+}
+
+// Marks every pixel as a miss
+func (aov *AOVs) Clear() {
+	for i := range aov.depth {
+		aov.setMiss(i)
+	}
+}
+
+// Everything about the pixel at x, y
+func (aov *AOVs) At(x, y int) AOVSample {
+	i := aov.Width*y + x
+	if aov.voxel[3*i] < 0 {
+		return AOVSample{Depth: math32.Inf(1)}
+	}
+	return AOVSample{
+		Hit:      true,
+		Depth:    aov.depth[i],
+		Normal:   te.Vec3(aov.normal[3*i], aov.normal[3*i+1], aov.normal[3*i+2]),
+		Albedo:   te.Vec3(aov.albedo[3*i], aov.albedo[3*i+1], aov.albedo[3*i+2]),
+		Position: te.Vec3(aov.position[3*i], aov.position[3*i+1], aov.position[3*i+2]),
+		Voxel:    [3]int{int(aov.voxel[3*i]), int(aov.voxel[3*i+1]), int(aov.voxel[3*i+2])},
+		Material: aov.material[i],
+	}
+}
+
+// Distance to what the pixel at x, y sees, +Inf for misses
+func (aov *AOVs) Depth(x, y int) float32 {
+	return aov.depth[aov.Width*y+x]
+}
+
+// Records what the ray through the pixel at x, y hit
+func (aov *AOVs) set(cam *Camera, vox *vxl.Voxels, x, y int, hit vxl.RayHit) {
+	i := aov.Width*y + x
+	if !hit.Hit {
+		aov.setMiss(i)
+		return
+	}
+	albedo := SRGBColorToLinear(hit.Color)
+	aov.depth[i] = cam.viewDepth(hit.Position)
+	aov.normal[3*i], aov.normal[3*i+1], aov.normal[3*i+2] = hit.Normal.Elms()
+	aov.albedo[3*i], aov.albedo[3*i+1], aov.albedo[3*i+2] = albedo.Elms()
+	aov.position[3*i], aov.position[3*i+1], aov.position[3*i+2] = hit.Position.Elms()
+	aov.voxel[3*i], aov.voxel[3*i+1], aov.voxel[3*i+2] = int32(hit.IntPos[0]), int32(hit.IntPos[1]), int32(hit.IntPos[2])
+	aov.material[i] = vox.Material(hit.Index)
+}
+
+func (aov *AOVs) setMiss(i int) {
+	aov.depth[i] = math32.Inf(1)
+	clear(aov.normal[3*i : 3*i+3])
+	clear(aov.albedo[3*i : 3*i+3])
+	clear(aov.position[3*i : 3*i+3])
+	aov.voxel[3*i], aov.voxel[3*i+1], aov.voxel[3*i+2] = -1, -1, -1
+	aov.material[i] = vxl.MaterialDiffuse
+}
+
+// One of the outputs as an image, to save with SaveImage. The HDR buffer has the raw
+// values, zero for misses, and the bytes are scaled to be viewable
+func (aov *AOVs) Image(kind AOV) Pixels {
+	pix := PixelsInitHDR(aov.Width, aov.Height)
+	// Depth and position are scaled by the furthest value in the image
+	far := float32(0)
+	for i := range aov.depth {
+		if aov.voxel[3*i] < 0 {
+			continue
+		}
+		far = max(far, aov.depth[i], math32.Abs(aov.position[3*i]), math32.Abs(aov.position[3*i+1]), math32.Abs(aov.position[3*i+2]))
+	}
+	far = max(far, 1e-6)
+
+	for y := range aov.Height {
+		for x := range aov.Width {
+			sample := aov.At(x, y)
+			if !sample.Hit {
+				continue
+			}
+			var raw, shown te.Vector3
+			switch kind {
+			case AOVDepth:
+				raw = te.Vec3Splat(sample.Depth)
+				shown = te.Vec3Splat(1 - sample.Depth/far)
+			case AOVNormal:
+				raw = sample.Normal
+				shown = sample.Normal.Add(te.Vec3Splat(1)).Mul(0.5)
+			case AOVAlbedo:
+				pix.SetPixelLinear(x, y, sample.Albedo)
+				continue
+			case AOVPosition:
+				raw = sample.Position
+				shown = sample.Position.Div(far)
+			case AOVVoxel:
+				raw = te.Vec3(float32(sample.Voxel[0]), float32(sample.Voxel[1]), float32(sample.Voxel[2]))
+				shown = idColor(uint32(sample.Voxel[0]) | uint32(sample.Voxel[1])<<10 | uint32(sample.Voxel[2])<<20)
+			case AOVMaterial:
+				raw = te.Vec3Splat(float32(sample.Material))
+				shown = idColor(uint32(sample.Material) + 1)
+			}
+			pix.SetPixelHDR(x, y, raw)
+			shown = shown.ComponentClamp(0, 1)
+			pix.SetPixel(x, y, byte(shown.X*255+0.5), byte(shown.Y*255+0.5), byte(shown.Z*255+0.5))
+		}
+	}
+	return pix
+}
+
+// A bright color that's different for nearby ids
+func idColor(id uint32) te.Vector3 {
+	// Knuth's multiplicative hash, the high bits are the well mixed ones
+	h := id * 2654435761
+	return te.Vec3(
+		0.3+0.7*float32((h>>24)&0xff)/255,
+		0.3+0.7*float32((h>>16)&0xff)/255,
+		0.3+0.7*float32((h>>8)&0xff)/255,
+	)
+}
+
+// Fills in aov without shading anything, much faster than rendering when only the AOVs are needed
+func (cam *Camera) RenderAOVs(vox *vxl.Voxels, aov *AOVs) {
+	basis := cameraRayBasis(cam, aov.Width, aov.Height)
+	DefaultScheduler().RenderTiles(aov.Width, aov.Height, func(x0, y0, x1, y1 int) {
+		for row := y0; row < y1; row++ {
+			for column := x0; column < x1; column++ {
+				aov.set(cam, vox, column, row, vox.MarchRay(cam.getPixelRay(column, row, basis)))
+			}
+		}
+	})
+}
+
+// Keeps AOVs for every pixel from now on, filled in by the renderers
+func (px *Pixels) EnableAOVs() {
+	if px.aov == nil {
+		aov := AOVsInit(px.Width, px.Height)
+		px.aov = &aov
+	}
+}
+
+// The pixels' AOVs, nil unless EnableAOVs was called
+func (px *Pixels) AOVs() *AOVs {
+	return px.aov
+}
@@ -0,0 +1,61 @@
+package render
+
+import (
+	"testing"
+
+	"github.com/chewxy/math32"
+	te "github.com/zheskett/go-voxel/internal/tensor"
+	vxl "github.com/zheskett/go-voxel/internal/voxel"
+)
+
+// TestAOVs expects every renderer to record what each pixel's ray hit, and
+// misses to be marked as such.
+func TestAOVs(t *testing.T) {
+	vox := testWorld()
+	vox.SetVoxel(16, 1, 16, 255, 0, 0)
+	vox.SetMaterial(16, 1, 16, vxl.MaterialMetal)
+	pix := PixelsInitHDR(16, 16)
+	pix.EnableAOVs()
+	cam := testCamera(&pix)
+	cam.Pos = te.Vec3(16.5, 10, 16.5)
+	cam.SetRotationFPS(math32.Pi/2, 0)
+
+	check := func(name string, aov *AOVs) {
+		center := aov.At(8, 8)
+		if !center.Hit || center.Voxel != [3]int{16, 1, 16} || center.Material != vxl.MaterialMetal {
+			t.Errorf("%v: expected the metal voxel in the middle, got %+v", name, center)
+		}
+		if !closeToVec(center.Normal, te.Vec3Y(), 1e-5) || math32.Abs(center.Depth-8) > 0.1 {
+			t.Errorf("%v: expected the top of the voxel 8 away, got %+v", name, center)
+		}
+		if !closeToVec(center.Albedo, te.Vec3(1, 0, 0), 1e-5) {
+			t.Errorf("%v: expected a red albedo, got %v", name, center.Albedo)
+		}
+		// Linear depth, along the view direction rather than the ray, which is further
+		floor := aov.At(2, 2)
+		offset := floor.Position.Sub(cam.Pos)
+		if !floor.Hit || floor.Voxel[1] != 0 || math32.Abs(floor.Depth-offset.Dot(cam.Fvec)) > 1e-3 || offset.Len()-floor.Depth < 0.5 {
+			t.Errorf("%v: expected the floor's linear depth at the corner, got %+v", name, floor)
+		}
+	}
+	cam.RenderVoxels(&vox, &pix)
+	check("RenderVoxels", pix.AOVs())
+
+	pg := ProgressiveInit()
+	pix.AOVs().Clear()
+	pg.Render(&cam, &vox, &pix)
+	check("Progressive", pix.AOVs())
+
+	only := AOVsInit(16, 16)
+	cam.RenderAOVs(&vox, &only)
+	check("RenderAOVs", &only)
+
+	cam.SetRotationFPS(-math32.Pi/2, 0)
+	cam.RenderAOVs(&vox, &only)
+	if sky := only.At(8, 8); sky.Hit || !math32.IsInf(sky.Depth, 1) {
+		t.Errorf("Expected a miss looking up, got %+v", sky)
+	}
+	if img := only.Image(AOVDepth); img.GetPixel(8, 8) != [3]byte{} {
+		t.Errorf("Expected misses to be black, got %v", img.GetPixel(8, 8))
+	}
+}
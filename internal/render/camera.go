@@ -2,10 +2,7 @@
 package render
 
 import (
-	"sync"
-
 	"github.com/chewxy/math32"
-	"github.com/go-gl/glfw/v3.3/glfw"
 	te "github.com/zheskett/go-voxel/internal/tensor"
 	vxl "github.com/zheskett/go-voxel/internal/voxel"
 )
@@ -19,8 +16,16 @@ type CameraRayBasis struct {
 }
 
 func CameraRayBasisInit(cam *Camera, pix *Pixels) CameraRayBasis {
+	return cameraRayBasis(cam, pix.Width, pix.Height)
+}
+
+func cameraRayBasis(cam *Camera, width, height int) CameraRayBasis {
 	scale := math32.Tan(cam.Fov * math32.Pi / 360.0)
-	hh, hw := float32(pix.Height/2), float32(pix.Width/2)
+	if cam.Projection == ProjectOrthographic {
+		// Offsets of the ray's origin instead of its direction
+		scale = cam.OrthoHeight / 2
+	}
+	hh, hw := float32(height/2), float32(width/2)
 
 	dcamrdx := cam.Rvec.Mul(scale * cam.Aspect)
 	dcamudy := cam.Uvec.Mul(scale)
@@ -39,6 +44,16 @@ type Camera struct {
 	Fov            float32
 	Aspect         float32
 	RenderDistance float32
+	Ambient        Ambient
+	Environment    Environment // Sky and fog
+	MaxReflections int         // Bounces followed off metal and through glass per pixel
+
+	Projection  Projection
+	OrthoHeight float32 // Height of the view in voxels for ProjectOrthographic
+	// Radius of the lens for depth of field, 0 keeps everything sharp. Only the
+	// progressive renderer samples the lens, the fast one always looks through its center
+	Aperture      float32
+	FocusDistance float32 // Distance in front of the camera that's in focus
 
 	Pitch float32
 	Yaw   float32
@@ -53,6 +68,12 @@ func CameraInit() Camera {
 
 		Yaw:   0.0,
 		Pitch: math32.Pi / 4.0,
+
+		Ambient:        AmbientInit(),
+		Environment:    EnvironmentInit(),
+		MaxReflections: 4,
+		OrthoHeight:    64,
+		FocusDistance:  16,
 	}
 }
 
@@ -68,9 +89,13 @@ func (cam *Camera) UpdateRotation(rx, ry, rz float32, frame *FrameData) {
 
 // These are really messy and will be cleaned up eventually I swear
 func (cam *Camera) UpdateRotationFPS(pitch, yaw float32) {
-	cam.Pitch += pitch * cam.Lookspeed
-	cam.Yaw += yaw * cam.Lookspeed
-	cam.Pitch = math32.Min(math32.Max(cam.Pitch, -math32.Pi/2*0.99), math32.Pi/2*0.99)
+	cam.SetRotationFPS(cam.Pitch+pitch*cam.Lookspeed, cam.Yaw+yaw*cam.Lookspeed)
+}
+
+// Points the camera at the given pitch and yaw in radians, without rolling
+func (cam *Camera) SetRotationFPS(pitch, yaw float32) {
+	cam.Pitch = math32.Min(math32.Max(pitch, -math32.Pi/2*0.99), math32.Pi/2*0.99)
+	cam.Yaw = yaw
 
 	front := te.Rotate3DY(cam.Yaw).Mul(te.Rotate3DX(cam.Pitch)).MulVec(te.Vec3Z())
 	right := front.Cross(cam.Wupvec)
@@ -99,119 +124,26 @@ func (cam *Camera) UpdatePositionFPS(dx, dy, dz float32, frame *FrameData) {
 }
 
 func (cam *Camera) getPixelRay(column int, row int, basis CameraRayBasis) vxl.Ray {
-	dx, dy := float32(column)+0.5, float32(row)+0.5
-
-	ndcx := (dx - basis.halfwidth) / basis.halfwidth
-	ndcy := -(dy - basis.halfheight) / basis.halfheight
-
-	// This is effectively finding the ray that points to that specific pixel
-	dcamr := basis.drdx.Mul(ndcx)
-	dcamu := basis.dudy.Mul(ndcy)
-	raydirec := (cam.Fvec.Add(dcamr).Add(dcamu)).Normalized()
-	return vxl.Ray{
-		Origin: cam.Pos,
-		Dir:    raydirec,
-		Tmax:   cam.RenderDistance, // Max distance a ray can travel before terminating
-	}
+	return cam.getSubpixelRay(float32(column)+0.5, float32(row)+0.5, basis)
 }
 
-func (cam *Camera) RenderVoxels(vox *vxl.Voxels, pix *Pixels) {
-	basis := CameraRayBasisInit(cam, pix)
-	// Shouldn't be here, but tbh light info shouldn't be in the Voxel struct at all probably
-	vox.LightCached.Clear()
-
-	// Iterate and spawn a thread for each row of the pixel buffer
-	threads := sync.WaitGroup{}
-	for row := 0; row < pix.Height; row++ {
-		threads.Go(func() {
-			// Iterate each column of the pixel row
-			for column := 0; column < pix.Width; column++ {
-				ray := cam.getPixelRay(column, row, basis)
-
-				hit := vox.MarchRay(ray)
-				if hit.Hit {
-					color := te.Vec3(float32(hit.Color[0]), float32(hit.Color[1]), float32(hit.Color[2]))
-
-					/* Two choices for lighting, doing it per pixel or per voxel */
-					shadedintensity := GetPixelShading(vox, hit, cam.RenderDistance)
-					// shadedintensity := GetVoxelShading(vox, hit, cam.RenderDistance)
-
-					shadedcolor := shadedintensity.MulComponent(color).ComponentMin(255.0)
-					pix.SetPixel(column, row, byte(shadedcolor.X), byte(shadedcolor.Y), byte(shadedcolor.Z))
-				}
-			}
-		})
-	}
-	threads.Wait()
+// Same as getPixelRay, but through any point of the screen, in pixels from the top left
+func (cam *Camera) getSubpixelRay(dx, dy float32, basis CameraRayBasis) vxl.Ray {
+	return cam.projectRay(dx, dy, basis, 0, 0)
 }
 
-func UpdateCamInputGLFW(cam *Camera, window *glfw.Window, frame *FrameData) {
-	rx, ry, rz := 0, 0, 0
-	tx, ty, tz := 0, 0, 0
-	if window.GetKey(glfw.KeyW) == glfw.Press {
-		tz++
-	}
-	if window.GetKey(glfw.KeyS) == glfw.Press {
-		tz--
-	}
-	if window.GetKey(glfw.KeyA) == glfw.Press {
-		tx--
-	}
-	if window.GetKey(glfw.KeyD) == glfw.Press {
-		tx++
-	}
-	if window.GetKey(glfw.KeySpace) == glfw.Press {
-		ty--
-	}
-	if window.GetKey(glfw.KeyLeftShift) == glfw.Press {
-		ty++
-	}
-	if window.GetKey(glfw.KeyUp) == glfw.Press {
-		rx++
-	}
-	if window.GetKey(glfw.KeyDown) == glfw.Press {
-		rx--
-	}
-	if window.GetKey(glfw.KeyRight) == glfw.Press {
-		ry++
-	}
-	if window.GetKey(glfw.KeyLeft) == glfw.Press {
-		ry--
-	}
-	if window.GetKey(glfw.KeyQ) == glfw.Press {
-		rz--
-	}
-	if window.GetKey(glfw.KeyE) == glfw.Press {
-		rz++
-	}
-	cam.UpdateRotation(float32(rx), float32(ry), float32(rz), frame)
-	cam.UpdatePosition(float32(tx), float32(ty), float32(tz), frame)
+// Renders the voxels into pix on the default tile scheduler
+func (cam *Camera) RenderVoxels(vox *vxl.Voxels, pix *Pixels) {
+	DefaultScheduler().Render(cam, vox, pix)
 }
 
-func UpdateCamInputGLFWFPS(cam *Camera, window *glfw.Window, frame *FrameData) {
-	tx, ty, tz := 0, 0, 0
-	if window.GetKey(glfw.KeyW) == glfw.Press {
-		tz++
-	}
-	if window.GetKey(glfw.KeyS) == glfw.Press {
-		tz--
-	}
-	if window.GetKey(glfw.KeyA) == glfw.Press {
-		tx--
-	}
-	if window.GetKey(glfw.KeyD) == glfw.Press {
-		tx++
-	}
-	if window.GetKey(glfw.KeySpace) == glfw.Press {
-		ty--
-	}
-	if window.GetKey(glfw.KeyLeftShift) == glfw.Press {
-		ty++
-	}
-	if window.GetKey(glfw.KeyT) == glfw.Press {
-		window.SetInputMode(glfw.CursorMode, glfw.CursorNormal)
+// Traces and shades a single pixel, and fills in its AOVs if pix has them
+func (cam *Camera) renderPixel(vox *vxl.Voxels, pix *Pixels, basis CameraRayBasis, column, row int) {
+	sh := cam.shader(vox)
+	ray := cam.getPixelRay(column, row, basis)
+	hit := vox.MarchRay(ray)
+	pix.SetPixelLinear(column, row, sh.light(ray, hit, 0))
+	if pix.aov != nil {
+		pix.aov.set(cam, vox, column, row, hit)
 	}
-	dx, dy := frame.GetMouseDelta(window)
-	cam.UpdateRotationFPS(dy, dx)
-	cam.UpdatePositionFPS(float32(tx), float32(ty), float32(tz), frame)
 }
@@ -0,0 +1,77 @@
+package render
+
+import (
+	"sync"
+	"testing"
+
+	te "github.com/zheskett/go-voxel/internal/tensor"
+	vxl "github.com/zheskett/go-voxel/internal/voxel"
+)
+
+func testWorld() vxl.Voxels {
+	vox := vxl.VoxelsInit(32, 32, 32)
+	for i := range vox.X {
+		for j := range vox.Z {
+			vox.SetVoxel(i, 0, j, 200, 200, 200)
+		}
+	}
+	vox.Lights = append(vox.Lights, vxl.Light{Position: te.Vec3(16, 20, 16), Color: te.Vec3Splat(500)})
+	return vox
+}
+
+func testCamera(pix *Pixels) Camera {
+	cam := CameraInit()
+	cam.Fov = 90
+	cam.Aspect = float32(pix.Width) / float32(pix.Height)
+	cam.Pos = te.Vec3(16, 10, 2)
+	cam.RenderDistance = 64
+	cam.UpdateRotationFPS(0, 0)
+	return cam
+}
+
+// TestConcurrentRenderAndEdit renders frames while another goroutine submits
+// edits, and fills the per-voxel lighting cache from every render thread.
+// Run with -race to check the concurrency model.
+func TestConcurrentRenderAndEdit(t *testing.T) {
+	vox := testWorld()
+	journal := vxl.JournalInit(&vox)
+	pix := PixelsInit(32, 24)
+	cam := testCamera(&pix)
+
+	done := make(chan struct{})
+	editor := sync.WaitGroup{}
+	editor.Go(func() {
+		for i := 0; ; i++ {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			x, z := i%vox.X, (i/vox.X)%vox.Z
+			journal.Submit(func(j *vxl.Journal) { j.SetVoxel(x, 1, z, 255, 0, 0) })
+		}
+	})
+
+	for range 8 {
+		cam.RenderVoxels(&vox, &pix)
+
+		threads := sync.WaitGroup{}
+		for row := range pix.Height {
+			threads.Go(func() {
+				basis := CameraRayBasisInit(&cam, &pix)
+				for column := range pix.Width {
+					hit := vox.MarchRay(cam.getPixelRay(column, row, basis))
+					if hit.Hit {
+						GetVoxelShading(&vox, hit, cam.RenderDistance, nil)
+					}
+				}
+			})
+		}
+		threads.Wait()
+
+		journal.ApplyPending()
+	}
+	close(done)
+	editor.Wait()
+	journal.ApplyPending()
+}
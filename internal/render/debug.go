@@ -0,0 +1,210 @@
+package render
+
+import (
+	"github.com/chewxy/math32"
+	te "github.com/zheskett/go-voxel/internal/tensor"
+	vxl "github.com/zheskett/go-voxel/internal/voxel"
+)
+
+// DebugView replaces the shaded frame with something that's easier to tune the renderer by
+type DebugView int
+
+const (
+	DebugOff        DebugView = iota
+	DebugSteps                // Heatmap of the cells MarchRay stepped through, misses included
+	DebugNormals              // Normal of the hit face, each axis mapped to [0, 1]
+	DebugDepth                // Brighter is closer, black past the render distance
+	DebugLighting             // Per-voxel lighting on the left half, per-pixel on the right
+	DebugLightCache           // Per-voxel lighting, green where it came from the cache and red where it didn't
+	debugViewCount
+)
+
+func (view DebugView) String() string {
+	switch view {
+	case DebugOff:
+		return "off"
+	case DebugSteps:
+		return "steps"
+	case DebugNormals:
+		return "normals"
+	case DebugDepth:
+		return "depth"
+	case DebugLighting:
+		return "lighting"
+	case DebugLightCache:
+		return "cache"
+	}
+	return "unknown"
+}
+
+// View V switches to, off comes after the light cache view
+func (view DebugView) Next() DebugView {
+	return (view + 1) % debugViewCount
+}
+
+// View for a -debug value, where "off" renders normally
+func ParseDebugView(name string) (DebugView, bool) {
+	for view := range debugViewCount {
+		if view.String() == name {
+			return view, true
+		}
+	}
+	return DebugOff, false
+}
+
+// Debug has what to show instead of the shaded frame, and what to draw over whatever is shown
+type Debug struct {
+	View     DebugView
+	Grid     bool // Wireframe of the voxel grid over every face
+	Chunks   bool // Boundaries of the world's chunks, vxl.WorldChunkSize voxels a side
+	MaxSteps int  // Step count the heatmap tops out at
+}
+
+func DebugInit() Debug {
+	return Debug{MaxSteps: 256}
+}
+
+// Whether the overlays need the frame's AOVs
+func (dbg *Debug) HasOverlay() bool {
+	return dbg.Grid || dbg.Chunks
+}
+
+// Renders View into pix, straight into its bytes so tone mapping shouldn't be run after.
+// Fills in the AOVs if pix has them, for the overlays
+func (dbg *Debug) Render(cam *Camera, vox *vxl.Voxels, pix *Pixels) {
+	basis := CameraRayBasisInit(cam, pix)
+	vox.UpdateLightCache()
+	vox.UpdatePropagation()
+
+	DefaultScheduler().RenderTiles(pix.Width, pix.Height, func(x0, y0, x1, y1 int) {
+		for row := y0; row < y1; row++ {
+			for column := x0; column < x1; column++ {
+				ray := cam.getPixelRay(column, row, basis)
+				hit := vox.MarchRay(ray)
+				c := dbg.shade(cam, vox, hit, column < pix.Width/2)
+				if column == pix.Width/2 && dbg.View == DebugLighting {
+					c = te.Vec3Splat(1)
+				}
+				pix.SetPixelHDR(column, row, c)
+				setPixelFloat(pix, column, row, c)
+				if pix.aov != nil {
+					pix.aov.set(cam, vox, column, row, hit)
+				}
+			}
+		}
+	})
+}
+
+// Display color of one pixel of the view, left is whether it's on the left half of the frame
+func (dbg *Debug) shade(cam *Camera, vox *vxl.Voxels, hit vxl.RayHit, left bool) te.Vector3 {
+	if dbg.View == DebugSteps {
+		return heat(float32(hit.Steps) / float32(max(dbg.MaxSteps, 1)))
+	}
+	if !hit.Hit {
+		return te.Vec3Zero()
+	}
+	albedo := SRGBColorToLinear(hit.Color)
+	switch dbg.View {
+	case DebugNormals:
+		return hit.Normal.Add(te.Vec3Splat(1)).Mul(0.5)
+	case DebugDepth:
+		return te.Vec3Splat(1 - hit.Time/cam.RenderDistance)
+	case DebugLighting:
+		var light te.Vector3
+		if left {
+			light = GetVoxelShading(vox, hit, cam.RenderDistance, &cam.Ambient)
+		} else {
+			light = GetPixelShading(vox, hit, cam.RenderDistance, &cam.Ambient)
+		}
+		return linearToDisplay(light.MulComponent(albedo))
+	case DebugLightCache:
+		cached := vox.LightCached.Get(hit.Index)
+		light := GetVoxelShading(vox, hit, cam.RenderDistance, &cam.Ambient)
+		// Gray from the lighting, so shadows still show through the tint
+		gray := linearToDisplay(te.Vec3Splat(luminance(light.MulComponent(albedo).Elms()))).X
+		tint := te.Vec3(1, 0.25, 0.25)
+		if cached {
+			tint = te.Vec3(0.25, 1, 0.25)
+		}
+		return tint.Mul(0.25 + 0.75*gray)
+	}
+	return te.Vec3Zero()
+}
+
+// sRGB encoded, clamped to [0, 1]
+func linearToDisplay(c te.Vector3) te.Vector3 {
+	return te.Vec3(float32(LinearToSRGB(c.X)), float32(LinearToSRGB(c.Y)), float32(LinearToSRGB(c.Z))).Div(255)
+}
+
+// Colors along the heatmap, cold to hot
+var heatStops = [...]te.Vector3{
+	{X: 0, Y: 0, Z: 0.3},
+	{X: 0, Y: 0.5, Z: 1},
+	{X: 0, Y: 1, Z: 0.5},
+	{X: 1, Y: 1, Z: 0},
+	{X: 1, Y: 0, Z: 0},
+}
+
+// Heatmap color of t in [0, 1], anything over 1 is as hot as 1
+func heat(t float32) te.Vector3 {
+	t = min(max(t, 0), 1) * float32(len(heatStops)-1)
+	i := min(int(t), len(heatStops)-2)
+	return mix(heatStops[i], heatStops[i+1], t-float32(i))
+}
+
+// Draws the grid and chunk overlays over the bytes of pix, using aov to find where the
+// lines go. Does nothing without AOVs
+func (dbg *Debug) Overlay(cam *Camera, pix *Pixels, aov *AOVs) {
+	if !dbg.HasOverlay() || aov == nil {
+		return
+	}
+	// Size of a pixel one voxel in front of the camera
+	pixel := math32.Tan(cam.Fov*math32.Pi/360) / float32(pix.Height/2)
+	switch cam.Projection {
+	case ProjectOrthographic:
+		pixel = cam.OrthoHeight / float32(pix.Height)
+	case ProjectPanorama, ProjectCubeMap:
+		pixel = math32.Pi / float32(pix.Height)
+	}
+	gridColor, chunkColor := te.Vec3Splat(0), te.Vec3(1, 0.85, 0)
+
+	DefaultScheduler().RenderTiles(pix.Width, pix.Height, func(x0, y0, x1, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				sample := aov.At(x, y)
+				if !sample.Hit {
+					continue
+				}
+				footprint := pixel
+				if cam.Projection != ProjectOrthographic {
+					footprint *= sample.Depth
+				}
+				b := pix.GetPixel(x, y)
+				c := te.Vec3(float32(b[0]), float32(b[1]), float32(b[2])).Div(255)
+				switch {
+				case dbg.Chunks && onGridLine(sample, vxl.WorldChunkSize, 2*footprint):
+					setPixelFloat(pix, x, y, chunkColor)
+				// Past half a voxel per pixel the lines would cover everything
+				case dbg.Grid && footprint < 0.5 && onGridLine(sample, 1, footprint):
+					setPixelFloat(pix, x, y, mix(c, gridColor, 0.6))
+				}
+			}
+		}
+	})
+}
+
+// Whether the hit lies within width/2 of a line of a grid with the given spacing,
+// on the plane of the face it hit
+func onGridLine(sample AOVSample, spacing, width float32) bool {
+	p, n := [3]float32{sample.Position.X, sample.Position.Y, sample.Position.Z}, [3]float32{sample.Normal.X, sample.Normal.Y, sample.Normal.Z}
+	for axis := range 3 {
+		if math32.Abs(n[axis]) > 0.5 {
+			continue
+		}
+		cell := p[axis] / spacing
+		if math32.Abs(cell-math32.Round(cell))*spacing < width/2 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,103 @@
+package render
+
+import (
+	"testing"
+
+	"github.com/chewxy/math32"
+	te "github.com/zheskett/go-voxel/internal/tensor"
+	vxl "github.com/zheskett/go-voxel/internal/voxel"
+)
+
+// TestDebugViews expects each view to show what it's named after, looking straight
+// down at the floor.
+func TestDebugViews(t *testing.T) {
+	vox := testWorld()
+	pix := PixelsInitHDR(16, 16)
+	cam := testCamera(&pix)
+	cam.Pos = te.Vec3(16.5, 10, 16.5)
+	cam.SetRotationFPS(math32.Pi/2, 0)
+	dbg := DebugInit()
+
+	dbg.View = DebugNormals
+	dbg.Render(&cam, &vox, &pix)
+	if got := pix.GetPixel(8, 8); got != [3]byte{128, 255, 128} {
+		t.Errorf("Expected an up facing normal's color, got %v", got)
+	}
+
+	dbg.View = DebugDepth
+	dbg.Render(&cam, &vox, &pix)
+	if middle, corner := pix.GetPixel(8, 8), pix.GetPixel(0, 0); middle[0] <= corner[0] {
+		t.Errorf("Expected the nearer middle brighter than the corner, got %v and %v", middle, corner)
+	}
+
+	dbg.View = DebugSteps
+	dbg.Render(&cam, &vox, &pix)
+	hit := vox.MarchRay(cam.getPixelRay(0, 0, CameraRayBasisInit(&cam, &pix)))
+	want := heat(float32(hit.Steps) / float32(dbg.MaxSteps))
+	if got := pix.GetPixelHDR(0, 0); hit.Steps == 0 || !closeToVec(got, want, 1e-5) {
+		t.Errorf("Expected the heat of %v steps, %v, got %v", hit.Steps, want, got)
+	}
+
+	dbg.View = DebugLightCache
+	vox.ClearLightCache()
+	dbg.Render(&cam, &vox, &pix)
+	dbg.Render(&cam, &vox, &pix)
+	for y := range pix.Height {
+		for x := range pix.Width {
+			if c := pix.GetPixel(x, y); c[1] <= c[0] {
+				t.Fatalf("Expected everything cached by the second frame, %v, %v is %v", x, y, c)
+			}
+		}
+	}
+
+	dbg.View = DebugLighting
+	dbg.Render(&cam, &vox, &pix)
+	if got := pix.GetPixel(pix.Width/2, 3); got != [3]byte{255, 255, 255} {
+		t.Errorf("Expected a divider between the halves, got %v", got)
+	}
+}
+
+// TestDebugOverlay expects chunk boundaries and grid lines drawn where the floor
+// crosses them.
+func TestDebugOverlay(t *testing.T) {
+	vox := vxl.VoxelsInit(64, 8, 64)
+	for x := range vox.X {
+		for z := range vox.Z {
+			vox.SetVoxel(x, 0, z, 200, 200, 200)
+		}
+	}
+	pix := PixelsInit(64, 64)
+	cam := testCamera(&pix)
+	cam.Pos = te.Vec3(32.25, 9, 20.5)
+	cam.SetRotationFPS(math32.Pi/2, 0)
+	aov := AOVsInit(pix.Width, pix.Height)
+	cam.RenderAOVs(&vox, &aov)
+	pix.FillPixels(255, 255, 255)
+
+	dbg := DebugInit()
+	dbg.Grid, dbg.Chunks = true, true
+	dbg.Overlay(&cam, &pix, &aov)
+	grid := 0
+	for y := range pix.Height {
+		chunk := false
+		for x := range pix.Width {
+			switch pix.GetPixel(x, y) {
+			case [3]byte{255, 217, 0}:
+				chunk = true
+				if p := aov.At(x, y).Position.X; math32.Abs(p-32) > 0.5 {
+					t.Errorf("Expected chunk lines only at x = 32, got one at %v", p)
+				}
+			case [3]byte{255, 255, 255}:
+			default:
+				grid++
+			}
+		}
+		if !chunk {
+			t.Errorf("Expected the chunk boundary across row %v", y)
+		}
+	}
+	// Lines every voxel, 16 or so across the view each way
+	if grid < 2*16*pix.Height/4 {
+		t.Errorf("Expected grid lines, only %v pixels were drawn on", grid)
+	}
+}
@@ -0,0 +1,286 @@
+package render
+
+import (
+	"github.com/chewxy/math32"
+	te "github.com/zheskett/go-voxel/internal/tensor"
+	vxl "github.com/zheskett/go-voxel/internal/voxel"
+)
+
+// Weights of the 5 tap B3 spline the à-trous filter spreads out, from the middle out
+var atrousKernel = [3]float32{3.0 / 8.0, 1.0 / 4.0, 1.0 / 16.0}
+
+// Denoiser smooths the noise of soft shadows, traced ambient occlusion and path tracing
+// out of a frame, using its AOVs to keep from blurring across edges. Every frame is first
+// mixed with the ones before it, moved to where they'd be seen from the camera now, then
+// blurred by an edge-aware à-trous filter, like Schied et al's SVGF.
+// It works on the lighting alone, with the voxel colors divided out so they stay sharp
+type Denoiser struct {
+	// À-trous passes, each one reaching twice as far as the one before. 0 turns the blur off
+	Iterations int
+	// How strongly differences in lighting stop the blur, in standard deviations of the noise
+	ColorSigma float32
+	// How strongly differences in normals stop the blur, higher is stricter
+	NormalPower float32
+	// Distance off a pixel's plane, in voxels, that stops the blur
+	PlaneSigma float32
+	// Weight of the new frame once there's enough history, 1 turns reprojection off
+	Alpha float32
+	// History is thrown away where its depth is off by more than this fraction
+	DepthTolerance float32
+
+	width  int
+	height int
+	// What the history was rendered with
+	prev      Camera
+	prevBasis CameraRayBasis
+	edits     uint64
+	lights    []vxl.LightSource
+
+	history []float32 // Lighting so far, 3 floats per pixel
+	moments []float32 // Average luminance and luminance squared, 2 floats per pixel
+	length  []float32 // Frames of history behind each pixel, 0 for misses
+	depth   []float32 // Last frame's AOVs, to check reprojected history against
+	normal  []float32
+
+	// Scratch space, swapped with the history every frame
+	nextHistory []float32
+	nextMoments []float32
+	nextLength  []float32
+	variance    []float32
+	filtered    [2][]float32
+	filteredVar [2][]float32
+}
+
+func DenoiserInit() Denoiser {
+	return Denoiser{
+		Iterations:     4,
+		ColorSigma:     4,
+		NormalPower:    64,
+		PlaneSigma:     0.25,
+		Alpha:          0.2,
+		DepthTolerance: 0.05,
+	}
+}
+
+// Throws away the history, the next frame is denoised on its own
+func (dn *Denoiser) Reset() {
+	clear(dn.length)
+}
+
+// Denoises the HDR buffer of pix in place, with aov holding what every pixel hit.
+// cam and vox have to be what the frame was rendered with. Does nothing without HDR
+func (dn *Denoiser) Apply(cam *Camera, vox *vxl.Voxels, pix *Pixels, aov *AOVs) {
+	if !pix.HasHDR() || aov == nil || aov.Width != pix.Width || aov.Height != pix.Height {
+		return
+	}
+	if dn.width != pix.Width || dn.height != pix.Height {
+		dn.resize(pix.Width, pix.Height)
+	}
+	// Lighting changes make the whole history wrong, there's no telling which pixels they touched
+	if vox.Edits() != dn.edits || !vxl.SameLights(vox.Lights, dn.lights) || cam.Ambient != dn.prev.Ambient ||
+		cam.Environment != dn.prev.Environment || cam.MaxReflections != dn.prev.MaxReflections || dn.Alpha >= 1 {
+		dn.Reset()
+	}
+	basis := CameraRayBasisInit(cam, pix)
+
+	scheduler := DefaultScheduler()
+	scheduler.RenderTiles(pix.Width, pix.Height, func(x0, y0, x1, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				dn.accumulate(pix, aov, x, y)
+			}
+		}
+	})
+	scheduler.RenderTiles(pix.Width, pix.Height, func(x0, y0, x1, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				dn.estimateVariance(aov, x, y)
+			}
+		}
+	})
+
+	// Ping pongs between the filtered buffers, starting from the accumulated history
+	src, srcVar := dn.nextHistory, dn.variance
+	for iteration := range dn.Iterations {
+		dst, dstVar := dn.filtered[iteration%2], dn.filteredVar[iteration%2]
+		step := 1 << iteration
+		scheduler.RenderTiles(pix.Width, pix.Height, func(x0, y0, x1, y1 int) {
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					dn.atrous(aov, src, srcVar, dst, dstVar, x, y, step)
+				}
+			}
+		})
+		src, srcVar = dst, dstVar
+	}
+
+	for i := range pix.Width * pix.Height {
+		if dn.nextLength[i] == 0 {
+			continue
+		}
+		albedo := demodulation(aov, i)
+		light := te.Vec3(src[3*i], src[3*i+1], src[3*i+2]).MulComponent(albedo)
+		pix.SetPixelLinear(i%pix.Width, i/pix.Width, light)
+	}
+
+	dn.history, dn.nextHistory = dn.nextHistory, dn.history
+	dn.moments, dn.nextMoments = dn.nextMoments, dn.moments
+	dn.length, dn.nextLength = dn.nextLength, dn.length
+	copy(dn.depth, aov.depth)
+	copy(dn.normal, aov.normal)
+	dn.prev, dn.prevBasis = *cam, basis
+	dn.edits = vox.Edits()
+	dn.lights = append(dn.lights[:0], vox.Lights...)
+}
+
+func (dn *Denoiser) resize(width, height int) {
+	size := width * height
+	dn.width, dn.height = width, height
+	dn.history, dn.nextHistory = make([]float32, 3*size), make([]float32, 3*size)
+	dn.moments, dn.nextMoments = make([]float32, 2*size), make([]float32, 2*size)
+	dn.length, dn.nextLength = make([]float32, size), make([]float32, size)
+	dn.depth, dn.normal = make([]float32, size), make([]float32, 3*size)
+	dn.variance = make([]float32, size)
+	for i := range dn.filtered {
+		dn.filtered[i], dn.filteredVar[i] = make([]float32, 3*size), make([]float32, size)
+	}
+}
+
+// The voxel color the lighting is divided by, kept away from 0 so black voxels don't blow up
+func demodulation(aov *AOVs, i int) te.Vector3 {
+	return te.Vec3(aov.albedo[3*i], aov.albedo[3*i+1], aov.albedo[3*i+2]).ComponentMax(0.01)
+}
+
+// Mixes the pixel's lighting into its reprojected history
+func (dn *Denoiser) accumulate(pix *Pixels, aov *AOVs, x, y int) {
+	i := pix.Width*y + x
+	if aov.voxel[3*i] < 0 {
+		// The sky is left alone
+		dn.nextLength[i] = 0
+		return
+	}
+	albedo := demodulation(aov, i)
+	light := pix.GetPixelHDR(x, y)
+	light = te.Vec3(light.X/albedo.X, light.Y/albedo.Y, light.Z/albedo.Z)
+	lum := luminance(light.X, light.Y, light.Z)
+
+	history, moments, length := light, [2]float32{lum, lum * lum}, float32(1)
+	if j, ok := dn.reproject(aov, i); ok {
+		length = min(dn.length[j]+1, 1/dn.Alpha)
+		// Plain averaging until there's enough history to settle into the moving average
+		alpha := max(dn.Alpha, 1/length)
+		old := te.Vec3(dn.history[3*j], dn.history[3*j+1], dn.history[3*j+2])
+		history = mix(old, light, alpha)
+		moments = [2]float32{
+			dn.moments[2*j] + alpha*(lum-dn.moments[2*j]),
+			dn.moments[2*j+1] + alpha*(lum*lum-dn.moments[2*j+1]),
+		}
+	}
+	dn.nextHistory[3*i], dn.nextHistory[3*i+1], dn.nextHistory[3*i+2] = history.Elms()
+	dn.nextMoments[2*i], dn.nextMoments[2*i+1] = moments[0], moments[1]
+	dn.nextLength[i] = length
+}
+
+// Finds the pixel of the last frame that saw the same surface as pixel i, if there was one
+func (dn *Denoiser) reproject(aov *AOVs, i int) (int, bool) {
+	pos := te.Vec3(aov.position[3*i], aov.position[3*i+1], aov.position[3*i+2])
+	x, y, depth, ok := dn.prev.project(pos, dn.prevBasis)
+	if !ok {
+		return 0, false
+	}
+	px, py := int(math32.Floor(x)), int(math32.Floor(y))
+	if px < 0 || py < 0 || px >= dn.width || py >= dn.height {
+		return 0, false
+	}
+	j := dn.width*py + px
+	// Disoccluded, the last frame saw something else there
+	if dn.length[j] == 0 || math32.Abs(dn.depth[j]-depth) > dn.DepthTolerance*depth {
+		return 0, false
+	}
+	normal := aov.normal[3*i]*dn.normal[3*j] + aov.normal[3*i+1]*dn.normal[3*j+1] + aov.normal[3*i+2]*dn.normal[3*j+2]
+	return j, normal > 0.9
+}
+
+// Variance of the pixel's luminance over time, or over its neighbors while it has too
+// little history to tell
+func (dn *Denoiser) estimateVariance(aov *AOVs, x, y int) {
+	i := dn.width*y + x
+	if dn.nextLength[i] == 0 {
+		dn.variance[i] = 0
+		return
+	}
+	if dn.nextLength[i] >= 4 {
+		dn.variance[i] = max(dn.nextMoments[2*i+1]-dn.nextMoments[2*i]*dn.nextMoments[2*i], 0)
+		return
+	}
+
+	sum, sumSqr, count := float32(0), float32(0), float32(0)
+	for dy := -1; dy <= 1; dy++ {
+		for dx := -1; dx <= 1; dx++ {
+			qx, qy := x+dx, y+dy
+			if qx < 0 || qy < 0 || qx >= dn.width || qy >= dn.height {
+				continue
+			}
+			q := dn.width*qy + qx
+			if dn.nextLength[q] == 0 {
+				continue
+			}
+			sum += dn.nextMoments[2*q]
+			sumSqr += dn.nextMoments[2*q+1]
+			count++
+		}
+	}
+	mean := sum / count
+	// Boosted, one frame's worth of neighbors underestimates the noise
+	dn.variance[i] = 4 * max(sumSqr/count-mean*mean, 0)
+}
+
+// One edge-aware blur pass over the pixel, with taps step pixels apart
+func (dn *Denoiser) atrous(aov *AOVs, src, srcVar, dst, dstVar []float32, x, y, step int) {
+	i := dn.width*y + x
+	if dn.nextLength[i] == 0 {
+		return
+	}
+	normal := te.Vec3(aov.normal[3*i], aov.normal[3*i+1], aov.normal[3*i+2])
+	pos := te.Vec3(aov.position[3*i], aov.position[3*i+1], aov.position[3*i+2])
+	lum := luminance(src[3*i], src[3*i+1], src[3*i+2])
+	sigma := dn.ColorSigma*math32.Sqrt(srcVar[i]) + 1e-4
+
+	sum, sumVar, weights := te.Vec3Zero(), float32(0), float32(0)
+	for ky := -2; ky <= 2; ky++ {
+		for kx := -2; kx <= 2; kx++ {
+			qx, qy := x+kx*step, y+ky*step
+			if qx < 0 || qy < 0 || qx >= dn.width || qy >= dn.height {
+				continue
+			}
+			q := dn.width*qy + qx
+			if dn.nextLength[q] == 0 {
+				continue
+			}
+			qNormal := te.Vec3(aov.normal[3*q], aov.normal[3*q+1], aov.normal[3*q+2])
+			qPos := te.Vec3(aov.position[3*q], aov.position[3*q+1], aov.position[3*q+2])
+			qLum := luminance(src[3*q], src[3*q+1], src[3*q+2])
+
+			weight := atrousKernel[abs(kx)] * atrousKernel[abs(ky)]
+			weight *= math32.Pow(max(normal.Dot(qNormal), 0), dn.NormalPower)
+			// Voxel faces are flat, so anything off the pixel's plane is a different surface
+			weight *= math32.Exp(-math32.Abs(qPos.Sub(pos).Dot(normal))/dn.PlaneSigma - math32.Abs(lum-qLum)/sigma)
+			if weight <= 0 {
+				continue
+			}
+			sum = sum.Add(te.Vec3(src[3*q], src[3*q+1], src[3*q+2]).Mul(weight))
+			sumVar += weight * weight * srcVar[q]
+			weights += weight
+		}
+	}
+	// The pixel itself always has a weight, so this never divides by 0
+	dst[3*i], dst[3*i+1], dst[3*i+2] = sum.Div(weights).Elms()
+	dstVar[i] = sumVar / (weights * weights)
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,133 @@
+package render
+
+import (
+	"math/rand/v2"
+	"testing"
+
+	"github.com/chewxy/math32"
+	te "github.com/zheskett/go-voxel/internal/tensor"
+	vxl "github.com/zheskett/go-voxel/internal/voxel"
+)
+
+// A floor with a wall across it, rendered cleanly with its AOVs
+func denoiseScene() (vxl.Voxels, Camera, Pixels, []te.Vector3) {
+	vox := testWorld()
+	for x := range vox.X {
+		for y := range 8 {
+			vox.SetVoxel(x, y, 24, 120, 160, 220)
+		}
+	}
+	pix := PixelsInitHDR(48, 36)
+	pix.EnableAOVs()
+	cam := testCamera(&pix)
+	cam.RenderVoxels(&vox, &pix)
+
+	clean := make([]te.Vector3, pix.Width*pix.Height)
+	for i := range clean {
+		clean[i] = pix.GetPixelHDR(i%pix.Width, i/pix.Width)
+	}
+	return vox, cam, pix, clean
+}
+
+// Replaces every pixel with its clean color times some noise
+func addNoise(pix *Pixels, clean []te.Vector3, rng *rand.Rand) {
+	for i, c := range clean {
+		pix.SetPixelLinear(i%pix.Width, i/pix.Width, c.Mul(0.2+1.6*rng.Float32()))
+	}
+}
+
+// Root mean squared error of the pixels that hit something
+func rmse(pix *Pixels, clean []te.Vector3) float32 {
+	sum, count := float32(0), 0
+	for i, c := range clean {
+		x, y := i%pix.Width, i/pix.Width
+		if !pix.AOVs().At(x, y).Hit {
+			continue
+		}
+		diff := pix.GetPixelHDR(x, y).Sub(c)
+		sum += diff.Dot(diff)
+		count++
+	}
+	return math32.Sqrt(sum / float32(count))
+}
+
+// TestDenoiserSmoothsNoise expects the blur and the history to each cut the noise
+// down, and the wall's color not to bleed into the floor.
+func TestDenoiserSmoothsNoise(t *testing.T) {
+	vox, cam, pix, clean := denoiseScene()
+	rng := rand.New(rand.NewPCG(1, 2))
+	dn := DenoiserInit()
+
+	addNoise(&pix, clean, rng)
+	noisy := rmse(&pix, clean)
+	dn.Apply(&cam, &vox, &pix, pix.AOVs())
+	once := rmse(&pix, clean)
+	if once > noisy/2 {
+		t.Errorf("Expected the blur to halve the error at least, got %v from %v", once, noisy)
+	}
+
+	// Pixels right by the bottom of the wall shouldn't pick up the other side's color
+	for x := range pix.Width {
+		for y := range pix.Height - 1 {
+			top, bottom := pix.AOVs().At(x, y), pix.AOVs().At(x, y+1)
+			if !top.Hit || !bottom.Hit || top.Normal == bottom.Normal {
+				continue
+			}
+			for _, at := range [][2]int{{x, y}, {x, y + 1}} {
+				i := pix.Width*at[1] + at[0]
+				got := pix.GetPixelHDR(at[0], at[1])
+				if want := clean[i].Z / clean[i].X; math32.Abs(got.Z/got.X-want) > 0.05*want {
+					t.Errorf("Expected the color %v at the edge pixel %v, got %v", clean[i], at, got)
+				}
+			}
+		}
+	}
+
+	dn = DenoiserInit()
+	dn.Iterations = 0
+	for range 16 {
+		addNoise(&pix, clean, rng)
+		dn.Apply(&cam, &vox, &pix, pix.AOVs())
+	}
+	if history := rmse(&pix, clean); history > noisy/2 {
+		t.Errorf("Expected the history alone to halve the error, got %v from %v", history, noisy)
+	}
+}
+
+// TestDenoiserHistory expects history to follow the camera when it moves, and to
+// be thrown away when the world changes.
+func TestDenoiserHistory(t *testing.T) {
+	vox, cam, pix, _ := denoiseScene()
+	dn := DenoiserInit()
+	for range 3 {
+		cam.RenderVoxels(&vox, &pix)
+		dn.Apply(&cam, &vox, &pix, pix.AOVs())
+	}
+
+	kept := func() float32 {
+		count, total := 0, 0
+		for i, length := range dn.length {
+			if pix.AOVs().At(i%pix.Width, i/pix.Width).Hit {
+				total++
+				if length > 1 {
+					count++
+				}
+			}
+		}
+		return float32(count) / float32(total)
+	}
+
+	cam.Pos = cam.Pos.Add(te.Vec3(0.5, 0, 0.25))
+	cam.RenderVoxels(&vox, &pix)
+	dn.Apply(&cam, &vox, &pix, pix.AOVs())
+	if got := kept(); got < 0.8 {
+		t.Errorf("Expected most pixels to keep their history after a small move, only %v did", got)
+	}
+
+	vox.SetVoxel(2, 1, 2, 255, 255, 255)
+	cam.RenderVoxels(&vox, &pix)
+	dn.Apply(&cam, &vox, &pix, pix.AOVs())
+	if got := kept(); got != 0 {
+		t.Errorf("Expected an edit to throw away the history, %v of it was kept", got)
+	}
+}
@@ -0,0 +1,238 @@
+package render
+
+import (
+	"github.com/chewxy/math32"
+	te "github.com/zheskett/go-voxel/internal/tensor"
+	vxl "github.com/zheskett/go-voxel/internal/voxel"
+)
+
+type SkyMode int
+
+const (
+	// One color everywhere, the old background
+	SkySolid SkyMode = iota
+	// Fades from the horizon up to the zenith, and down to the ground
+	SkyGradient
+	// Preetham's daylight model, lit by the world's first directional light
+	SkyPhysical
+	// An equirectangular Skybox
+	SkyImage
+	skyModeCount
+)
+
+func (mode SkyMode) String() string {
+	switch mode {
+	case SkySolid:
+		return "solid"
+	case SkyGradient:
+		return "gradient"
+	case SkyPhysical:
+		return "physical"
+	case SkyImage:
+		return "image"
+	}
+	return "unknown"
+}
+
+// Sky F10 switches to. The engine skips SkyImage itself when no skybox is loaded
+func (mode SkyMode) Next() SkyMode {
+	return (mode + 1) % skyModeCount
+}
+
+// Sky for a -sky value. The CLI takes anything this rejects as the path of a skybox
+func ParseSkyMode(name string) (SkyMode, bool) {
+	for mode := range skyModeCount {
+		if mode.String() == name {
+			return mode, true
+		}
+	}
+	return SkySolid, false
+}
+
+// Fog thins the light coming along a ray, mixing in its own color instead.
+// With a Falloff it's thicker below Height and thinner above it
+type Fog struct {
+	Density float32    // Fraction of light lost per voxel at Height, 0 has no fog
+	Height  float32    // Height Density is measured at
+	Falloff float32    // How quickly it thins out going up, 0 is the same everywhere
+	Color   te.Vector3 // Linear, zero takes the sky's color in the ray's direction
+}
+
+// Environment is what rays that miss the world see, and the air between the camera
+// and what they hit
+type Environment struct {
+	Sky SkyMode
+	// Linear. SkySolid is Zenith everywhere
+	Zenith, Horizon, Ground te.Vector3
+
+	// SkyPhysical: how hazy the air is, from 2 for clear to 10 for hazy
+	Turbidity float32
+	// SkyPhysical: scales the model's luminance to the world's lights, times the sun's brightness
+	Brightness float32
+	// Towards the sun when the world has no directional light
+	SunDirection te.Vector3
+	// Angular radius of the sun's disk in radians for gradient and physical skies, 0 hides it
+	SunSize float32
+	// Radiance of the sun's disk, relative to the directional light's color
+	SunBrightness float32
+
+	// SkyImage shows it, or Zenith if it's nil
+	Skybox *Skybox
+
+	Fog Fog
+	// Fraction of the render distance, at the end of it, that hits fade into the sky over
+	// instead of popping in and out at the cutoff. 0 has a hard cutoff
+	Fade float32
+}
+
+func EnvironmentInit() Environment {
+	return Environment{
+		Sky:           SkySolid,
+		Zenith:        SRGBColorToLinear([3]byte{BackgroundRed, BackgroundGreen, BackgroundBlue}),
+		Horizon:       SRGBColorToLinear([3]byte{110, 130, 160}),
+		Ground:        SRGBColorToLinear([3]byte{40, 38, 36}),
+		Turbidity:     3,
+		Brightness:    0.02,
+		SunDirection:  te.Vec3(0.4, 1, 0.3).Normalized(),
+		SunSize:       0.03,
+		SunBrightness: 40,
+		Fade:          0.1,
+	}
+}
+
+// Linear light coming from the sky in direction dir, including the sun's disk.
+// dir must be normalized
+func (env *Environment) Background(dir te.Vector3, lights []vxl.LightSource) te.Vector3 {
+	sky := env.SkyLight(dir, lights)
+	if env.SunSize <= 0 || (env.Sky != SkyGradient && env.Sky != SkyPhysical) {
+		return sky
+	}
+	sunDir, sunColor := env.sun(lights)
+	edge := math32.Cos(env.SunSize)
+	if cosine := dir.Dot(sunDir); cosine > edge {
+		// Softened over the outer fifth of the disk
+		inner := math32.Cos(env.SunSize * 0.8)
+		sky = sky.Add(sunColor.Mul(env.SunBrightness * te.Smoothstep(edge, inner, cosine)))
+	}
+	return sky
+}
+
+// Same as Background without the sun's disk, which the sun's directional light already
+// stands in for. For rays bouncing off diffuse surfaces
+func (env *Environment) SkyLight(dir te.Vector3, lights []vxl.LightSource) te.Vector3 {
+	switch env.Sky {
+	case SkyGradient:
+		if dir.Y >= 0 {
+			return mix(env.Horizon, env.Zenith, math32.Sqrt(dir.Y))
+		}
+		return mix(env.Horizon, env.Ground, te.Smoothstep(0, 0.2, -dir.Y))
+	case SkyPhysical:
+		return env.physical(dir, lights)
+	case SkyImage:
+		if env.Skybox != nil {
+			return env.Skybox.Sample(dir)
+		}
+	}
+	return env.Zenith
+}
+
+// Direction towards the sun and its color, from the first directional light
+func (env *Environment) sun(lights []vxl.LightSource) (te.Vector3, te.Vector3) {
+	for _, light := range lights {
+		if sun, ok := light.(vxl.DirectionalLight); ok {
+			return sun.Direction.Normalized().Neg(), sun.Color
+		}
+	}
+	return env.SunDirection.Normalized(), te.Vec3Splat(1)
+}
+
+// How much of the light from dist along the ray makes it through the fog, and the fog's own
+// light added on the way, so the light arriving is keep * light + add
+func (env *Environment) fog(ray vxl.Ray, dist float32, lights []vxl.LightSource) (float32, te.Vector3) {
+	fog := env.Fog
+	if fog.Density <= 0 || dist <= 0 {
+		return 1, te.Vec3Zero()
+	}
+
+	// Density falls off exponentially with height, which integrates in closed form along a straight ray
+	depth := fog.Density * dist * math32.Exp(-fog.Falloff*(ray.Origin.Y-fog.Height))
+	if rise := fog.Falloff * ray.Dir.Y * dist; math32.Abs(rise) > 1e-4 {
+		depth *= (1 - math32.Exp(-rise)) / rise
+	}
+	keep := math32.Exp(-depth)
+
+	color := fog.Color
+	if color == te.Vec3Zero() {
+		color = env.SkyLight(ray.Dir, lights)
+	}
+	return keep, color.Mul(1 - keep)
+}
+
+// Same as fog, but also fades hits near the end of the render distance into the sky.
+// travelled is how far the ray came before it started
+func (env *Environment) attenuate(ray vxl.Ray, dist, travelled, tmax float32, lights []vxl.LightSource) (float32, te.Vector3) {
+	keep, add := env.fog(ray, dist, lights)
+	start := (1 - env.Fade) * tmax
+	if env.Fade <= 0 || travelled+dist <= start {
+		return keep, add
+	}
+	fade := te.Smoothstep(start, tmax, travelled+dist)
+	return keep * (1 - fade), add.Mul(1 - fade).Add(env.SkyLight(ray.Dir, lights).Mul(fade))
+}
+
+// Preetham, Shirley and Smits, "A Practical Analytic Model for Daylight"
+func (env *Environment) physical(dir te.Vector3, lights []vxl.LightSource) te.Vector3 {
+	sunDir, sunColor := env.sun(lights)
+	// Dims to nothing as the sun sets, the model itself only covers the sun above the horizon
+	daylight := te.Smoothstep(-0.1, 0.05, sunDir.Y) * luminance(sunColor.X, sunColor.Y, sunColor.Z)
+	if daylight <= 0 {
+		return te.Vec3Zero()
+	}
+	sunDir = te.Vec3(sunDir.X, max(sunDir.Y, 0), sunDir.Z).Normalized()
+	ground := math32.Max(-dir.Y, 0)
+	view := te.Vec3(dir.X, max(dir.Y, 0), dir.Z).NormalizedOrZero()
+	if view == te.Vec3Zero() {
+		view = te.Vec3Y()
+	}
+
+	t := env.Turbidity
+	thetaS := math32.Acos(sunDir.Y)
+	cosTheta := math32.Max(view.Y, 0.01)
+	gamma := math32.Acos(math32.Min(math32.Max(view.Dot(sunDir), -1), 1))
+
+	chi := (4.0/9.0 - t/120) * (math32.Pi - 2*thetaS)
+	zenithY := (4.0453*t-4.9710)*math32.Tan(chi) - 0.2155*t + 2.4192
+	th2, th3 := thetaS*thetaS, thetaS*thetaS*thetaS
+	zenithX := t*t*(0.00166*th3-0.00375*th2+0.00209*thetaS) +
+		t*(-0.02903*th3+0.06377*th2-0.03202*thetaS+0.00394) +
+		(0.11693*th3 - 0.21196*th2 + 0.06052*thetaS + 0.25886)
+	zenithy := t*t*(0.00275*th3-0.00610*th2+0.00317*thetaS) +
+		t*(-0.04214*th3+0.08970*th2-0.04153*thetaS+0.00516) +
+		(0.15346*th3 - 0.26756*th2 + 0.06670*thetaS + 0.26688)
+
+	perez := func(a, b, c, d, e float32) float32 {
+		at := (1 + a*math32.Exp(b/cosTheta)) * (1 + c*math32.Exp(d*gamma) + e*math32.Cos(gamma)*math32.Cos(gamma))
+		zenith := (1 + a*math32.Exp(b)) * (1 + c*math32.Exp(d*thetaS) + e*math32.Cos(thetaS)*math32.Cos(thetaS))
+		return at / zenith
+	}
+	lum := zenithY * perez(0.1787*t-1.4630, -0.3554*t+0.4275, -0.0227*t+5.3251, 0.1206*t-2.5771, -0.0670*t+0.3703)
+	x := zenithX * perez(-0.0193*t-0.2592, -0.0665*t+0.0008, -0.0004*t+0.2125, -0.0641*t-0.8989, -0.0033*t+0.0452)
+	y := zenithy * perez(-0.0167*t-0.2608, -0.0950*t+0.0092, -0.0079*t+0.2102, -0.0441*t-1.6537, -0.0109*t+0.0529)
+
+	// xyY to XYZ to linear sRGB
+	lum = math32.Max(lum, 0) * env.Brightness * daylight
+	cx, cz := x/y*lum, (1-x-y)/y*lum
+	sky := te.Vec3(
+		3.2406*cx-1.5372*lum-0.4986*cz,
+		-0.9689*cx+1.8758*lum+0.0415*cz,
+		0.0557*cx-0.2040*lum+1.0570*cz,
+	).ComponentMax(0)
+	if ground > 0 {
+		sky = mix(sky, env.Ground.Mul(daylight), te.Smoothstep(0, 0.2, ground))
+	}
+	return sky
+}
+
+func mix(a, b te.Vector3, t float32) te.Vector3 {
+	return a.Mul(1 - t).Add(b.Mul(t))
+}
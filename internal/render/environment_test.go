@@ -0,0 +1,116 @@
+package render
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/chewxy/math32"
+	te "github.com/zheskett/go-voxel/internal/tensor"
+	vxl "github.com/zheskett/go-voxel/internal/voxel"
+)
+
+// TestSkyModes checks the gradient's ends and that the physical sky is blue overhead,
+// brightest around the sun and follows the directional light.
+func TestSkyModes(t *testing.T) {
+	env := EnvironmentInit()
+	env.Sky = SkyGradient
+	if got := env.SkyLight(te.Vec3Y(), nil); !closeToVec(got, env.Zenith, 1e-6) {
+		t.Errorf("Expected the zenith %v straight up, got %v", env.Zenith, got)
+	}
+	if got := env.SkyLight(te.Vec3X(), nil); !closeToVec(got, env.Horizon, 1e-6) {
+		t.Errorf("Expected the horizon %v straight out, got %v", env.Horizon, got)
+	}
+
+	env.Sky = SkyPhysical
+	sun := vxl.DirectionalLight{Direction: te.Vec3(-1, -1, 0), Color: te.Vec3Splat(1)}
+	lights := []vxl.LightSource{sun}
+	up := env.SkyLight(te.Vec3Y(), lights)
+	if up.Z <= up.X {
+		t.Errorf("Expected a blue sky overhead, got %v", up)
+	}
+	towards := env.SkyLight(te.Vec3(1, 0.6, 0).Normalized(), lights)
+	away := env.SkyLight(te.Vec3(-1, 0.6, 0).Normalized(), lights)
+	if towards.Max() <= away.Max() {
+		t.Errorf("Expected the sky brighter towards the sun, got %v towards and %v away", towards, away)
+	}
+	if disk := env.Background(te.Vec3(1, 1, 0).Normalized(), lights); disk.Max() < env.SunBrightness {
+		t.Errorf("Expected to see the sun's disk, got %v", disk)
+	}
+
+	night := []vxl.LightSource{vxl.DirectionalLight{Direction: te.Vec3(0, 1, 0), Color: te.Vec3Splat(1)}}
+	if got := env.Background(te.Vec3Y(), night); got.Max() != 0 {
+		t.Errorf("Expected a black sky with the sun below the horizon, got %v", got)
+	}
+}
+
+// TestFog compares height fog against marching through it in small steps, and
+// expects hits to fade into the sky by the end of the render distance.
+func TestFog(t *testing.T) {
+	env := EnvironmentInit()
+	env.Fog = Fog{Density: 0.05, Height: 4, Falloff: 0.3, Color: te.Vec3(1, 0, 0)}
+	ray := vxl.Ray{Origin: te.Vec3(0, 2, 0), Dir: te.Vec3(1, 1, 0).Normalized(), Tmax: 64}
+
+	dist := float32(20)
+	depth, steps := float32(0), 10000
+	for i := range steps {
+		y := ray.Origin.Y + ray.Dir.Y*dist*(float32(i)+0.5)/float32(steps)
+		depth += env.Fog.Density * math32.Exp(-env.Fog.Falloff*(y-env.Fog.Height)) * dist / float32(steps)
+	}
+	keep, add := env.fog(ray, dist, nil)
+	if want := math32.Exp(-depth); math32.Abs(keep-want) > 1e-4 {
+		t.Errorf("Expected %v of the light through the fog, got %v", want, keep)
+	}
+	if !closeToVec(add, te.Vec3(1-keep, 0, 0), 1e-6) {
+		t.Errorf("Expected the fog's color for the rest, got %v", add)
+	}
+
+	env.Fog.Density = 0
+	if keep, _ := env.attenuate(ray, 10, 0, 64, nil); keep != 1 {
+		t.Errorf("Expected nothing fading close up, got %v", keep)
+	}
+	keep, add = env.attenuate(ray, 64, 0, 64, nil)
+	if keep != 0 || add != env.Zenith {
+		t.Errorf("Expected the sky at the end of the render distance, got %v and %v", keep, add)
+	}
+}
+
+// TestSkyboxRoundTrip writes an HDR image, reads it back as a skybox, and
+// expects to sample its colors from the right directions. Sizes too big to load should fail.
+func TestSkyboxRoundTrip(t *testing.T) {
+	pix := PixelsInitHDR(8, 4)
+	for y := range 4 {
+		for x := range 8 {
+			// Sky on the top half, bright ground on the bottom
+			c := te.Vec3(0.1, 0.2, 0.4)
+			if y >= 2 {
+				c = te.Vec3(3, 2, 1)
+			}
+			pix.SetPixelLinear(x, y, c)
+		}
+	}
+	buf := bytes.Buffer{}
+	if err := pix.WriteHDR(&buf); err != nil {
+		t.Fatalf("WriteHDR: %v", err)
+	}
+	sky, err := ReadHDR(&buf)
+	if err != nil {
+		t.Fatalf("ReadHDR: %v", err)
+	}
+	if sky.Width != 8 || sky.Height != 4 {
+		t.Fatalf("Expected 8 x 4, got %v x %v", sky.Width, sky.Height)
+	}
+	if got := sky.Sample(te.Vec3Y()); !closeToVec(got, te.Vec3(0.1, 0.2, 0.4), 0.01) {
+		t.Errorf("Expected the sky looking up, got %v", got)
+	}
+	if got := sky.Sample(te.Vec3(1, -1, 1).Normalized()); !closeToVec(got, te.Vec3(3, 2, 1), 0.02) {
+		t.Errorf("Expected the ground looking down, got %v", got)
+	}
+
+	for _, size := range []string{"-Y 0 +X 8", "-Y 4 +X -8", "-Y 4 +X 40000", "-Y 30000 +X 30000", "-Y 4 +X 9223372036854775807"} {
+		header := "#?RADIANCE\nFORMAT=32-bit_rle_rgbe\n\n" + size + "\n"
+		if _, err := ReadHDR(bytes.NewBufferString(header)); err == nil || !strings.Contains(err.Error(), "Bad HDR size") {
+			t.Errorf("Expected a bad size error for %q, got %v", size, err)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package render
+
+import (
+	"github.com/chewxy/math32"
+	te "github.com/zheskett/go-voxel/internal/tensor"
+)
+
+// FXAA smooths jagged edges by blurring along them. It's the cheap console version,
+// which finds the direction of an edge from the 4 diagonal neighbors and blends a few
+// samples along it
+type FXAA struct {
+	// Contrast, relative to the brightest neighbor, a pixel needs to count as an edge
+	EdgeThreshold float32
+	// Contrast below which dark pixels are never edges
+	EdgeThresholdMin float32
+	// Furthest the samples along an edge go, in pixels
+	SpanMax float32
+
+	src  []float32
+	luma []float32
+}
+
+func FXAAInit() *FXAA {
+	return &FXAA{EdgeThreshold: 1.0 / 8, EdgeThresholdMin: 1.0 / 32, SpanMax: 8}
+}
+
+func (fx *FXAA) Name() string {
+	return "fxaa"
+}
+
+func (fx *FXAA) Apply(pix *Pixels, _ *AOVs) error {
+	const reduceMul, reduceMin = 1.0 / 8, 1.0 / 128
+
+	fx.src = pixelFloats(pix, fx.src)
+	fx.luma = fx.luma[:0]
+	for i := range pix.Width * pix.Height {
+		fx.luma = append(fx.luma, luma(te.Vec3(fx.src[3*i], fx.src[3*i+1], fx.src[3*i+2])))
+	}
+	lumaAt := func(x, y int) float32 {
+		x, y = min(max(x, 0), pix.Width-1), min(max(y, 0), pix.Height-1)
+		return fx.luma[pix.Width*y+x]
+	}
+
+	DefaultScheduler().RenderTiles(pix.Width, pix.Height, func(x0, y0, x1, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				nw, ne := lumaAt(x-1, y-1), lumaAt(x+1, y-1)
+				sw, se := lumaAt(x-1, y+1), lumaAt(x+1, y+1)
+				m := lumaAt(x, y)
+				lumaMin, lumaMax := min(m, nw, ne, sw, se), max(m, nw, ne, sw, se)
+				if lumaMax-lumaMin < max(fx.EdgeThresholdMin, lumaMax*fx.EdgeThreshold) {
+					continue
+				}
+
+				// Across the gradient, so along the edge
+				dx, dy := (sw+se)-(nw+ne), (nw+sw)-(ne+se)
+				reduce := max((nw+ne+sw+se)*0.25*reduceMul, reduceMin)
+				scale := 1 / (min(math32.Abs(dx), math32.Abs(dy)) + reduce)
+				dx = min(max(dx*scale, -fx.SpanMax), fx.SpanMax)
+				dy = min(max(dy*scale, -fx.SpanMax), fx.SpanMax)
+
+				cx, cy := float32(x)+0.5, float32(y)+0.5
+				near := fx.sample(pix, cx-dx/6, cy-dy/6).Add(fx.sample(pix, cx+dx/6, cy+dy/6)).Mul(0.5)
+				far := fx.sample(pix, cx-dx/2, cy-dy/2).Add(fx.sample(pix, cx+dx/2, cy+dy/2)).Mul(0.5)
+				wide := near.Add(far).Mul(0.5)
+				// The wide blend overshoots when it reaches past the end of the edge
+				if l := luma(wide); l < lumaMin || l > lumaMax {
+					setPixelFloat(pix, x, y, near)
+				} else {
+					setPixelFloat(pix, x, y, wide)
+				}
+			}
+		}
+	})
+	return nil
+}
+
+// Bilinear sample of the frame as it was before the pass, x and y in pixels
+func (fx *FXAA) sample(pix *Pixels, x, y float32) te.Vector3 {
+	x, y = x-0.5, y-0.5
+	fx0, fy0 := math32.Floor(x), math32.Floor(y)
+	tx, ty := x-fx0, y-fy0
+	at := func(px, py int) te.Vector3 {
+		px, py = min(max(px, 0), pix.Width-1), min(max(py, 0), pix.Height-1)
+		i := 3 * (pix.Width*py + px)
+		return te.Vec3(fx.src[i], fx.src[i+1], fx.src[i+2])
+	}
+	ix, iy := int(fx0), int(fy0)
+	top := mix(at(ix, iy), at(ix+1, iy), tx)
+	bottom := mix(at(ix, iy+1), at(ix+1, iy+1), tx)
+	return mix(top, bottom, ty)
+}
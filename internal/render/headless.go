@@ -0,0 +1,129 @@
+package render
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	vxl "github.com/zheskett/go-voxel/internal/voxel"
+)
+
+// Renders the world from the camera at the given resolution without a window.
+// The camera's aspect is set to match the resolution
+func RenderHeadless(cam *Camera, vox *vxl.Voxels, width, height int) Pixels {
+	pix := PixelsInitHDR(width, height)
+	cam.Aspect = float32(width) / float32(height)
+	cam.RenderVoxels(vox, &pix)
+	return pix
+}
+
+// Same as RenderHeadless, but averages the given number of progressive samples
+// for anti-aliasing and soft shadows
+func RenderHeadlessProgressive(cam *Camera, vox *vxl.Voxels, width, height, samples int) Pixels {
+	pix := PixelsInitHDR(width, height)
+	cam.Aspect = float32(width) / float32(height)
+	pg := ProgressiveInit()
+	pg.MaxSamples = samples
+	for range samples {
+		pg.Render(cam, vox, &pix)
+	}
+	return pix
+}
+
+// Same as RenderHeadless, but renders one of the debug views. The bytes are final, the
+// HDR buffer has the same colors for .hdr output
+func RenderHeadlessDebug(cam *Camera, vox *vxl.Voxels, width, height int, dbg *Debug) Pixels {
+	pix := PixelsInitHDR(width, height)
+	cam.Aspect = float32(width) / float32(height)
+	dbg.Render(cam, vox, &pix)
+	return pix
+}
+
+// Same as RenderHeadlessProgressive, but path traces every sample
+func RenderHeadlessPathTraced(cam *Camera, vox *vxl.Voxels, width, height, samples int, pt PathTracer) Pixels {
+	pix := PixelsInitHDR(width, height)
+	cam.Aspect = float32(width) / float32(height)
+	pg := ProgressiveInit()
+	pg.MaxSamples = samples
+	pg.PathTrace = &pt
+	for range samples {
+		pg.Render(cam, vox, &pix)
+	}
+	return pix
+}
+
+// Copies the pixels into an image
+func (px *Pixels) Image() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, px.Width, px.Height))
+	for y := range px.Height {
+		for x := range px.Width {
+			c := px.GetPixel(x, y)
+			img.SetRGBA(x, y, color.RGBA{c[0], c[1], c[2], 0xff})
+		}
+	}
+	return img
+}
+
+// Writes the pixels as an 8-bit PNG
+func (px *Pixels) WritePNG(w io.Writer) error {
+	return png.Encode(w, px.Image())
+}
+
+// Writes the pixels as a Radiance HDR (.hdr) image, using the unclamped colors if there are any
+func (px *Pixels) WriteHDR(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "#?RADIANCE\nFORMAT=32-bit_rle_rgbe\n\n-Y %d +X %d\n", px.Height, px.Width)
+
+	// Flat scanlines, readers tell them apart from RLE ones by the first bytes
+	for y := range px.Height {
+		for x := range px.Width {
+			c := px.GetPixelHDR(x, y)
+			rgbe := [4]byte{}
+			brightest := float64(max(c.X, c.Y, c.Z))
+			if brightest > 1e-32 {
+				mantissa, exponent := math.Frexp(brightest)
+				scale := float32(mantissa * 256.0 / brightest)
+				rgbe = [4]byte{
+					byte(max(c.X, 0) * scale),
+					byte(max(c.Y, 0) * scale),
+					byte(max(c.Z, 0) * scale),
+					byte(exponent + 128),
+				}
+			}
+			if _, err := bw.Write(rgbe[:]); err != nil {
+				return err
+			}
+		}
+	}
+	return bw.Flush()
+}
+
+// Writes the pixels to path, as a PNG or HDR depending on the extension
+func (px *Pixels) SaveImage(path string) error {
+	var write func(io.Writer) error
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png":
+		write = px.WritePNG
+	case ".hdr":
+		write = px.WriteHDR
+	default:
+		return fmt.Errorf("Unknown image format: %v", path)
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,134 @@
+package render
+
+import (
+	"bytes"
+	"image/png"
+	"slices"
+	"testing"
+
+	"github.com/chewxy/math32"
+	te "github.com/zheskett/go-voxel/internal/tensor"
+)
+
+// TestRenderHeadless renders the test world looking along the floor and expects the
+// floor lit on the bottom half and nothing hit on the top.
+func TestRenderHeadless(t *testing.T) {
+	vox := testWorld()
+	cam := CameraInit()
+	cam.Fov = 90
+	cam.Pos = te.Vec3(16, 10, 2)
+	cam.RenderDistance = 64
+	cam.UpdateRotationFPS(0, 0)
+
+	pix := RenderHeadless(&cam, &vox, 24, 16)
+	if pix.Width != 24 || pix.Height != 16 || cam.Aspect != 1.5 {
+		t.Fatalf("Expected a 24 x 16 frame at aspect 1.5, got %v x %v at %v", pix.Width, pix.Height, cam.Aspect)
+	}
+	floor := pix.GetPixel(12, 14)
+	// Gray, give or take the sky's tint on the ambient light
+	if min(floor[0], floor[1], floor[2]) < 32 || max(floor[0], floor[1], floor[2])-min(floor[0], floor[1], floor[2]) > 8 {
+		t.Errorf("Expected the gray floor lit at the bottom, got %v", floor)
+	}
+	if sky := pix.GetPixel(12, 1); sky == floor {
+		t.Errorf("Expected the top to miss the floor, got the floor's %v", sky)
+	}
+	if again := RenderHeadless(&cam, &vox, 24, 16); !bytes.Equal(again.data, pix.data) {
+		t.Errorf("Expected the same frame rendering twice")
+	}
+}
+
+// TestWritePNG expects a PNG to decode back to the exact bytes it was written from.
+func TestWritePNG(t *testing.T) {
+	pix := PixelsInit(7, 5)
+	for y := range pix.Height {
+		for x := range pix.Width {
+			pix.SetPixel(x, y, byte(x*36), byte(y*60), byte(x*y))
+		}
+	}
+	buf := bytes.Buffer{}
+	if err := pix.WritePNG(&buf); err != nil {
+		t.Fatalf("WritePNG: %v", err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	if size := img.Bounds().Size(); size.X != pix.Width || size.Y != pix.Height {
+		t.Fatalf("Expected 7 x 5, got %v", size)
+	}
+	for y := range pix.Height {
+		for x := range pix.Width {
+			r, g, b, a := img.At(x, y).RGBA()
+			got := [3]byte{byte(r >> 8), byte(g >> 8), byte(b >> 8)}
+			if got != pix.GetPixel(x, y) || a != 0xffff {
+				t.Errorf("Pixel %v, %v: expected %v opaque, got %v with alpha %v", x, y, pix.GetPixel(x, y), got, a)
+			}
+		}
+	}
+}
+
+// TestWriteHDR expects unclamped colors, from black to far past white, to read back
+// within RGBE's precision.
+func TestWriteHDR(t *testing.T) {
+	colors := []te.Vector3{
+		te.Vec3Zero(), te.Vec3(0.001, 0.5, 1), te.Vec3(1, 1, 1), te.Vec3(12.5, 3, 0.25), te.Vec3(4000, 0, 100),
+	}
+	pix := PixelsInitHDR(len(colors), 2)
+	for x, c := range colors {
+		pix.SetPixelLinear(x, 0, c)
+		pix.SetPixelLinear(x, 1, c.Mul(2))
+	}
+	buf := bytes.Buffer{}
+	if err := pix.WriteHDR(&buf); err != nil {
+		t.Fatalf("WriteHDR: %v", err)
+	}
+	sky, err := ReadHDR(&buf)
+	if err != nil {
+		t.Fatalf("ReadHDR: %v", err)
+	}
+	if sky.Width != pix.Width || sky.Height != pix.Height {
+		t.Fatalf("Expected %v x %v, got %v x %v", pix.Width, pix.Height, sky.Width, sky.Height)
+	}
+	for y := range pix.Height {
+		for x := range pix.Width {
+			want := pix.GetPixelHDR(x, y)
+			i := 3 * (y*sky.Width + x)
+			got := te.Vec3(sky.data[i], sky.data[i+1], sky.data[i+2])
+			// Channels share the brightest one's exponent, so they're only as exact as 1/256 of it
+			if tolerance := want.Max() / 128; !closeToVec(got, want, math32.Max(tolerance, 1e-6)) {
+				t.Errorf("Pixel %v, %v: expected %v, got %v", x, y, want, got)
+			}
+		}
+	}
+}
+
+// TestRenderHeadlessStats expects the scheduler's stats, saved straight after a headless
+// render, to still describe the render once the passes after it have run.
+func TestRenderHeadlessStats(t *testing.T) {
+	vox := testWorld()
+	cam := CameraInit()
+	cam.Pos = te.Vec3(16, 10, 2)
+	cam.RenderDistance = 64
+	pix := RenderHeadless(&cam, &vox, 40, 20)
+	frame := DefaultScheduler().Stats()
+	tiles := slices.Clone(frame.Tiles)
+
+	aov := AOVsInit(8, 8)
+	cam.RenderAOVs(&vox, &aov)
+	if len(DefaultScheduler().Stats().Tiles) != 1 {
+		t.Fatalf("Expected the scheduler's stats to be the 8 x 8 AOVs' one tile")
+	}
+	pipeline := HDRPipelineInit()
+	pipeline.Resolve(&pix)
+
+	if len(frame.Tiles) != 3*2 || !slices.Equal(frame.Tiles, tiles) {
+		t.Errorf("Expected the saved stats to keep the render's 6 tiles, got %v", frame.Tiles)
+	}
+	pixels := 0
+	for _, tile := range frame.Tiles {
+		pixels += tile.Width * tile.Height
+	}
+	if pixels != 40*20 {
+		t.Errorf("Expected the saved stats to cover the 40 x 20 render, got %v pixels", pixels)
+	}
+}
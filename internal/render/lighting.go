@@ -1,41 +1,83 @@
 package render
 
 import (
+	"math/rand/v2"
+
 	"github.com/chewxy/math32"
 	te "github.com/zheskett/go-voxel/internal/tensor"
 	vxl "github.com/zheskett/go-voxel/internal/voxel"
 )
 
 // Performs the per-pixel lighting by sending secondary rays back towards all of the lights in the scene
-// Much slower than below funcs, but looks very nice
-func GetPixelShading(vox *vxl.Voxels, hit vxl.RayHit, tmax float32) te.Vector3 {
-	intensity := te.Vec3Zero()
+// Much slower than below funcs, but looks very nice. ambient can be nil for no ambient light
+func GetPixelShading(vox *vxl.Voxels, hit vxl.RayHit, tmax float32, ambient *Ambient) te.Vector3 {
+	intensity := ambient.Light(vox, hit)
+	shift := sampleShift(hit.Position)
 	for _, light := range vox.Lights {
-		lightpos := light.Position.Sub(hit.Position)
-		lightdist := lightpos.Len()
-		lightdir := lightpos.Div(lightdist)
-		recastpos := hit.Position.Add(hit.Normal.Mul(vxl.VoxelRayDelta))
-		recastray := vxl.Ray{
-			Origin: recastpos,
-			Dir:    lightdir,
-			Tmax:   math32.Min(lightdist, tmax),
+		// Lights with an area get several shadow rays spread over them for soft shadows
+		count := light.Samples()
+		sum := te.Vec3Zero()
+		for i := range count {
+			u, v := lightSamplePoint(i, shift)
+			sum = sum.Add(shadePixelSample(vox, hit, light.Sample(hit.Position, u, v), tmax))
 		}
+		intensity = intensity.Add(sum.Div(float32(count)))
+	}
+	return intensity
+}
 
-		shadowcast := vox.MarchRay(recastray)
-
-		// If we don't hit anything, the pixel has direct view of the light, as the rayline
-		// has no obstruction
-		if !shadowcast.Hit {
-			brightness := math32.Max(0.0, hit.Normal.Dot(lightdir)) / (lightdist * lightdist)
-			intensity = intensity.Add(light.Color.Mul(brightness))
+// Same as GetPixelShading, but with a single random shadow ray per light. Point lights are
+// treated as spheres of the given radius. A single call is noisy, averaging many gives soft shadows
+func GetPixelShadingSoft(vox *vxl.Voxels, hit vxl.RayHit, tmax, radius float32, ambient *Ambient, rng *rand.Rand) te.Vector3 {
+	intensity := ambient.occludedLight(vox, hit, [2]float32{rng.Float32(), rng.Float32()})
+	for _, light := range vox.Lights {
+		if point, ok := light.(vxl.Light); ok && radius > 0 {
+			light = vxl.SphereLight{Position: point.Position, Radius: radius, Color: point.Color}
 		}
+		sample := light.Sample(hit.Position, rng.Float32(), rng.Float32())
+		intensity = intensity.Add(shadePixelSample(vox, hit, sample, tmax))
 	}
-
 	return intensity
 }
 
+func shadePixelSample(vox *vxl.Voxels, hit vxl.RayHit, sample vxl.LightSample, tmax float32) te.Vector3 {
+	cosine := hit.Normal.Dot(sample.Dir)
+	if cosine <= 0 || sample.Radiance.Max() <= 0 {
+		return te.Vec3Zero()
+	}
+	// Starting on the face puts the origin in either the hit voxel, which the ray
+	// skips, or the empty cell in front of it
+	recastray := vxl.Ray{
+		Origin: hit.Position,
+		Dir:    sample.Dir,
+		Tmax:   math32.Min(sample.Dist, tmax),
+	}
+
+	// If we don't hit anything, the pixel has direct view of the light, as the rayline
+	// has no obstruction. Glass in the way tints it
+	return sample.Radiance.Mul(cosine).MulComponent(vox.Transmittance(recastray))
+}
+
+// Where the i-th shadow ray lands on a light with an area. The R2 sequence, shifted
+// per shaded point so neighbouring pixels don't all pick the same points and band
+func lightSamplePoint(i int, shift [2]float32) (float32, float32) {
+	const a1, a2 = 0.7548776662466927, 0.5698402909980532
+	u := shift[0] + a1*float32(i)
+	v := shift[1] + a2*float32(i)
+	return u - math32.Floor(u), v - math32.Floor(v)
+}
+
+func sampleShift(p te.Vector3) [2]float32 {
+	h := math32.Float32bits(p.X)*73856093 ^ math32.Float32bits(p.Y)*19349663 ^ math32.Float32bits(p.Z)*83492791
+	h ^= h >> 16
+	h *= 0x7feb352d
+	h ^= h >> 15
+	return [2]float32{float32(h&0xffff) / 65536.0, float32(h>>16) / 65536.0}
+}
+
 // Gets the per-voxel lighting from cache or calculating it
-func GetVoxelShading(vox *vxl.Voxels, hit vxl.RayHit, tmax float32) te.Vector3 {
+// Ambient light isn't cached, as occlusion changes over the face
+func GetVoxelShading(vox *vxl.Voxels, hit vxl.RayHit, tmax float32, ambient *Ambient) te.Vector3 {
 	x, y, z := hit.IntPos[0], hit.IntPos[1], hit.IntPos[2]
 	idx := vox.Index(x, y, z)
 
@@ -44,48 +86,68 @@ func GetVoxelShading(vox *vxl.Voxels, hit vxl.RayHit, tmax float32) te.Vector3 {
 		light = vox.Lighting[idx]
 	} else {
 		light = shadeVoxel(vox, hit, tmax)
-		vox.Lighting[idx] = light
-		vox.LightCached.Set(idx)
+		vox.CacheLighting(idx, light)
 	}
 
 	brightness := math32.Max(0.0, hit.Normal.Dot(light.Dir))
-	return light.Light.Mul(brightness)
+	return light.Light.Mul(brightness).Add(ambient.Light(vox, hit))
 }
 
 // Performs the per-voxel lighting (attempts to at least) by caching shadow data from the voxel face center
 //
-// This actually now works really well, there is no flickering however there is an
-// issue where walls must be < 1 voxel thick when using this or they won't actually
-// be opaque, as the light ray basically jumps out to the nearest corner of the
-// parent voxel
+// The lighting is shared by every face of the voxel, so the shadow rays leave through
+// whichever face looks most towards the light rather than the face that was hit
 func shadeVoxel(vox *vxl.Voxels, hit vxl.RayHit, tmax float32) vxl.CachedLighting {
 	intensity := te.Vec3Zero()
 	direction := te.Vec3Zero()
 	x, y, z := float32(hit.IntPos[0]), float32(hit.IntPos[1]), float32(hit.IntPos[2])
 	voxelcenter := te.Vec3(x+0.5, y+0.5, z+0.5)
-	distanceoutvoxel := math32.Sqrt(0.5 * 0.5 * 3)
+	shift := sampleShift(voxelcenter)
 	for _, light := range vox.Lights {
-		lightpos := light.Position.Sub(voxelcenter)
-		lightdist := lightpos.Len()
-		lightdir := lightpos.Div(lightdist)
-		outsidedirec := lightdir.SignVec() // Shift over to one of the corners for the shadow vector's origin
-		recastpos := voxelcenter.Add(outsidedirec.Mul(distanceoutvoxel + vxl.VoxelRayDelta))
-		recastray := vxl.Ray{
-			Origin: recastpos,
-			Dir:    lightdir,
-			Tmax:   math32.Min(lightdist-distanceoutvoxel-vxl.VoxelRayDelta, tmax),
-		}
+		count := light.Samples()
+		for i := range count {
+			u, v := lightSamplePoint(i, shift)
+			sample := light.Sample(voxelcenter, u, v)
+			if sample.Radiance.Max() <= 0 {
+				continue
+			}
+			face := faceTowards(sample.Dir)
+			recastray := vxl.Ray{
+				Origin: voxelcenter.Add(face.Mul(0.5)),
+				Dir:    sample.Dir,
+				Tmax:   math32.Min(sample.Dist-sample.Dir.Dot(face.Mul(0.5)), tmax),
+				// On the positive side the origin lies in the neighbor the face is shared
+				// with, which should still block the light. On the negative side it lies
+				// in this voxel, which shouldn't
+				ReportStart: face.X+face.Y+face.Z > 0,
+			}
 
-		shadowcast := vox.MarchRay(recastray)
-
-		// If we don't hit anything, the pixel has direct view of the light, as the rayline
-		// has no obstruction
-		if !shadowcast.Hit {
-			intensity = intensity.Add(light.Color.Div(lightdist * lightdist))
-			direction = direction.Add(lightpos)
+			// If we don't hit anything, the pixel has direct view of the light, as the rayline
+			// has no obstruction
+			through := vox.Transmittance(recastray)
+			if through.Max() > 0 {
+				radiance := sample.Radiance.MulComponent(through)
+				intensity = intensity.Add(radiance.Div(float32(count)))
+				direction = direction.Add(sample.Dir.Mul(radiance.Max() / float32(count)))
+			}
 		}
 	}
-	direction = direction.Normalized()
+	// Fully shadowed voxels have no direction, and normalizing zero gives NaN
+	if direction.Max() != 0 || direction.Min() != 0 {
+		direction = direction.Normalized()
+	}
 
 	return vxl.CachedLighting{Light: intensity, Dir: direction}
 }
+
+// Normal of the voxel face that faces dir the most
+func faceTowards(dir te.Vector3) te.Vector3 {
+	a := dir.Abs()
+	switch {
+	case a.X >= a.Y && a.X >= a.Z:
+		return te.Vec3(dir.SignVec().X, 0, 0)
+	case a.Y >= a.Z:
+		return te.Vec3(0, dir.SignVec().Y, 0)
+	}
+	return te.Vec3(0, 0, dir.SignVec().Z)
+}
@@ -0,0 +1,71 @@
+package render
+
+import (
+	"testing"
+
+	te "github.com/zheskett/go-voxel/internal/tensor"
+	vxl "github.com/zheskett/go-voxel/internal/voxel"
+)
+
+// Hit on the floor of testWorld straight below (x, z)
+func floorHit(t *testing.T, vox *vxl.Voxels, x, z float32) vxl.RayHit {
+	hit := vox.MarchRay(vxl.Ray{Origin: te.Vec3(x, 3, z), Dir: te.Vec3(0, -1, 0), Tmax: 10})
+	if !hit.Hit || hit.IntPos[1] != 0 {
+		t.Fatalf("Expected to hit the floor below (%v, %v)", x, z)
+	}
+	return hit
+}
+
+// TestAreaLightPenumbra puts the edge of a slab between the floor and a
+// rectangle light and expects the floor under the edge to be partly lit.
+func TestAreaLightPenumbra(t *testing.T) {
+	vox := testWorld()
+	vox.Lights = []vxl.LightSource{vxl.RectLight{
+		Position: te.Vec3(16, 10, 16),
+		U:        te.Vec3(4, 0, 0),
+		V:        te.Vec3(0, 0, 4),
+		Color:    te.Vec3Splat(500),
+		Count:    64,
+	}}
+	lit := GetPixelShading(&vox, floorHit(t, &vox, 16.5, 16.5), 100, nil).X
+
+	for x := range 16 {
+		for z := range vox.Z {
+			vox.SetVoxel(x, 5, z, 255, 255, 255)
+		}
+	}
+	penumbra := GetPixelShading(&vox, floorHit(t, &vox, 16.5, 16.5), 100, nil).X
+	umbra := GetPixelShading(&vox, floorHit(t, &vox, 4.5, 16.5), 100, nil).X
+	if umbra != 0 {
+		t.Errorf("Expected no light far under the slab, got %v", umbra)
+	}
+	if penumbra <= 0.3*lit || penumbra >= 0.9*lit {
+		t.Errorf("Expected partial light under the edge, got %v of %v", penumbra, lit)
+	}
+
+	voxel := GetVoxelShading(&vox, floorHit(t, &vox, 16.5, 16.5), 100, nil).X
+	if voxel <= 0 || voxel >= lit {
+		t.Errorf("Expected per voxel shading to be partly lit too, got %v of %v", voxel, lit)
+	}
+}
+
+// TestDirectionalLightShading expects a sun to light open floor evenly in both
+// shading functions and to be blocked by a roof.
+func TestDirectionalLightShading(t *testing.T) {
+	vox := testWorld()
+	vox.Lights = []vxl.LightSource{vxl.DirectionalLight{Direction: te.Vec3(0, -1, 0), Color: te.Vec3Splat(0.8)}}
+	for _, x := range []float32{2.5, 16.5, 30.5} {
+		hit := floorHit(t, &vox, x, 8.5)
+		if got := GetPixelShading(&vox, hit, 100, nil); got != te.Vec3Splat(0.8) {
+			t.Errorf("Expected 0.8 per pixel at x %v, got %v", x, got)
+		}
+		if got := GetVoxelShading(&vox, hit, 100, nil); got != te.Vec3Splat(0.8) {
+			t.Errorf("Expected 0.8 per voxel at x %v, got %v", x, got)
+		}
+	}
+
+	vox.SetVoxel(16, 20, 8, 255, 255, 255)
+	if got := GetPixelShading(&vox, floorHit(t, &vox, 16.5, 8.5), 100, nil); got.Max() != 0 {
+		t.Errorf("Expected the roof to shade the floor, got %v", got)
+	}
+}
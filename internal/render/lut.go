@@ -0,0 +1,179 @@
+package render
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	te "github.com/zheskett/go-voxel/internal/tensor"
+)
+
+// LUT is a 3D color lookup table from sRGB colors to graded ones, both in [0, 1]
+type LUT struct {
+	Size int
+	data []float32 // 3 floats per entry, red changing fastest then green
+}
+
+// A LUT that leaves colors as they are
+func IdentityLUT(size int) *LUT {
+	return LUTFromFunc(size, func(c te.Vector3) te.Vector3 { return c })
+}
+
+// Bakes fn into a LUT, by calling it on every entry
+func LUTFromFunc(size int, fn func(te.Vector3) te.Vector3) *LUT {
+	lut := &LUT{Size: size, data: make([]float32, 0, size*size*size*3)}
+	step := 1 / float32(size-1)
+	for b := range size {
+		for g := range size {
+			for r := range size {
+				c := fn(te.Vec3(float32(r)*step, float32(g)*step, float32(b)*step))
+				lut.data = append(lut.data, c.X, c.Y, c.Z)
+			}
+		}
+	}
+	return lut
+}
+
+// A LUT that changes the saturation and contrast, 1 for both leaves them as they are,
+// and warms colors up by warmth, or cools them for negative values
+func GradeLUT(size int, saturation, contrast, warmth float32) *LUT {
+	return LUTFromFunc(size, func(c te.Vector3) te.Vector3 {
+		gray := luma(c)
+		c = mix(te.Vec3Splat(gray), c, saturation)
+		c = c.Sub(te.Vec3Splat(0.5)).Mul(contrast).Add(te.Vec3Splat(0.5))
+		c = c.MulComponent(te.Vec3(1+warmth, 1, 1-warmth))
+		return c.ComponentClamp(0, 1)
+	})
+}
+
+func (lut *LUT) entry(r, g, b int) te.Vector3 {
+	i := 3 * ((b*lut.Size+g)*lut.Size + r)
+	return te.Vec3(lut.data[i], lut.data[i+1], lut.data[i+2])
+}
+
+// Looks up c, blending between the 8 nearest entries
+func (lut *LUT) Lookup(c te.Vector3) te.Vector3 {
+	scaled := c.ComponentClamp(0, 1).Mul(float32(lut.Size - 1))
+	r0, g0, b0 := int(scaled.X), int(scaled.Y), int(scaled.Z)
+	r1, g1, b1 := min(r0+1, lut.Size-1), min(g0+1, lut.Size-1), min(b0+1, lut.Size-1)
+	fr, fg, fb := scaled.X-float32(r0), scaled.Y-float32(g0), scaled.Z-float32(b0)
+
+	c00 := mix(lut.entry(r0, g0, b0), lut.entry(r1, g0, b0), fr)
+	c10 := mix(lut.entry(r0, g1, b0), lut.entry(r1, g1, b0), fr)
+	c01 := mix(lut.entry(r0, g0, b1), lut.entry(r1, g0, b1), fr)
+	c11 := mix(lut.entry(r0, g1, b1), lut.entry(r1, g1, b1), fr)
+	return mix(mix(c00, c10, fg), mix(c01, c11, fg), fb)
+}
+
+// Loads a .cube LUT
+func LoadCubeLUT(path string) (*LUT, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return ReadCubeLUT(file)
+}
+
+// Reads a 3D LUT in the .cube format most grading tools export
+func ReadCubeLUT(r io.Reader) (*LUT, error) {
+	lut := &LUT{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		switch fields[0] {
+		case "TITLE":
+			continue
+		case "LUT_3D_SIZE":
+			size, err := strconv.Atoi(fields[len(fields)-1])
+			if err != nil || size < 2 || size > 256 {
+				return nil, fmt.Errorf("Bad LUT size: %v", line)
+			}
+			lut.Size = size
+			lut.data = make([]float32, 0, size*size*size*3)
+			continue
+		case "LUT_1D_SIZE":
+			return nil, fmt.Errorf("Only 3D LUTs are supported")
+		case "DOMAIN_MIN", "DOMAIN_MAX":
+			want := 0.0
+			if fields[0] == "DOMAIN_MAX" {
+				want = 1
+			}
+			for _, field := range fields[1:] {
+				if v, err := strconv.ParseFloat(field, 32); err != nil || v != want {
+					return nil, fmt.Errorf("Only LUTs over 0 to 1 are supported: %v", line)
+				}
+			}
+			continue
+		}
+
+		if lut.Size == 0 {
+			return nil, fmt.Errorf("LUT entries before LUT_3D_SIZE")
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("Bad LUT entry: %v", line)
+		}
+		for _, field := range fields {
+			v, err := strconv.ParseFloat(field, 32)
+			if err != nil {
+				return nil, fmt.Errorf("Bad LUT entry: %v", line)
+			}
+			lut.data = append(lut.data, float32(v))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if lut.Size == 0 || len(lut.data) != lut.Size*lut.Size*lut.Size*3 {
+		return nil, fmt.Errorf("LUT has %v entries, expected %v", len(lut.data)/3, lut.Size*lut.Size*lut.Size)
+	}
+	return lut, nil
+}
+
+// Writes the LUT in the .cube format
+func (lut *LUT) WriteCube(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "LUT_3D_SIZE %v\n", lut.Size)
+	for i := 0; i < len(lut.data); i += 3 {
+		fmt.Fprintf(bw, "%.6f %.6f %.6f\n", lut.data[i], lut.data[i+1], lut.data[i+2])
+	}
+	return bw.Flush()
+}
+
+// Grades the frame through a LUT
+type ColorGrade struct {
+	LUT      *LUT    // Does nothing without one
+	Strength float32 // Blend between the original colors at 0 and graded ones at 1
+}
+
+// Grades with a mild contrast and saturation boost until given another LUT
+func ColorGradeInit() *ColorGrade {
+	return &ColorGrade{LUT: GradeLUT(17, 1.15, 1.1, 0.03), Strength: 1}
+}
+
+func (cg *ColorGrade) Name() string {
+	return "grade"
+}
+
+func (cg *ColorGrade) Apply(pix *Pixels, _ *AOVs) error {
+	if cg.LUT == nil {
+		return nil
+	}
+	DefaultScheduler().RenderTiles(pix.Width, pix.Height, func(x0, y0, x1, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				b := pix.GetPixel(x, y)
+				c := te.Vec3(float32(b[0]), float32(b[1]), float32(b[2])).Div(255)
+				setPixelFloat(pix, x, y, mix(c, cg.LUT.Lookup(c), cg.Strength))
+			}
+		}
+	})
+	return nil
+}
@@ -0,0 +1,192 @@
+package render
+
+import (
+	"math/rand/v2"
+
+	"github.com/chewxy/math32"
+	te "github.com/zheskett/go-voxel/internal/tensor"
+	vxl "github.com/zheskett/go-voxel/internal/voxel"
+)
+
+const (
+	// How far rays are pushed into glass so they start inside it
+	glassNudge = 1e-3
+	// Times a ray can bounce around inside glass before it's treated as absorbed
+	maxInternalReflections = 4
+)
+
+// Shades camera rays, following them off metal and through glass
+type shader struct {
+	vox     *vxl.Voxels
+	ambient *Ambient
+	env     *Environment
+	tmax    float32
+	bounces int // Reflections and refractions followed before shading everything as diffuse
+
+	// Set for the noisy progressive version, which picks random shadow, reflection and
+	// refraction rays. Otherwise they're picked the same way for the same point every frame
+	rng         *rand.Rand
+	lightRadius float32
+}
+
+func (cam *Camera) shader(vox *vxl.Voxels) shader {
+	return shader{vox: vox, ambient: &cam.Ambient, env: &cam.Environment, tmax: cam.RenderDistance, bounces: cam.MaxReflections}
+}
+
+// Linear light coming back along the ray, through the fog. Misses see the sky
+func (sh *shader) trace(ray vxl.Ray, depth int) te.Vector3 {
+	return sh.light(ray, sh.vox.MarchRay(ray), depth)
+}
+
+// Same as trace, for a ray that's already been marched
+func (sh *shader) light(ray vxl.Ray, hit vxl.RayHit, depth int) te.Vector3 {
+	if !hit.Hit {
+		keep, add := sh.env.fog(ray, ray.Tmax, sh.vox.Lights)
+		return sh.env.Background(ray.Dir, sh.vox.Lights).Mul(keep).Add(add)
+	}
+	// Reflections start part way along, with what's left of the render distance
+	keep, add := sh.env.attenuate(ray, hit.Time, sh.tmax-ray.Tmax, sh.tmax, sh.vox.Lights)
+	return sh.shade(ray, hit, depth).Mul(keep).Add(add)
+}
+
+func (sh *shader) shade(ray vxl.Ray, hit vxl.RayHit, depth int) te.Vector3 {
+	// Lighting happens in linear space, the palette is sRGB
+	albedo := SRGBColorToLinear(hit.Color)
+	mat := sh.vox.MaterialProps(sh.vox.Material(hit.Index))
+	if depth < sh.bounces {
+		switch mat.Kind {
+		case vxl.KindMetal:
+			u, v := sh.random(hit)
+			dir := roughen(reflect(ray.Dir, hit.Normal), hit.Normal, mat.Roughness, u, v)
+			light := sh.trace(vxl.Ray{Origin: hit.Position, Dir: dir, Tmax: ray.Tmax - hit.Time}, depth+1)
+			return light.MulComponent(albedo)
+		case vxl.KindGlass:
+			return sh.glass(ray, hit, mat, depth)
+		}
+	}
+
+	var light te.Vector3
+	if sh.rng == nil {
+		/* Two choices for lighting, doing it per pixel or per voxel */
+		light = GetPixelShading(sh.vox, hit, sh.tmax, sh.ambient)
+		// light = GetVoxelShading(sh.vox, hit, sh.tmax, sh.ambient)
+	} else {
+		light = GetPixelShadingSoft(sh.vox, hit, sh.tmax, sh.lightRadius, sh.ambient, sh.rng)
+	}
+	color := light.MulComponent(albedo)
+	if mat.Kind == vxl.KindEmissive {
+		color = color.Add(albedo.Mul(mat.Emission))
+	}
+	return color
+}
+
+// Mixes the reflection and the light coming through the glass by the Fresnel term.
+// The progressive version picks one of the two at random instead of tracing both
+func (sh *shader) glass(ray vxl.Ray, hit vxl.RayHit, mat vxl.Material, depth int) te.Vector3 {
+	remaining := ray.Tmax - hit.Time
+	u, v := sh.random(hit)
+	reflectance := schlick(-ray.Dir.Dot(hit.Normal), mat.IOR)
+
+	reflected := func() te.Vector3 {
+		dir := roughen(reflect(ray.Dir, hit.Normal), hit.Normal, mat.Roughness, u, v)
+		light := sh.trace(vxl.Ray{Origin: hit.Position, Dir: dir, Tmax: remaining}, depth+1)
+		return light
+	}
+	transmitted := func() te.Vector3 {
+		// Entering from air always refracts
+		dir, _ := refract(ray.Dir, hit.Normal, 1/mat.IOR)
+		dir = roughen(dir, hit.Normal.Neg(), mat.Roughness, u, v)
+		out, tint, ok := crossGlass(sh.vox, hit, mat, dir, remaining)
+		if !ok {
+			return te.Vec3Zero()
+		}
+		light := sh.trace(out, depth+1)
+		return light.MulComponent(tint)
+	}
+
+	if sh.rng != nil {
+		if sh.rng.Float32() < reflectance {
+			return reflected()
+		}
+		return transmitted()
+	}
+	return reflected().Mul(reflectance).Add(transmitted().Mul(1 - reflectance))
+}
+
+// Two numbers in [0, 1) for picking rough reflections
+func (sh *shader) random(hit vxl.RayHit) (float32, float32) {
+	if sh.rng != nil {
+		return sh.rng.Float32(), sh.rng.Float32()
+	}
+	shift := sampleShift(hit.Position)
+	return shift[0], shift[1]
+}
+
+// Follows a ray that was just refracted into the glass at hit to where it comes back
+// out, bending it again on the way. Returns the ray carrying on from there and how much
+// of each color is left of the light, or false if the glass swallows the ray
+func crossGlass(vox *vxl.Voxels, hit vxl.RayHit, mat vxl.Material, dir te.Vector3, tmax float32) (vxl.Ray, te.Vector3, bool) {
+	medium := vox.Material(hit.Index)
+	origin := hit.Position.Add(dir.Mul(glassNudge))
+	tint := te.Vec3Splat(1)
+	for range maxInternalReflections {
+		inner := vox.MarchRay(vxl.Ray{Origin: origin, Dir: dir, Tmax: tmax, ReportStart: true, Medium: medium})
+		if !inner.Hit {
+			return vxl.Ray{}, te.Vec3Zero(), false
+		}
+		// The whole stretch is tinted by the color where the ray went in
+		tint = tint.MulComponent(mat.Absorb(hit.Color, inner.Time))
+		tmax -= inner.Time
+
+		if vox.Presence.Get(inner.Index) {
+			// Something solid right up against the glass, carry on straight into it from inside the glass
+			return vxl.Ray{Origin: inner.Position.Sub(dir.Mul(glassNudge)), Dir: dir, Tmax: tmax + glassNudge}, tint, true
+		}
+		// The hit's normal faces back into the glass, against the ray
+		if out, ok := refract(dir, inner.Normal, mat.IOR); ok {
+			return vxl.Ray{Origin: inner.Position, Dir: out, Tmax: tmax}, tint, true
+		}
+		dir = reflect(dir, inner.Normal)
+		origin = inner.Position.Add(dir.Mul(glassNudge))
+	}
+	return vxl.Ray{}, te.Vec3Zero(), false
+}
+
+// Mirrors dir about the surface with normal n
+func reflect(dir, n te.Vector3) te.Vector3 {
+	return dir.Sub(n.Mul(2 * dir.Dot(n)))
+}
+
+// Bends dir going through a surface with normal n facing against it, eta is the ratio of
+// the indices of refraction from the side it comes from over the side it goes to.
+// false for total internal reflection
+func refract(dir, n te.Vector3, eta float32) (te.Vector3, bool) {
+	cosine := -dir.Dot(n)
+	k := 1 - eta*eta*(1-cosine*cosine)
+	if k < 0 {
+		return te.Vec3Zero(), false
+	}
+	return dir.Mul(eta).Add(n.Mul(eta*cosine - math32.Sqrt(k))).Normalized(), true
+}
+
+// Schlick's approximation of how much light a surface reflects at the given angle
+func schlick(cosine, ior float32) float32 {
+	r0 := (1 - ior) / (1 + ior)
+	r0 *= r0
+	return r0 + (1-r0)*math32.Pow(1-math32.Max(cosine, 0), 5)
+}
+
+// Scatters dir by roughness, keeping it on the side of the surface n points to
+func roughen(dir, n te.Vector3, roughness, u, v float32) te.Vector3 {
+	if roughness <= 0 {
+		return dir
+	}
+	z := 1 - 2*u
+	r, phi := math32.Sqrt(math32.Max(0, 1-z*z)), 2*math32.Pi*v
+	sphere := te.Vec3(r*math32.Cos(phi), r*math32.Sin(phi), z)
+	rough := dir.Add(sphere.Mul(roughness)).NormalizedOrZero()
+	if rough.Dot(n) <= 0 {
+		return dir
+	}
+	return rough
+}
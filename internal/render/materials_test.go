@@ -0,0 +1,64 @@
+package render
+
+import (
+	"testing"
+
+	te "github.com/zheskett/go-voxel/internal/tensor"
+	vxl "github.com/zheskett/go-voxel/internal/voxel"
+)
+
+// TestMirrorReflects expects a metal floor to show the wall it faces, tinted by its own color.
+func TestMirrorReflects(t *testing.T) {
+	vox := testWorld()
+	mirror, _ := vox.AddMaterial(vxl.Material{Kind: vxl.KindMetal})
+	vox.SetMaterial(8, 0, 16, mirror)
+	for y := range vox.Y {
+		for z := range vox.Z {
+			vox.SetVoxel(0, y, z, 40, 200, 40)
+		}
+	}
+	pix := PixelsInit(4, 4)
+	cam := testCamera(&pix)
+	sh := cam.shader(&vox)
+
+	// Looking down at 45 degrees, straight into the wall after the floor
+	ray := vxl.Ray{Origin: te.Vec3(12, 4.5, 16.5), Dir: te.Vec3(-1, -1, 0).Normalized(), Tmax: 64}
+	got := sh.trace(ray, 0)
+	wall := sh.trace(vxl.Ray{Origin: te.Vec3(8.5, 1, 16.5), Dir: te.Vec3(-1, 1, 0).Normalized(), Tmax: 64}, 1)
+	if want := wall.MulComponent(SRGBColorToLinear([3]byte{200, 200, 200})); !closeToVec(got, want, 1e-5) {
+		t.Errorf("Expected the reflected wall %v, got %v", want, got)
+	}
+	if got.Y <= got.X {
+		t.Errorf("Expected the mirror to look green, got %v", got)
+	}
+
+	sh.bounces = 0
+	if flat := sh.trace(ray, 0); flat.Y > 2*flat.X {
+		t.Errorf("Expected no reflection without bounces, got %v", flat)
+	}
+}
+
+// TestGlassLetsLightThrough expects a glass block to tint the floor's shadow
+// and to show the floor through it.
+func TestGlassLetsLightThrough(t *testing.T) {
+	vox := testWorld()
+	for x := 14; x < 19; x++ {
+		for z := 14; z < 19; z++ {
+			vox.SetVoxel(x, 10, z, 80, 80, 255)
+			vox.SetMaterial(x, 10, z, vxl.MaterialGlass)
+		}
+	}
+	floor := vox.MarchRay(vxl.Ray{Origin: te.Vec3(16.5, 3, 16.5), Dir: te.Vec3(0, -1, 0), Tmax: 64})
+	light := GetPixelShading(&vox, floor, 64, nil)
+	if light.Z <= 0 || light.Z <= light.X {
+		t.Errorf("Expected blue light under the glass, got %v", light)
+	}
+
+	pix := PixelsInit(4, 4)
+	cam := testCamera(&pix)
+	sh := cam.shader(&vox)
+	got := sh.trace(vxl.Ray{Origin: te.Vec3(16.5, 20, 16.5), Dir: te.Vec3(0, -1, 0), Tmax: 64}, 0)
+	if got.Z <= got.X {
+		t.Errorf("Expected the floor to look blue through the glass, got %v", got)
+	}
+}
@@ -0,0 +1,125 @@
+package render
+
+import (
+	"math/rand/v2"
+
+	"github.com/chewxy/math32"
+	te "github.com/zheskett/go-voxel/internal/tensor"
+	vxl "github.com/zheskett/go-voxel/internal/voxel"
+)
+
+// PathTracer is the slow reference renderer, for checking the fast shading against
+// and for stills. Every sample follows a path of diffuse bounces through the world,
+// adding the light of emissive voxels and the camera's sky it runs into, and at every
+// bounce a shadow ray towards a random point on each of the world's lights. Emissive
+// voxels' VoxelLights get no shadow rays, paths already pick up their light by hitting them.
+// Metal and glass send the path on in a mirrored or bent direction instead of bouncing it diffusely.
+//
+// Lights follow the same convention as GetPixelShading, so with no bounces, a black sky
+// and no fog it gives the same picture as the fast path without ambient light.
+// Set it as Progressive.PathTrace to render with it
+type PathTracer struct {
+	// Diffuse bounces after the first hit, 0 only has direct light
+	MaxBounces int
+	// Bounces before Russian roulette can end a path early
+	RouletteDepth int
+}
+
+func PathTracerInit() PathTracer {
+	return PathTracer{
+		MaxBounces:    8,
+		RouletteDepth: 3,
+	}
+}
+
+// Light coming back along the ray, in linear RGB, with env's sky and fog
+func (pt *PathTracer) Trace(vox *vxl.Voxels, env *Environment, ray vxl.Ray, rng *rand.Rand) te.Vector3 {
+	radiance := te.Vec3Zero()
+	throughput := te.Vec3Splat(1)
+	tmax := ray.Tmax
+	// The sun's disk is only seen by the camera and in reflections, diffuse bounces get
+	// its light from the directional light instead
+	specular := true
+	for bounce := 0; ; bounce++ {
+		hit := vox.MarchRay(ray)
+		if !hit.Hit {
+			sky := env.SkyLight(ray.Dir, vox.Lights)
+			if specular {
+				sky = env.Background(ray.Dir, vox.Lights)
+			}
+			keep, add := env.fog(ray, ray.Tmax, vox.Lights)
+			return radiance.Add(throughput.MulComponent(sky.Mul(keep).Add(add)))
+		}
+		keep, add := env.attenuate(ray, hit.Time, tmax-ray.Tmax, tmax, vox.Lights)
+		radiance = radiance.Add(throughput.MulComponent(add))
+		throughput = throughput.Mul(keep)
+
+		albedo := SRGBColorToLinear(hit.Color)
+		mat := vox.MaterialProps(vox.Material(hit.Index))
+		if mat.Kind == vxl.KindEmissive {
+			radiance = radiance.Add(throughput.MulComponent(albedo.Mul(mat.Emission)))
+		}
+
+		// Mirror-like surfaces pass the path on without direct light, lights are points
+		// so a reflection could never find them anyway
+		if bounce < pt.MaxBounces && (mat.Kind == vxl.KindMetal || mat.Kind == vxl.KindGlass) {
+			next, tint, ok := pt.scatter(vox, ray, hit, mat, rng)
+			if !ok {
+				return radiance
+			}
+			if mat.Kind == vxl.KindMetal {
+				tint = albedo
+			}
+			throughput = throughput.MulComponent(tint)
+			ray = next
+			specular = true
+			continue
+		}
+
+		// Next event estimation. Point, spot and area lights can't be hit by rays, so this is the only
+		// way they're counted. VoxelLights were already counted by the paths that hit their voxel
+		direct := te.Vec3Zero()
+		for _, light := range vox.Lights {
+			if _, ok := light.(vxl.VoxelLight); ok {
+				continue
+			}
+			sample := light.Sample(hit.Position, rng.Float32(), rng.Float32())
+			direct = direct.Add(shadePixelSample(vox, hit, sample, ray.Tmax))
+		}
+		throughput = throughput.MulComponent(albedo)
+		radiance = radiance.Add(throughput.MulComponent(direct))
+
+		if bounce >= pt.MaxBounces {
+			return radiance
+		}
+		if bounce >= pt.RouletteDepth {
+			// Dim paths are ended at random, and the ones that survive make up for them
+			survive := math32.Min(throughput.Max(), 0.95)
+			if rng.Float32() >= survive {
+				return radiance
+			}
+			throughput = throughput.Div(survive)
+		}
+
+		// Cosine weighted directions cancel out the cosine and the 1/pi of a diffuse surface,
+		// leaving just the albedo, which is already in throughput
+		ray = vxl.Ray{
+			Origin: hit.Position,
+			Dir:    cosineHemisphere(hit.Normal, rng.Float32(), rng.Float32()),
+			Tmax:   ray.Tmax,
+		}
+		specular = false
+	}
+}
+
+// Picks the ray a path carries on along off metal or through glass, and the tint for glass
+func (pt *PathTracer) scatter(vox *vxl.Voxels, ray vxl.Ray, hit vxl.RayHit, mat vxl.Material, rng *rand.Rand) (vxl.Ray, te.Vector3, bool) {
+	remaining := ray.Tmax - hit.Time
+	u, v := rng.Float32(), rng.Float32()
+	if mat.Kind == vxl.KindGlass && rng.Float32() >= schlick(-ray.Dir.Dot(hit.Normal), mat.IOR) {
+		dir, _ := refract(ray.Dir, hit.Normal, 1/mat.IOR)
+		return crossGlass(vox, hit, mat, roughen(dir, hit.Normal.Neg(), mat.Roughness, u, v), remaining)
+	}
+	dir := roughen(reflect(ray.Dir, hit.Normal), hit.Normal, mat.Roughness, u, v)
+	return vxl.Ray{Origin: hit.Position, Dir: dir, Tmax: remaining}, te.Vec3Splat(1), true
+}
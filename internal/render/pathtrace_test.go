@@ -0,0 +1,150 @@
+package render
+
+import (
+	"math/rand/v2"
+	"testing"
+
+	"github.com/chewxy/math32"
+	te "github.com/zheskett/go-voxel/internal/tensor"
+	vxl "github.com/zheskett/go-voxel/internal/voxel"
+)
+
+func closeToVec(a, b te.Vector3, tolerance float32) bool {
+	return a.Sub(b).Abs().Max() <= tolerance
+}
+
+// TestPathTraceDirectMatchesFast expects direct light alone to match the fast
+// shading without ambient light.
+func TestPathTraceDirectMatchesFast(t *testing.T) {
+	vox := testWorld()
+	pt := PathTracerInit()
+	pt.MaxBounces = 0
+	env := EnvironmentInit()
+	env.Zenith = te.Vec3Zero()
+	rng := rand.New(rand.NewPCG(1, 2))
+
+	for _, x := range []float32{4.5, 16.5, 27.5} {
+		ray := vxl.Ray{Origin: te.Vec3(x, 3, 16.5), Dir: te.Vec3(0, -1, 0), Tmax: 64}
+		hit := vox.MarchRay(ray)
+		want := GetPixelShading(&vox, hit, 64, nil).MulComponent(SRGBColorToLinear(hit.Color))
+		if got := pt.Trace(&vox, &env, ray, rng); !closeToVec(got, want, 1e-5) {
+			t.Errorf("Expected %v at x %v, got %v", want, x, got)
+		}
+	}
+}
+
+// TestPathTraceSky expects a floor under an open sky to reflect its albedo times the
+// sky, as every bounce off it escapes, and a missed ray to see the sky itself.
+func TestPathTraceSky(t *testing.T) {
+	vox := testWorld()
+	vox.Lights = vox.Lights[:0]
+	pt := PathTracerInit()
+	env := EnvironmentInit()
+	env.Zenith = te.Vec3(0.2, 0.4, 0.8)
+	rng := rand.New(rand.NewPCG(1, 2))
+
+	down := vxl.Ray{Origin: te.Vec3(16.5, 3, 16.5), Dir: te.Vec3(0, -1, 0), Tmax: 64}
+	want := SRGBColorToLinear([3]byte{200, 200, 200}).MulComponent(env.Zenith)
+	for range 16 {
+		if got := pt.Trace(&vox, &env, down, rng); !closeToVec(got, want, 1e-5) {
+			t.Fatalf("Expected %v off the floor, got %v", want, got)
+		}
+	}
+	up := vxl.Ray{Origin: te.Vec3(16.5, 3, 16.5), Dir: te.Vec3(0, 1, 0), Tmax: 64}
+	if got := pt.Trace(&vox, &env, up, rng); got != env.Zenith {
+		t.Errorf("Expected the sky %v, got %v", env.Zenith, got)
+	}
+}
+
+// TestPathTraceEmissive expects emissive voxels to light the floor around them
+// with nothing else in the world.
+func TestPathTraceEmissive(t *testing.T) {
+	vox := testWorld()
+	vox.Lights = vox.Lights[:0]
+	vox.SetVoxel(16, 4, 16, 255, 128, 0)
+	vox.SetMaterial(16, 4, 16, vxl.MaterialEmissive)
+	pt := PathTracerInit()
+	env := EnvironmentInit()
+	env.Zenith = te.Vec3Zero()
+	rng := rand.New(rand.NewPCG(1, 2))
+
+	at := vxl.Ray{Origin: te.Vec3(16.5, 10, 16.5), Dir: te.Vec3(0, -1, 0), Tmax: 64}
+	want := SRGBColorToLinear([3]byte{255, 128, 0}).Mul(vox.Materials[vxl.MaterialEmissive].Emission)
+	if got := pt.Trace(&vox, &env, at, rng); got.X < want.X || got.Y < want.Y {
+		t.Errorf("Expected at least %v looking at the emissive voxel, got %v", want, got)
+	}
+
+	// Averaged, since only paths that bounce into the voxel pick up its light
+	floor := te.Vec3Zero()
+	below := vxl.Ray{Origin: te.Vec3(18.5, 3, 16.5), Dir: te.Vec3(0, -1, 0), Tmax: 64}
+	for range 256 {
+		floor = floor.Add(pt.Trace(&vox, &env, below, rng))
+	}
+	if floor.X <= 0 || floor.X <= floor.Z || math32.IsNaN(floor.X) {
+		t.Errorf("Expected orange light on the floor by the voxel, got %v", floor.Div(256))
+	}
+}
+
+// TestPathTraceEmissiveLights expects adding an emissive voxel's EmissiveLights to leave
+// the path traced floor as bright as before, since paths already pick up its light.
+func TestPathTraceEmissiveLights(t *testing.T) {
+	vox := testWorld()
+	vox.Lights = vox.Lights[:0]
+	glow, err := vox.AddMaterial(vxl.Material{Kind: vxl.KindEmissive, Emission: 4, Light: true})
+	if err != nil {
+		t.Fatalf("AddMaterial: %v", err)
+	}
+	vox.SetVoxel(16, 4, 16, 255, 255, 255)
+	vox.SetMaterial(16, 4, 16, glow)
+	pt := PathTracerInit()
+	env := EnvironmentInit()
+	env.Zenith = te.Vec3Zero()
+
+	floor := func() te.Vector3 {
+		rng := rand.New(rand.NewPCG(1, 2))
+		sum := te.Vec3Zero()
+		below := vxl.Ray{Origin: te.Vec3(18.5, 3, 16.5), Dir: te.Vec3(0, -1, 0), Tmax: 64}
+		for range 1024 {
+			sum = sum.Add(pt.Trace(&vox, &env, below, rng))
+		}
+		return sum.Div(1024)
+	}
+	without := floor()
+	vox.Lights = append(vox.Lights, vox.EmissiveLights()...)
+	if len(vox.Lights) != 1 {
+		t.Fatalf("Expected one light for the emissive voxel, got %v", len(vox.Lights))
+	}
+	with := floor()
+	if without.X <= 0 || !closeToVec(with, without, without.X/20) {
+		t.Errorf("Expected the floor as bright with the voxel's light as without, got %v and %v", with, without)
+	}
+}
+
+// TestProgressivePathTraceResets expects switching to the path tracer, or changing it, to start over.
+func TestProgressivePathTraceResets(t *testing.T) {
+	vox := testWorld()
+	pix := PixelsInitHDR(16, 12)
+	cam := testCamera(&pix)
+	pg := ProgressiveInit()
+	pg.Render(&cam, &vox, &pix)
+	pg.Render(&cam, &vox, &pix)
+
+	pt := PathTracerInit()
+	pg.PathTrace = &pt
+	pg.Render(&cam, &vox, &pix)
+	if pg.Samples() != 1 {
+		t.Errorf("Expected turning on path tracing to reset, got %v samples", pg.Samples())
+	}
+	pg.Render(&cam, &vox, &pix)
+	pt.MaxBounces = 2
+	pg.Render(&cam, &vox, &pix)
+	if pg.Samples() != 1 {
+		t.Errorf("Expected changing the path tracer to reset, got %v samples", pg.Samples())
+	}
+	pg.Render(&cam, &vox, &pix)
+	pg.PathTrace = nil
+	pg.Render(&cam, &vox, &pix)
+	if pg.Samples() != 1 {
+		t.Errorf("Expected turning off path tracing to reset, got %v samples", pg.Samples())
+	}
+}
@@ -0,0 +1,312 @@
+package render
+
+import (
+	"fmt"
+
+	"github.com/chewxy/math32"
+	te "github.com/zheskett/go-voxel/internal/tensor"
+)
+
+// PostEffect is one stage of a PostStack. Effects work on the bytes of Pixels, after
+// HDRPipeline.Resolve has tone mapped them
+type PostEffect interface {
+	// Short lowercase name to find and toggle the effect by
+	Name() string
+	// Changes pix in place. aov is nil when the frame has none, effects that want
+	// them fall back to working from the colors alone or do nothing
+	Apply(pix *Pixels, aov *AOVs) error
+}
+
+type PostStage struct {
+	Effect  PostEffect
+	Enabled bool
+}
+
+// PostStack runs its enabled effects over a finished frame, in order
+type PostStack struct {
+	Stages []PostStage
+}
+
+// Every built in effect, turned off, in the order they work best in
+func PostStackInit() PostStack {
+	return PostStack{Stages: []PostStage{
+		{Effect: FXAAInit()},
+		{Effect: OutlineInit()},
+		{Effect: ColorGradeInit()},
+		{Effect: VignetteInit()},
+		{Effect: PixelateInit()},
+		{Effect: DitherInit()},
+	}}
+}
+
+// Runs every enabled effect over pix, stopping at the first that fails
+func (ps *PostStack) Apply(pix *Pixels, aov *AOVs) error {
+	for _, stage := range ps.Stages {
+		if !stage.Enabled {
+			continue
+		}
+		if err := stage.Effect.Apply(pix, aov); err != nil {
+			return fmt.Errorf("Post effect %v: %w", stage.Effect.Name(), err)
+		}
+	}
+	return nil
+}
+
+// Finds an effect by name, to change its settings. nil if there's none
+func (ps *PostStack) Effect(name string) PostEffect {
+	if stage := ps.stage(name); stage != nil {
+		return stage.Effect
+	}
+	return nil
+}
+
+// Turns an effect on or off, false if there's no such effect
+func (ps *PostStack) Enable(name string, enabled bool) bool {
+	stage := ps.stage(name)
+	if stage == nil {
+		return false
+	}
+	stage.Enabled = enabled
+	return true
+}
+
+func (ps *PostStack) stage(name string) *PostStage {
+	for i := range ps.Stages {
+		if ps.Stages[i].Effect.Name() == name {
+			return &ps.Stages[i]
+		}
+	}
+	return nil
+}
+
+// Reads the bytes of pix into linear-in-bytes floats in [0, 1], 3 per pixel, reusing buf
+func pixelFloats(pix *Pixels, buf []float32) []float32 {
+	buf = buf[:0]
+	for i := range pix.Width * pix.Height {
+		buf = append(buf, float32(pix.data[4*i])/255, float32(pix.data[4*i+1])/255, float32(pix.data[4*i+2])/255)
+	}
+	return buf
+}
+
+func setPixelFloat(pix *Pixels, x, y int, c te.Vector3) {
+	c = c.ComponentClamp(0, 1)
+	pix.SetPixel(x, y, byte(c.X*255+0.5), byte(c.Y*255+0.5), byte(c.Z*255+0.5))
+}
+
+// Luma of the sRGB encoded color, what edge detection looks at
+func luma(c te.Vector3) float32 {
+	return 0.299*c.X + 0.587*c.Y + 0.114*c.Z
+}
+
+// Darkens the corners of the frame
+type Vignette struct {
+	Strength float32 // How dark the corners get, 1 is black
+	Radius   float32 // Distance from the middle darkening ends at, 1 is the corners
+	Softness float32 // Distance darkening starts before Radius
+}
+
+func VignetteInit() *Vignette {
+	return &Vignette{Strength: 0.5, Radius: 1.1, Softness: 0.7}
+}
+
+func (vg *Vignette) Name() string {
+	return "vignette"
+}
+
+func (vg *Vignette) Apply(pix *Pixels, _ *AOVs) error {
+	hw, hh := float32(pix.Width)/2, float32(pix.Height)/2
+	corner := math32.Hypot(hw, hh)
+	DefaultScheduler().RenderTiles(pix.Width, pix.Height, func(x0, y0, x1, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				dist := math32.Hypot(float32(x)+0.5-hw, float32(y)+0.5-hh) / corner
+				dark := 1 - vg.Strength*te.Smoothstep(vg.Radius-vg.Softness, vg.Radius, dist)
+				c := pix.GetPixel(x, y)
+				pix.SetPixel(x, y, byte(float32(c[0])*dark), byte(float32(c[1])*dark), byte(float32(c[2])*dark))
+			}
+		}
+	})
+	return nil
+}
+
+// Blocky pixels, each block the average of the pixels it covers
+type Pixelate struct {
+	Size int // Block size in pixels
+}
+
+func PixelateInit() *Pixelate {
+	return &Pixelate{Size: 4}
+}
+
+func (px *Pixelate) Name() string {
+	return "pixelate"
+}
+
+func (px *Pixelate) Apply(pix *Pixels, _ *AOVs) error {
+	if px.Size <= 1 {
+		return nil
+	}
+	// Tiles of blocks rather than pixels, so no block is split between two tiles
+	size := px.Size
+	blocksX, blocksY := (pix.Width+size-1)/size, (pix.Height+size-1)/size
+	DefaultScheduler().RenderTiles(blocksX, blocksY, func(bx0, by0, bx1, by1 int) {
+		for by := by0 * size; by < by1*size; by += size {
+			for bx := bx0 * size; bx < bx1*size; bx += size {
+				px.block(pix, bx, by, min(bx+size, pix.Width), min(by+size, pix.Height))
+			}
+		}
+	})
+	return nil
+}
+
+// Fills the block from x0, y0 to x1, y1 with its average
+func (px *Pixelate) block(pix *Pixels, x0, y0, x1, y1 int) {
+	sum, count := [3]int{}, 0
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			c := pix.GetPixel(x, y)
+			sum[0], sum[1], sum[2] = sum[0]+int(c[0]), sum[1]+int(c[1]), sum[2]+int(c[2])
+			count++
+		}
+	}
+	r, g, b := byte(sum[0]/count), byte(sum[1]/count), byte(sum[2]/count)
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			pix.SetPixel(x, y, r, g, b)
+		}
+	}
+}
+
+// 4x4 Bayer matrix, thresholds for ordered dithering
+var bayer4 = [4][4]float32{
+	{0, 8, 2, 10},
+	{12, 4, 14, 6},
+	{3, 11, 1, 9},
+	{15, 7, 13, 5},
+}
+
+// Cuts every channel down to a few levels, with an ordered dither pattern standing in
+// for the levels in between
+type Dither struct {
+	Levels int // Shades per channel, at least 2
+	Scale  int // Pixels per cell of the pattern
+}
+
+func DitherInit() *Dither {
+	return &Dither{Levels: 4, Scale: 1}
+}
+
+func (dt *Dither) Name() string {
+	return "dither"
+}
+
+func (dt *Dither) Apply(pix *Pixels, _ *AOVs) error {
+	levels, scale := float32(max(dt.Levels, 2)-1), max(dt.Scale, 1)
+	quantize := func(c byte, threshold float32) byte {
+		v := math32.Floor(float32(c)/255*levels + threshold)
+		return byte(min(v, levels) / levels * 255)
+	}
+	DefaultScheduler().RenderTiles(pix.Width, pix.Height, func(x0, y0, x1, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				threshold := (bayer4[(y/scale)%4][(x/scale)%4] + 0.5) / 16
+				c := pix.GetPixel(x, y)
+				pix.SetPixel(x, y, quantize(c[0], threshold), quantize(c[1], threshold), quantize(c[2], threshold))
+			}
+		}
+	})
+	return nil
+}
+
+// Draws lines along silhouettes and creases, found from the AOVs' depth and normals.
+// Without AOVs it falls back to edges in the colors
+type Outline struct {
+	Color   [3]byte
+	Opacity float32
+	// Depth jump, as a fraction of the depth, that counts as a silhouette
+	DepthThreshold float32
+	// Normals facing apart further than this, as the cosine between them, count as a crease
+	NormalThreshold float32
+	// Also outlines every voxel, not just the edges of shapes
+	VoxelBorders bool
+	// Luma difference that counts as an edge without AOVs
+	LumaThreshold float32
+
+	src []float32
+}
+
+func OutlineInit() *Outline {
+	return &Outline{Opacity: 0.8, DepthThreshold: 0.1, NormalThreshold: 0.5, LumaThreshold: 0.2}
+}
+
+func (ol *Outline) Name() string {
+	return "outline"
+}
+
+func (ol *Outline) Apply(pix *Pixels, aov *AOVs) error {
+	if aov != nil && (aov.Width != pix.Width || aov.Height != pix.Height) {
+		return fmt.Errorf("AOVs are %v x %v but the frame is %v x %v", aov.Width, aov.Height, pix.Width, pix.Height)
+	}
+	ol.src = pixelFloats(pix, ol.src)
+	line := te.Vec3(float32(ol.Color[0]), float32(ol.Color[1]), float32(ol.Color[2])).Div(255)
+	neighbors := [4][2]int{{1, 0}, {-1, 0}, {0, 1}, {0, -1}}
+
+	DefaultScheduler().RenderTiles(pix.Width, pix.Height, func(x0, y0, x1, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				edge := false
+				for _, n := range neighbors {
+					qx, qy := x+n[0], y+n[1]
+					if !pix.Surrounds(qx, qy) {
+						continue
+					}
+					if aov != nil {
+						edge = ol.geometryEdge(aov, pix.Width*y+x, pix.Width*qy+qx)
+					} else {
+						edge = ol.colorEdge(pix.Width*y+x, pix.Width*qy+qx)
+					}
+					if edge {
+						break
+					}
+				}
+				if edge {
+					i := 3 * (pix.Width*y + x)
+					c := te.Vec3(ol.src[i], ol.src[i+1], ol.src[i+2])
+					setPixelFloat(pix, x, y, mix(c, line, ol.Opacity))
+				}
+			}
+		}
+	})
+	return nil
+}
+
+// Whether there's an edge between pixels i and q that belongs to i. Lines go on the
+// nearer side, so they hug the shape in front
+func (ol *Outline) geometryEdge(aov *AOVs, i, q int) bool {
+	if aov.voxel[3*i] < 0 {
+		return false
+	}
+	if aov.voxel[3*q] < 0 {
+		return true
+	}
+	di, dq := aov.depth[i], aov.depth[q]
+	if dq-di > ol.DepthThreshold*di {
+		return true
+	}
+	if dq < di {
+		return false
+	}
+	if ol.VoxelBorders && (aov.voxel[3*i] != aov.voxel[3*q] || aov.voxel[3*i+1] != aov.voxel[3*q+1] ||
+		aov.voxel[3*i+2] != aov.voxel[3*q+2]) {
+		return true
+	}
+	cosine := aov.normal[3*i]*aov.normal[3*q] + aov.normal[3*i+1]*aov.normal[3*q+1] + aov.normal[3*i+2]*aov.normal[3*q+2]
+	return cosine < ol.NormalThreshold
+}
+
+// Same as geometryEdge from the colors alone, the line goes on the brighter side
+func (ol *Outline) colorEdge(i, q int) bool {
+	li := luma(te.Vec3(ol.src[3*i], ol.src[3*i+1], ol.src[3*i+2]))
+	lq := luma(te.Vec3(ol.src[3*q], ol.src[3*q+1], ol.src[3*q+2]))
+	return li-lq > ol.LumaThreshold
+}
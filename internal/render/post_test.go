@@ -0,0 +1,173 @@
+package render
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/chewxy/math32"
+	te "github.com/zheskett/go-voxel/internal/tensor"
+)
+
+// A frame that's gray on the left and white on the right, with the edge on a slant
+func slantedEdge(width, height int) Pixels {
+	pix := PixelsInit(width, height)
+	for y := range height {
+		for x := range width {
+			if 2*x > width+y/3 {
+				pix.SetPixel(x, y, 255, 255, 255)
+			} else {
+				pix.SetPixel(x, y, 64, 64, 64)
+			}
+		}
+	}
+	return pix
+}
+
+// TestPostEffects expects each effect to do its one job.
+func TestPostEffects(t *testing.T) {
+	pix := slantedEdge(32, 32)
+	if err := FXAAInit().Apply(&pix, nil); err != nil {
+		t.Fatal(err)
+	}
+	blended := 0
+	for y := range pix.Height {
+		for x := range pix.Width {
+			if c := pix.GetPixel(x, y)[0]; c != 64 && c != 255 {
+				blended++
+			}
+		}
+	}
+	if blended < pix.Height {
+		t.Errorf("Expected FXAA to blend the edge, only %v pixels changed", blended)
+	}
+	if pix.GetPixel(0, 0)[0] != 64 || pix.GetPixel(31, 0)[0] != 255 {
+		t.Errorf("Expected FXAA to leave flat areas alone")
+	}
+
+	// Big enough for several tiles, with blocks that don't line up with them
+	pix = slantedEdge(150, 100)
+	(&Pixelate{Size: 7}).Apply(&pix, nil)
+	for y := range pix.Height {
+		for x := range pix.Width {
+			if pix.GetPixel(x, y) != pix.GetPixel(x/7*7, y/7*7) {
+				t.Fatalf("Expected 7 pixel blocks, %v, %v differs from its block", x, y)
+			}
+		}
+	}
+
+	pix = PixelsInit(32, 32)
+	pix.FillPixels(200, 200, 200)
+	VignetteInit().Apply(&pix, nil)
+	if middle, corner := pix.GetPixel(16, 16), pix.GetPixel(0, 0); middle[0] < 195 || corner[0] > 150 {
+		t.Errorf("Expected the corners darkened and not the middle, got %v and %v", corner, middle)
+	}
+
+	pix.FillPixels(128, 128, 128)
+	(&Dither{Levels: 2, Scale: 1}).Apply(&pix, nil)
+	white := 0
+	for y := range 4 {
+		for x := range 4 {
+			switch pix.GetPixel(x, y)[0] {
+			case 255:
+				white++
+			case 0:
+			default:
+				t.Fatalf("Expected only black and white, got %v", pix.GetPixel(x, y))
+			}
+		}
+	}
+	if white != 8 {
+		t.Errorf("Expected half the pattern white for half gray, got %v of 16", white)
+	}
+}
+
+// TestOutline expects lines around the silhouette of a voxel, on the voxel's side,
+// and an error for AOVs that don't match the frame.
+func TestOutline(t *testing.T) {
+	vox := testWorld()
+	vox.SetVoxel(16, 1, 16, 255, 255, 255)
+	pix := PixelsInitHDR(32, 32)
+	cam := testCamera(&pix)
+	cam.Pos = te.Vec3(16.5, 6, 16.5)
+	cam.SetRotationFPS(math32.Pi/2, 0)
+	cam.Fov = 60
+	aov := AOVsInit(pix.Width, pix.Height)
+	cam.RenderAOVs(&vox, &aov)
+	pix.FillPixels(255, 255, 255)
+
+	ol := OutlineInit()
+	ol.Opacity = 1
+	small := AOVsInit(pix.Width/2, pix.Height/2)
+	if err := ol.Apply(&pix, &small); err == nil {
+		t.Errorf("Expected an error for AOVs smaller than the frame")
+	}
+	if err := ol.Apply(&pix, &aov); err != nil {
+		t.Fatal(err)
+	}
+	lines := 0
+	for y := range pix.Height {
+		for x := range pix.Width {
+			if pix.GetPixel(x, y) != [3]byte{} {
+				continue
+			}
+			lines++
+			if aov.At(x, y).Voxel[1] != 1 {
+				t.Errorf("Expected lines only on the voxel, got one at %v, %v on %+v", x, y, aov.At(x, y))
+			}
+		}
+	}
+	if lines == 0 {
+		t.Errorf("Expected a line around the voxel")
+	}
+}
+
+// TestLUT expects graded colors to follow the LUT between its entries, and .cube files
+// to round trip.
+func TestLUT(t *testing.T) {
+	c := te.Vec3(0.3, 0.55, 0.9)
+	if got := IdentityLUT(5).Lookup(c); !closeToVec(got, c, 1e-5) {
+		t.Errorf("Expected the identity LUT to keep %v, got %v", c, got)
+	}
+	invert := LUTFromFunc(3, func(c te.Vector3) te.Vector3 { return te.Vec3Splat(1).Sub(c) })
+	if got := invert.Lookup(c); !closeToVec(got, te.Vec3(0.7, 0.45, 0.1), 1e-5) {
+		t.Errorf("Expected an inverted %v, got %v", c, got)
+	}
+
+	lut := GradeLUT(4, 1.2, 1.1, 0.05)
+	var buf bytes.Buffer
+	if err := lut.WriteCube(&buf); err != nil {
+		t.Fatal(err)
+	}
+	read, err := ReadCubeLUT(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if read.Size != lut.Size || !closeToVec(read.Lookup(c), lut.Lookup(c), 1e-5) {
+		t.Errorf("Expected the LUT to round trip, got %v from %v", read.Lookup(c), lut.Lookup(c))
+	}
+
+	for _, bad := range []string{"0 0 0\n", "LUT_3D_SIZE 2\n0 0 0\n", "LUT_3D_SIZE 2\nDOMAIN_MAX 2 2 2\n"} {
+		if _, err := ReadCubeLUT(bytes.NewBufferString(bad)); err == nil {
+			t.Errorf("Expected an error reading %q", bad)
+		}
+	}
+}
+
+// TestPostStack expects only enabled effects to run.
+func TestPostStack(t *testing.T) {
+	stack := PostStackInit()
+	pix := PixelsInit(8, 8)
+	pix.FillPixels(100, 100, 100)
+	stack.Apply(&pix, nil)
+	if pix.GetPixel(0, 0) != [3]byte{100, 100, 100} {
+		t.Errorf("Expected no effects to run by default, got %v", pix.GetPixel(0, 0))
+	}
+	if stack.Enable("nope", true) {
+		t.Errorf("Expected no effect called nope")
+	}
+	stack.Enable("vignette", true)
+	stack.Apply(&pix, nil)
+	if pix.GetPixel(0, 0)[0] >= 100 {
+		t.Errorf("Expected the vignette to darken the corner, got %v", pix.GetPixel(0, 0))
+	}
+}
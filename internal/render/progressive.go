@@ -0,0 +1,143 @@
+package render
+
+import (
+	"math/rand/v2"
+
+	te "github.com/zheskett/go-voxel/internal/tensor"
+	vxl "github.com/zheskett/go-voxel/internal/voxel"
+)
+
+// What the camera sees with, anything else (like move speed) can change without resetting
+type cameraView struct {
+	pos, fvec, rvec, uvec       te.Vector3
+	fov, aspect, renderDistance float32
+	projection                  Projection
+	orthoHeight                 float32
+	aperture, focusDistance     float32
+	ambient                     Ambient
+	env                         Environment
+	maxReflections              int
+}
+
+func viewOf(cam *Camera) cameraView {
+	return cameraView{cam.Pos, cam.Fvec, cam.Rvec, cam.Uvec, cam.Fov, cam.Aspect, cam.RenderDistance,
+		cam.Projection, cam.OrthoHeight, cam.Aperture, cam.FocusDistance, cam.Ambient, cam.Environment, cam.MaxReflections}
+}
+
+// Progressive renders a still view over many frames. Every frame adds one jittered
+// sample per pixel, with shadow rays aimed at random points on the lights, to a float
+// accumulation buffer of linear colors, so the image converges to an anti-aliased, soft-shadowed one.
+// The buffer starts over whenever the camera, the world, the lights, the path tracer or the size changes
+type Progressive struct {
+	// Radius of the sphere each light is treated as, 0 gives hard shadows
+	LightRadius float32
+	// Stops adding samples after this many, 0 never stops
+	MaxSamples int
+	// Path traces the samples instead of using the fast shading when set
+	PathTrace *PathTracer
+
+	accum       []float32 // Sum of every sample, linear RGB with 1.0 as full brightness
+	samples     int
+	width       int
+	height      int
+	view        cameraView
+	edits       uint64
+	lights      []vxl.LightSource
+	propagation *vxl.LightPropagation
+	tracing     bool
+	tracer      PathTracer // Copy of *PathTrace the samples were traced with
+}
+
+func ProgressiveInit() Progressive {
+	return Progressive{LightRadius: 1.5, MaxSamples: 1024}
+}
+
+// Number of samples in the current image
+func (pg *Progressive) Samples() int {
+	return pg.samples
+}
+
+// Throws away the accumulated samples, the next Render starts over
+func (pg *Progressive) Reset() {
+	pg.samples = 0
+	clear(pg.accum)
+}
+
+// Adds a sample per pixel and writes the average so far to pix
+func (pg *Progressive) Render(cam *Camera, vox *vxl.Voxels, pix *Pixels) {
+	if pg.width != pix.Width || pg.height != pix.Height {
+		pg.width, pg.height = pix.Width, pix.Height
+		pg.accum = make([]float32, pix.Width*pix.Height*3)
+		pg.samples = 0
+	}
+	vox.UpdatePropagation()
+	if view := viewOf(cam); view != pg.view || vox.Edits() != pg.edits || !vxl.SameLights(vox.Lights, pg.lights) ||
+		vox.Propagation() != pg.propagation || !pg.sameTracer() {
+		pg.view, pg.edits, pg.propagation = view, vox.Edits(), vox.Propagation()
+		pg.tracing = pg.PathTrace != nil
+		if pg.tracing {
+			pg.tracer = *pg.PathTrace
+		}
+		pg.lights = append(pg.lights[:0], vox.Lights...)
+		pg.Reset()
+	}
+
+	sample := pg.samples
+	adding := pg.MaxSamples <= 0 || sample < pg.MaxSamples
+	if adding {
+		pg.samples++
+	}
+	basis := CameraRayBasisInit(cam, pix)
+	scale := 1.0 / float32(pg.samples)
+
+	DefaultScheduler().RenderTiles(pix.Width, pix.Height, func(x0, y0, x1, y1 int) {
+		// Seeded by sample and tile so each sample gets fresh noise, but the same run is repeatable
+		rng := rand.New(rand.NewPCG(uint64(sample), uint64(y0*pix.Width+x0)))
+		for row := y0; row < y1; row++ {
+			for column := x0; column < x1; column++ {
+				i := 3 * (pix.Width*row + column)
+				if sample == 0 && adding && pix.aov != nil {
+					// From the first sample, through the middle of the pixel
+					ray := cam.getPixelRay(column, row, basis)
+					pix.aov.set(cam, vox, column, row, vox.MarchRay(ray))
+				}
+				if adding {
+					color := pg.sample(cam, vox, basis, column, row, sample, rng)
+					pg.accum[i+0] += color.X
+					pg.accum[i+1] += color.Y
+					pg.accum[i+2] += color.Z
+				}
+
+				average := te.Vec3(pg.accum[i+0], pg.accum[i+1], pg.accum[i+2]).Mul(scale)
+				pix.SetPixelLinear(column, row, average)
+			}
+		}
+	})
+}
+
+func (pg *Progressive) sameTracer() bool {
+	if pg.PathTrace == nil {
+		return !pg.tracing
+	}
+	return pg.tracing && *pg.PathTrace == pg.tracer
+}
+
+// Traces one sample through the pixel. The first sample goes through the center of the pixel
+// and the lens, the rest are jittered
+func (pg *Progressive) sample(cam *Camera, vox *vxl.Voxels, basis CameraRayBasis, column, row, sample int,
+	rng *rand.Rand) te.Vector3 {
+	jx, jy := float32(0.5), float32(0.5)
+	lu, lv := float32(0), float32(0)
+	if sample > 0 {
+		jx, jy = rng.Float32(), rng.Float32()
+		lu, lv = rng.Float32(), rng.Float32()
+	}
+	ray := cam.projectRay(float32(column)+jx, float32(row)+jy, basis, lu, lv)
+	if pg.PathTrace != nil {
+		return pg.PathTrace.Trace(vox, &cam.Environment, ray, rng)
+	}
+
+	sh := cam.shader(vox)
+	sh.rng, sh.lightRadius = rng, pg.LightRadius
+	return sh.trace(ray, 0)
+}
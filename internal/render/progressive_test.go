@@ -0,0 +1,78 @@
+package render
+
+import (
+	"testing"
+
+	te "github.com/zheskett/go-voxel/internal/tensor"
+	vxl "github.com/zheskett/go-voxel/internal/voxel"
+)
+
+// TestProgressiveResets expects samples to build up while nothing changes, and
+// to start over when the camera moves, the world is edited or the size changes.
+func TestProgressiveResets(t *testing.T) {
+	vox := testWorld()
+	pix := PixelsInit(32, 24)
+	cam := testCamera(&pix)
+	pg := ProgressiveInit()
+
+	for range 3 {
+		pg.Render(&cam, &vox, &pix)
+	}
+	if pg.Samples() != 3 {
+		t.Fatalf("Expected 3 samples, got %v", pg.Samples())
+	}
+
+	cam.Movespeed += 5
+	pg.Render(&cam, &vox, &pix)
+	if pg.Samples() != 4 {
+		t.Errorf("Expected move speed to keep samples, got %v", pg.Samples())
+	}
+
+	cam.Pos.X += 1
+	pg.Render(&cam, &vox, &pix)
+	if pg.Samples() != 1 {
+		t.Errorf("Expected camera move to reset, got %v samples", pg.Samples())
+	}
+
+	pg.Render(&cam, &vox, &pix)
+	vox.SetVoxel(3, 1, 3, 255, 0, 0)
+	pg.Render(&cam, &vox, &pix)
+	if pg.Samples() != 1 {
+		t.Errorf("Expected edit to reset, got %v samples", pg.Samples())
+	}
+
+	pg.Render(&cam, &vox, &pix)
+	vox.Lights[0] = vxl.Light{Position: te.Vec3(16, 20, 16), Color: te.Vec3Splat(1000)}
+	pg.Render(&cam, &vox, &pix)
+	if pg.Samples() != 1 {
+		t.Errorf("Expected light change to reset, got %v samples", pg.Samples())
+	}
+
+	pg.Render(&cam, &vox, &pix)
+	small := PixelsInit(16, 12)
+	pg.Render(&cam, &vox, &small)
+	if pg.Samples() != 1 {
+		t.Errorf("Expected resize to reset, got %v samples", pg.Samples())
+	}
+}
+
+// TestProgressiveMaxSamples expects the image to stop changing once MaxSamples is reached.
+func TestProgressiveMaxSamples(t *testing.T) {
+	vox := testWorld()
+	pix := PixelsInitHDR(32, 24)
+	cam := testCamera(&pix)
+	pg := ProgressiveInit()
+	pg.MaxSamples = 4
+
+	for range 4 {
+		pg.Render(&cam, &vox, &pix)
+	}
+	before := pix.GetPixelHDR(16, 20)
+	pg.Render(&cam, &vox, &pix)
+	if pg.Samples() != 4 {
+		t.Errorf("Expected samples to stop at 4, got %v", pg.Samples())
+	}
+	if after := pix.GetPixelHDR(16, 20); after != before {
+		t.Errorf("Expected pixel to stay %v, got %v", before, after)
+	}
+}
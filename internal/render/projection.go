@@ -0,0 +1,159 @@
+package render
+
+import (
+	"github.com/chewxy/math32"
+	te "github.com/zheskett/go-voxel/internal/tensor"
+	vxl "github.com/zheskett/go-voxel/internal/voxel"
+)
+
+type Projection int
+
+const (
+	// Pinhole camera with the Fov, the default
+	ProjectPerspective Projection = iota
+	// Parallel rays, OrthoHeight voxels tall. Things don't get smaller with distance
+	ProjectOrthographic
+	// Everything around the camera, 360 degrees across and 180 up and down.
+	// Level with the horizon no matter the pitch. Looks best at 2:1
+	ProjectPanorama
+	// The six 90 degree faces of a world aligned cube map, +X -X +Y in the top row
+	// and -Y +Z -Z in the bottom one. Looks best at 3:2
+	ProjectCubeMap
+	projectionCount
+)
+
+func (proj Projection) String() string {
+	switch proj {
+	case ProjectPerspective:
+		return "perspective"
+	case ProjectOrthographic:
+		return "orthographic"
+	case ProjectPanorama:
+		return "panorama"
+	case ProjectCubeMap:
+		return "cubemap"
+	}
+	return "unknown"
+}
+
+// Projection F12 switches to, through to the cube map and back to perspective
+func (proj Projection) Next() Projection {
+	return (proj + 1) % projectionCount
+}
+
+// Projection for a -projection value. Isometric and dimetric are orthographic
+// cameras set up by Camera.SetIsometric and SetDimetric, so they aren't parsed here
+func ParseProjection(name string) (Projection, bool) {
+	for proj := range projectionCount {
+		if proj.String() == name {
+			return proj, true
+		}
+	}
+	return ProjectPerspective, false
+}
+
+// Pitch of a true isometric view, where the three axes are equally foreshortened
+var isometricPitch = math32.Atan(1 / math32.Sqrt2)
+
+// Switches to an orthographic view looking down at 45 degrees between the axes,
+// so each axis is equally foreshortened
+func (cam *Camera) SetIsometric() {
+	cam.Projection = ProjectOrthographic
+	cam.SetRotationFPS(isometricPitch, math32.Pi/4)
+}
+
+// Same as SetIsometric, but looking down at 30 degrees, for the 2:1 lines of pixel art
+func (cam *Camera) SetDimetric() {
+	cam.Projection = ProjectOrthographic
+	cam.SetRotationFPS(math32.Pi/6, math32.Pi/4)
+}
+
+// Cube map faces, in the order they're laid out, as forward, right and up
+var cubeFaces = [6][3]te.Vector3{
+	{te.Vec3(1, 0, 0), te.Vec3(0, 0, -1), te.Vec3(0, 1, 0)},
+	{te.Vec3(-1, 0, 0), te.Vec3(0, 0, 1), te.Vec3(0, 1, 0)},
+	{te.Vec3(0, 1, 0), te.Vec3(1, 0, 0), te.Vec3(0, 0, -1)},
+	{te.Vec3(0, -1, 0), te.Vec3(1, 0, 0), te.Vec3(0, 0, 1)},
+	{te.Vec3(0, 0, 1), te.Vec3(1, 0, 0), te.Vec3(0, 1, 0)},
+	{te.Vec3(0, 0, -1), te.Vec3(-1, 0, 0), te.Vec3(0, 1, 0)},
+}
+
+// Ray through a point of the screen, in pixels from the top left. lensU and lensV in
+// [0, 1) pick the point on the lens the ray goes through when Aperture is set
+func (cam *Camera) projectRay(dx, dy float32, basis CameraRayBasis, lensU, lensV float32) vxl.Ray {
+	ray := vxl.Ray{Origin: cam.Pos, Tmax: cam.RenderDistance}
+	ndcx := (dx - basis.halfwidth) / basis.halfwidth
+	ndcy := -(dy - basis.halfheight) / basis.halfheight
+
+	switch cam.Projection {
+	case ProjectOrthographic:
+		ray.Origin = cam.Pos.Add(basis.drdx.Mul(ndcx)).Add(basis.dudy.Mul(ndcy))
+		ray.Dir = cam.Fvec
+	case ProjectPanorama:
+		front := te.Vec3(cam.Fvec.X, 0, cam.Fvec.Z).Normalized()
+		right := front.Cross(cam.Wupvec)
+		yaw, pitch := ndcx*math32.Pi, ndcy*math32.Pi/2
+		across := front.Mul(math32.Cos(yaw)).Add(right.Mul(math32.Sin(yaw)))
+		ray.Dir = across.Mul(math32.Cos(pitch)).Add(cam.Wupvec.Mul(math32.Sin(pitch))).Normalized()
+		return ray
+	case ProjectCubeMap:
+		// Every face is a 90 degree perspective view in one of the 3 x 2 cells
+		cellw, cellh := 2*basis.halfwidth/3, basis.halfheight
+		column, row := min(int(dx/cellw), 2), min(int(dy/cellh), 1)
+		face := cubeFaces[3*row+column]
+		fx := 2*(dx-float32(column)*cellw)/cellw - 1
+		fy := 1 - 2*(dy-float32(row)*cellh)/cellh
+		ray.Dir = face[0].Add(face[1].Mul(fx)).Add(face[2].Mul(fy)).Normalized()
+		return ray
+	default:
+		// This is effectively finding the ray that points to that specific pixel
+		dcamr := basis.drdx.Mul(ndcx)
+		dcamu := basis.dudy.Mul(ndcy)
+		ray.Dir = cam.Fvec.Add(dcamr).Add(dcamu).Normalized()
+	}
+
+	if cam.Aperture > 0 {
+		// Thin lens: every ray through the pixel meets on the focus plane, wherever on the lens it starts
+		focus := ray.Origin.Add(ray.Dir.Mul(cam.FocusDistance / ray.Dir.Dot(cam.Fvec)))
+		r, theta := cam.Aperture*math32.Sqrt(lensU), 2*math32.Pi*lensV
+		ray.Origin = ray.Origin.Add(cam.Rvec.Mul(r * math32.Cos(theta))).Add(cam.Uvec.Mul(r * math32.Sin(theta)))
+		ray.Dir = focus.Sub(ray.Origin).Normalized()
+	}
+	return ray
+}
+
+// How far in front of the camera p is, along Fvec. Panoramas and cube maps look every
+// way at once, so for them it's the straight line distance
+func (cam *Camera) viewDepth(p te.Vector3) float32 {
+	d := p.Sub(cam.Pos)
+	if cam.Projection == ProjectPanorama || cam.Projection == ProjectCubeMap {
+		return d.Len()
+	}
+	return d.Dot(cam.Fvec)
+}
+
+// Where the world point p shows up on screen, in pixels from the top left, and how far
+// away it is as AOVs measure depth. false if it's behind the camera, or for panoramas
+// and cube maps, which don't reproject
+func (cam *Camera) project(p te.Vector3, basis CameraRayBasis) (float32, float32, float32, bool) {
+	d := p.Sub(cam.Pos)
+	z := d.Dot(cam.Fvec)
+	var ndcx, ndcy, depth float32
+	switch cam.Projection {
+	case ProjectPerspective:
+		if z <= 1e-4 {
+			return 0, 0, 0, false
+		}
+		// The ray through the point is Fvec plus the basis scaled by the ndc, so dividing by z finds them
+		ndcx = d.Dot(basis.drdx) / (basis.drdx.LenSqr() * z)
+		ndcy = d.Dot(basis.dudy) / (basis.dudy.LenSqr() * z)
+		depth = z
+	case ProjectOrthographic:
+		ndcx = d.Dot(basis.drdx) / basis.drdx.LenSqr()
+		ndcy = d.Dot(basis.dudy) / basis.dudy.LenSqr()
+		depth = z
+	default:
+		return 0, 0, 0, false
+	}
+	return ndcx*basis.halfwidth + basis.halfwidth, basis.halfheight - ndcy*basis.halfheight, depth, true
+}
@@ -0,0 +1,97 @@
+package render
+
+import (
+	"math/rand/v2"
+	"testing"
+
+	"github.com/chewxy/math32"
+	te "github.com/zheskett/go-voxel/internal/tensor"
+	vxl "github.com/zheskett/go-voxel/internal/voxel"
+)
+
+// TestOrthographicRaysAreParallel expects every ray to point the same way, from
+// origins spread over OrthoHeight.
+func TestOrthographicRaysAreParallel(t *testing.T) {
+	pix := PixelsInit(40, 20)
+	cam := testCamera(&pix)
+	cam.Aspect = 2
+	cam.Projection = ProjectOrthographic
+	cam.OrthoHeight = 10
+	basis := CameraRayBasisInit(&cam, &pix)
+
+	top, bottom := cam.getSubpixelRay(20, 0, basis), cam.getSubpixelRay(20, 20, basis)
+	left, right := cam.getSubpixelRay(0, 10, basis), cam.getSubpixelRay(40, 10, basis)
+	for _, ray := range []vxl.Ray{top, bottom, left, right} {
+		if !closeToVec(ray.Dir, cam.Fvec, 1e-6) {
+			t.Errorf("Expected every ray along %v, got %v", cam.Fvec, ray.Dir)
+		}
+	}
+	if got := top.Origin.Sub(bottom.Origin).Len(); math32.Abs(got-10) > 1e-4 {
+		t.Errorf("Expected the view 10 tall, got %v", got)
+	}
+	if got := right.Origin.Sub(left.Origin).Len(); math32.Abs(got-20) > 1e-4 {
+		t.Errorf("Expected the view 20 wide, got %v", got)
+	}
+
+	cam.SetIsometric()
+	// Each axis should be foreshortened by the same amount
+	x, y, z := cam.Fvec.Abs().Elms()
+	if math32.Abs(x-y) > 1e-5 || math32.Abs(y-z) > 1e-5 {
+		t.Errorf("Expected an isometric view to look down the diagonal, got %v", cam.Fvec)
+	}
+}
+
+// TestPanoramaAndCubeMapDirections checks rays from known points of the image.
+func TestPanoramaAndCubeMapDirections(t *testing.T) {
+	pix := PixelsInit(60, 40)
+	cam := testCamera(&pix)
+	cam.SetRotationFPS(0.5, 0)
+	cam.Projection = ProjectPanorama
+	basis := CameraRayBasisInit(&cam, &pix)
+
+	front := te.Vec3(cam.Fvec.X, 0, cam.Fvec.Z).Normalized()
+	cases := map[[2]float32]te.Vector3{
+		{30, 20}: front,
+		{0, 20}:  front.Neg(),
+		{45, 20}: cam.Rvec,
+		{30, 0}:  te.Vec3Y(),
+	}
+	for at, want := range cases {
+		if got := cam.getSubpixelRay(at[0], at[1], basis).Dir; !closeToVec(got, want, 1e-5) {
+			t.Errorf("Expected panorama ray at %v to point %v, got %v", at, want, got)
+		}
+	}
+
+	cam.Projection = ProjectCubeMap
+	basis = CameraRayBasisInit(&cam, &pix)
+	for face, axes := range cubeFaces {
+		center := [2]float32{float32(face%3)*20 + 10, float32(face/3)*20 + 10}
+		if got := cam.getSubpixelRay(center[0], center[1], basis).Dir; !closeToVec(got, axes[0], 1e-5) {
+			t.Errorf("Expected face %v to look along %v, got %v", face, axes[0], got)
+		}
+	}
+}
+
+// TestDepthOfField expects rays through one pixel to start all over the lens but
+// meet on the focus plane.
+func TestDepthOfField(t *testing.T) {
+	pix := PixelsInit(40, 30)
+	cam := testCamera(&pix)
+	cam.Aperture = 0.5
+	cam.FocusDistance = 8
+	basis := CameraRayBasisInit(&cam, &pix)
+	pinhole := cam.getSubpixelRay(13, 7, basis)
+	focus := pinhole.Origin.Add(pinhole.Dir.Mul(8 / pinhole.Dir.Dot(cam.Fvec)))
+
+	rng := rand.New(rand.NewPCG(1, 2))
+	for range 16 {
+		ray := cam.projectRay(13, 7, basis, rng.Float32(), rng.Float32())
+		if offset := ray.Origin.Sub(cam.Pos).Len(); offset > cam.Aperture+1e-5 {
+			t.Errorf("Expected the ray to start on the lens, got %v away", offset)
+		}
+		at := ray.Origin.Add(ray.Dir.Mul(focus.Sub(ray.Origin).Dot(cam.Fvec) / ray.Dir.Dot(cam.Fvec)))
+		if !closeToVec(at, focus, 1e-3) {
+			t.Errorf("Expected rays to meet at %v, got %v", focus, at)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package render
+
+import (
+	"github.com/chewxy/math32"
+)
+
+const (
+	// Frames to wait after a change before judging the new resolution
+	scalerSettleFrames = 10
+	// How far off the target the average frame time has to be before the scale changes
+	scalerDeadband = 0.1
+	// Scales are rounded to this step so small jitters don't reallocate the frame
+	scalerStep = 1.0 / 32.0
+)
+
+// ResolutionScaler picks a render scale that keeps frames near a target time.
+// Render cost is roughly proportional to pixel count, so the scale moves by the
+// square root of how far off the frame time is
+type ResolutionScaler struct {
+	Scale    float32
+	MinScale float32
+	MaxScale float32
+	// Seconds per frame to aim for, 0 turns scaling off and leaves Scale alone
+	Target float32
+
+	average float32
+	settle  int
+}
+
+func ResolutionScalerInit(target float32) ResolutionScaler {
+	return ResolutionScaler{
+		Scale:    1.0,
+		MinScale: 0.25,
+		MaxScale: 2.0,
+		Target:   target,
+	}
+}
+
+// Feeds the last frame's time in seconds, returns whether Scale changed
+func (rs *ResolutionScaler) Update(frametime float32) bool {
+	if rs.Target <= 0 || frametime <= 0 {
+		return false
+	}
+	if rs.settle > 0 {
+		rs.settle--
+		return false
+	}
+	if rs.average == 0 {
+		rs.average = frametime
+	} else {
+		rs.average += (frametime - rs.average) * 0.1
+	}
+
+	ratio := rs.Target / rs.average
+	if math32.Abs(ratio-1) < scalerDeadband {
+		return false
+	}
+	scale := rs.Scale * math32.Sqrt(ratio)
+	scale = math32.Round(scale/scalerStep) * scalerStep
+	if scale == rs.Scale {
+		// Always move at least a step, at small scales the rounding would swallow it
+		scale += math32.Copysign(scalerStep, ratio-1)
+	}
+	scale = math32.Min(math32.Max(scale, rs.MinScale), rs.MaxScale)
+	if scale == rs.Scale {
+		return false
+	}
+
+	rs.Scale = scale
+	rs.average = 0
+	rs.settle = scalerSettleFrames
+	return true
+}
+
+// Size of the render for a given output size, never smaller than a pixel
+func (rs *ResolutionScaler) Resolution(width, height int) (int, int) {
+	return max(int(math32.Round(float32(width)*rs.Scale)), 1), max(int(math32.Round(float32(height)*rs.Scale)), 1)
+}
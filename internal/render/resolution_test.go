@@ -0,0 +1,41 @@
+package render
+
+import "testing"
+
+// TestResolutionScaler feeds frame times that scale with pixel count and
+// expects the scaler to settle near the target without leaving its bounds.
+func TestResolutionScaler(t *testing.T) {
+	rs := ResolutionScalerInit(1.0 / 60.0)
+	// A frame at scale 1 takes twice the target
+	cost := float32(2.0 / 60.0)
+	for range 500 {
+		rs.Update(cost * rs.Scale * rs.Scale)
+	}
+	if rs.Scale < 0.6 || rs.Scale > 0.8 {
+		t.Errorf("Expected scale near 0.7, got %v", rs.Scale)
+	}
+
+	// Much too slow even at the smallest scale
+	for range 500 {
+		rs.Update(1.0)
+	}
+	if rs.Scale != rs.MinScale {
+		t.Errorf("Expected scale to stop at %v, got %v", rs.MinScale, rs.Scale)
+	}
+
+	// Much too fast
+	for range 500 {
+		rs.Update(0.0001)
+	}
+	if rs.Scale != rs.MaxScale {
+		t.Errorf("Expected scale to stop at %v, got %v", rs.MaxScale, rs.Scale)
+	}
+
+	rs.Target = 0
+	if rs.Update(1.0) {
+		t.Errorf("Expected no change with scaling turned off")
+	}
+	if w, h := rs.Resolution(400, 300); w != 800 || h != 600 {
+		t.Errorf("Expected 800x600, got %vx%v", w, h)
+	}
+}
@@ -0,0 +1,259 @@
+package render
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	vxl "github.com/zheskett/go-voxel/internal/voxel"
+)
+
+const (
+	// Side length of the square tiles a frame is split into
+	RenderTileSize = 16
+)
+
+// How long one tile of the last frame took, and who rendered it
+type TileStats struct {
+	X, Y          int // Top left pixel
+	Width, Height int
+	Duration      time.Duration
+	Worker        int
+	Stolen        bool // Rendered by a worker other than the one it was handed to
+}
+
+// Timing for a whole frame, Tiles is in row major tile order
+type FrameStats struct {
+	Tiles   []TileStats
+	Elapsed time.Duration
+	Steals  int
+}
+
+// Returns the tile that took the longest, or false if there were no tiles
+func (stats FrameStats) Slowest() (TileStats, bool) {
+	if len(stats.Tiles) == 0 {
+		return TileStats{}, false
+	}
+	slowest := stats.Tiles[0]
+	for _, tile := range stats.Tiles[1:] {
+		if tile.Duration > slowest.Duration {
+			slowest = tile
+		}
+	}
+	return slowest, true
+}
+
+// A worker's share of the tiles. The owner takes from the front and thieves
+// take from the back, so a stolen tile is far from what the owner is working on
+type tileQueue struct {
+	lock  sync.Mutex
+	tiles []int
+}
+
+func (queue *tileQueue) popFront() (int, bool) {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	if len(queue.tiles) == 0 {
+		return 0, false
+	}
+	tile := queue.tiles[0]
+	queue.tiles = queue.tiles[1:]
+	return tile, true
+}
+
+func (queue *tileQueue) popBack() (int, bool) {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+	if len(queue.tiles) == 0 {
+		return 0, false
+	}
+	tile := queue.tiles[len(queue.tiles)-1]
+	queue.tiles = queue.tiles[:len(queue.tiles)-1]
+	return tile, true
+}
+
+type tileFrame struct {
+	fn      func(x0, y0, x1, y1 int)
+	columns int
+	width   int
+	height  int
+	stats   []TileStats
+	steals  atomic.Int64
+	done    sync.WaitGroup
+}
+
+// TileScheduler renders frames as 16x16 tiles on a fixed set of worker goroutines.
+// Each worker starts with a contiguous block of tiles and steals from the others once it runs out
+type TileScheduler struct {
+	queues  []tileQueue
+	start   []chan *tileFrame
+	workers sync.WaitGroup
+	frame   sync.Mutex
+	last    FrameStats
+}
+
+// Starts a scheduler with the given number of workers, or one per CPU if threads < 1
+func TileSchedulerInit(threads int) *TileScheduler {
+	if threads < 1 {
+		threads = runtime.GOMAXPROCS(0)
+	}
+	ts := &TileScheduler{
+		queues: make([]tileQueue, threads),
+		start:  make([]chan *tileFrame, threads),
+	}
+	for id := range threads {
+		ts.start[id] = make(chan *tileFrame)
+		ts.workers.Go(func() {
+			for frame := range ts.start[id] {
+				ts.work(id, frame)
+				frame.done.Done()
+			}
+		})
+	}
+	return ts
+}
+
+// Number of worker goroutines
+func (ts *TileScheduler) Threads() int {
+	return len(ts.queues)
+}
+
+// Stops the workers, the scheduler can't be used afterwards
+func (ts *TileScheduler) Close() {
+	for _, start := range ts.start {
+		close(start)
+	}
+	ts.workers.Wait()
+}
+
+// Timing of the last frame, which is whatever last called RenderTiles, post passes included.
+// The copy returned keeps its values after the next frame
+func (ts *TileScheduler) Stats() FrameStats {
+	return ts.last
+}
+
+// Renders the voxels from the camera into pix, same as Camera.RenderVoxels
+func (ts *TileScheduler) Render(cam *Camera, vox *vxl.Voxels, pix *Pixels) {
+	basis := CameraRayBasisInit(cam, pix)
+	// Only drops the cached lighting that edits or light changes could have affected
+	vox.UpdateLightCache()
+	vox.UpdatePropagation()
+
+	ts.RenderTiles(pix.Width, pix.Height, func(x0, y0, x1, y1 int) {
+		// Row by row inside the tile so neighbouring rays walk the same voxels
+		for row := y0; row < y1; row++ {
+			for column := x0; column < x1; column++ {
+				cam.renderPixel(vox, pix, basis, column, row)
+			}
+		}
+	})
+}
+
+// Calls fn for every tile of a width by height frame, with the tile's pixel bounds
+// [x0, x1) by [y0, y1). Blocks until every tile is done
+func (ts *TileScheduler) RenderTiles(width, height int, fn func(x0, y0, x1, y1 int)) {
+	ts.frame.Lock()
+	defer ts.frame.Unlock()
+
+	columns := (width + RenderTileSize - 1) / RenderTileSize
+	rows := (height + RenderTileSize - 1) / RenderTileSize
+	count := columns * rows
+	frame := &tileFrame{
+		fn:      fn,
+		columns: columns,
+		width:   width,
+		height:  height,
+		stats:   make([]TileStats, count),
+	}
+
+	// Contiguous blocks keep each worker on one part of the screen until it has to steal
+	threads := len(ts.queues)
+	for id := range ts.queues {
+		lo, hi := count*id/threads, count*(id+1)/threads
+		tiles := ts.queues[id].tiles[:0]
+		for tile := lo; tile < hi; tile++ {
+			tiles = append(tiles, tile)
+		}
+		ts.queues[id].tiles = tiles
+	}
+
+	begin := time.Now()
+	frame.done.Add(threads)
+	for _, start := range ts.start {
+		start <- frame
+	}
+	frame.done.Wait()
+
+	ts.last = FrameStats{
+		Tiles:   frame.stats,
+		Elapsed: time.Since(begin),
+		Steals:  int(frame.steals.Load()),
+	}
+}
+
+func (ts *TileScheduler) work(id int, frame *tileFrame) {
+	for {
+		tile, ok := ts.queues[id].popFront()
+		stolen := false
+		if !ok {
+			tile, ok = ts.steal(id)
+			stolen = true
+		}
+		if !ok {
+			return
+		}
+		if stolen {
+			frame.steals.Add(1)
+		}
+
+		x0 := (tile % frame.columns) * RenderTileSize
+		y0 := (tile / frame.columns) * RenderTileSize
+		x1, y1 := min(x0+RenderTileSize, frame.width), min(y0+RenderTileSize, frame.height)
+
+		begin := time.Now()
+		frame.fn(x0, y0, x1, y1)
+		frame.stats[tile] = TileStats{
+			X: x0, Y: y0, Width: x1 - x0, Height: y1 - y0,
+			Duration: time.Since(begin),
+			Worker:   id,
+			Stolen:   stolen,
+		}
+	}
+}
+
+// Takes a tile from the back of another worker's queue, starting with the next worker over
+func (ts *TileScheduler) steal(id int) (int, bool) {
+	for i := 1; i < len(ts.queues); i++ {
+		if tile, ok := ts.queues[(id+i)%len(ts.queues)].popBack(); ok {
+			return tile, true
+		}
+	}
+	return 0, false
+}
+
+var (
+	defaultLock      sync.Mutex
+	defaultScheduler *TileScheduler
+)
+
+// The scheduler Camera.RenderVoxels uses, started with one worker per CPU on first use
+func DefaultScheduler() *TileScheduler {
+	defaultLock.Lock()
+	defer defaultLock.Unlock()
+	if defaultScheduler == nil {
+		defaultScheduler = TileSchedulerInit(0)
+	}
+	return defaultScheduler
+}
+
+// Replaces the default scheduler with one using the given number of workers, or one per CPU if threads < 1.
+// Call between frames
+func SetRenderThreads(threads int) {
+	defaultLock.Lock()
+	defer defaultLock.Unlock()
+	if defaultScheduler != nil {
+		defaultScheduler.Close()
+	}
+	defaultScheduler = TileSchedulerInit(threads)
+}
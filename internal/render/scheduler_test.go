@@ -0,0 +1,147 @@
+package render
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/chewxy/math32"
+	"github.com/zheskett/go-voxel/internal/scenes"
+	te "github.com/zheskett/go-voxel/internal/tensor"
+	vxl "github.com/zheskett/go-voxel/internal/voxel"
+)
+
+// The old scheme, one goroutine per row every frame. Kept to compare against
+func renderRows(cam *Camera, vox *vxl.Voxels, pix *Pixels) {
+	basis := CameraRayBasisInit(cam, pix)
+	vox.UpdateLightCache()
+	threads := sync.WaitGroup{}
+	for row := range pix.Height {
+		threads.Go(func() {
+			for column := range pix.Width {
+				cam.renderPixel(vox, pix, basis, column, row)
+			}
+		})
+	}
+	threads.Wait()
+}
+
+// TestTileSchedulerCoversFrame expects every pixel of a frame that isn't a
+// multiple of the tile size to be visited exactly once, with a stat per tile.
+func TestTileSchedulerCoversFrame(t *testing.T) {
+	ts := TileSchedulerInit(3)
+	defer ts.Close()
+
+	width, height := 37, 21
+	visits := make([]atomic.Int32, width*height)
+	for range 4 {
+		ts.RenderTiles(width, height, func(x0, y0, x1, y1 int) {
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					visits[y*width+x].Add(1)
+				}
+			}
+		})
+	}
+	for idx := range visits {
+		if visits[idx].Load() != 4 {
+			t.Fatalf("Pixel (%v, %v) rendered %v times over 4 frames", idx%width, idx/width, visits[idx].Load())
+		}
+	}
+
+	stats := ts.Stats()
+	if len(stats.Tiles) != 6 {
+		t.Fatalf("Expected 6 tiles, got %v", len(stats.Tiles))
+	}
+	pixels := 0
+	for _, tile := range stats.Tiles {
+		pixels += tile.Width * tile.Height
+	}
+	if pixels != width*height {
+		t.Errorf("Expected tile stats to cover %v pixels, got %v", width*height, pixels)
+	}
+}
+
+// TestTileSchedulerMatchesRows expects the tiled render to be identical to the per row one.
+func TestTileSchedulerMatchesRows(t *testing.T) {
+	vox := testWorld()
+	tiled, rows := PixelsInit(45, 30), PixelsInit(45, 30)
+	cam := testCamera(&tiled)
+
+	ts := TileSchedulerInit(4)
+	defer ts.Close()
+	ts.Render(&cam, &vox, &tiled)
+	renderRows(&cam, &vox, &rows)
+
+	for y := range tiled.Height {
+		for x := range tiled.Width {
+			if tiled.GetPixel(x, y) != rows.GetPixel(x, y) {
+				t.Fatalf("Pixel (%v, %v) differs: %v vs %v", x, y, tiled.GetPixel(x, y), rows.GetPixel(x, y))
+			}
+		}
+	}
+}
+
+type benchScene struct {
+	name       string
+	scene      int
+	pos        te.Vector3
+	pitch, yaw float32 // Degrees
+}
+
+var benchScenes = []benchScene{
+	{"small", 0, te.Vec3(8, 12, 8), 15, 45},
+	{"big", 1, te.Vec3(16, 20, 16), 15, 45},
+	{"room", 2, te.Vec3(128, 60, 128), 30, 225},
+}
+
+// Runs render on each debug scene at the window's texture size
+func benchmarkScenes(b *testing.B, render func(cam *Camera, vox *vxl.Voxels, pix *Pixels)) {
+	// The scenes load their assets relative to the repo root
+	b.Chdir("../..")
+	for _, bs := range benchScenes {
+		b.Run(bs.name, func(b *testing.B) {
+			vox := vxl.VoxelsInit(256, 256, 256)
+			dist := scenes.Build(bs.scene, &vox)
+			pix := PixelsInit(400, 300)
+			cam := CameraInit()
+			cam.Fov = 90
+			cam.Aspect = float32(pix.Width) / float32(pix.Height)
+			cam.Pos = bs.pos
+			cam.RenderDistance = dist
+			cam.SetRotationFPS(bs.pitch*math32.Pi/180.0, bs.yaw*math32.Pi/180.0)
+			b.ResetTimer()
+
+			for b.Loop() {
+				render(&cam, &vox, &pix)
+			}
+		})
+	}
+}
+
+func BenchmarkRenderRows(b *testing.B) {
+	benchmarkScenes(b, renderRows)
+}
+
+func BenchmarkRenderTiles(b *testing.B) {
+	ts := TileSchedulerInit(0)
+	defer ts.Close()
+	benchmarkScenes(b, func(cam *Camera, vox *vxl.Voxels, pix *Pixels) {
+		ts.Render(cam, vox, pix)
+		if slowest, ok := ts.Stats().Slowest(); ok {
+			b.ReportMetric(float64(slowest.Duration.Microseconds()), "slowest-tile-µs")
+		}
+	})
+}
+
+// Same as BenchmarkRenderTiles with a range of thread counts
+func BenchmarkRenderTilesThreads(b *testing.B) {
+	for _, threads := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("threads=%v", threads), func(b *testing.B) {
+			ts := TileSchedulerInit(threads)
+			defer ts.Close()
+			benchmarkScenes(b, ts.Render)
+		})
+	}
+}
@@ -0,0 +1,190 @@
+package render
+
+import (
+	"bufio"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/chewxy/math32"
+	te "github.com/zheskett/go-voxel/internal/tensor"
+)
+
+const (
+	// Largest side ReadHDR accepts, run length encoded scanlines can't be any wider
+	maxHDRSide = 0x7fff
+	// Most pixels ReadHDR accepts, enough for an 8K panorama
+	maxHDRPixels = 8192 * 4096
+)
+
+// Skybox is an equirectangular image of everything around the world, in linear RGB.
+// The middle row is the horizon and the middle column looks down -Z
+type Skybox struct {
+	Width  int
+	Height int
+	data   []float32 // 3 floats per pixel, rows from the top
+}
+
+// Loads a .png or Radiance .hdr skybox. PNGs are sRGB, HDRs are linear
+func LoadSkybox(path string) (*Skybox, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".png":
+		img, err := png.Decode(bufio.NewReader(file))
+		if err != nil {
+			return nil, err
+		}
+		return SkyboxFromImage(img), nil
+	case ".hdr":
+		return ReadHDR(bufio.NewReader(file))
+	}
+	return nil, fmt.Errorf("Unknown skybox format: %v", path)
+}
+
+// Converts an sRGB image to a skybox
+func SkyboxFromImage(img image.Image) *Skybox {
+	bounds := img.Bounds()
+	sky := &Skybox{Width: bounds.Dx(), Height: bounds.Dy()}
+	sky.data = make([]float32, 0, sky.Width*sky.Height*3)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			sky.data = append(sky.data, SRGBToLinear(byte(r>>8)), SRGBToLinear(byte(g>>8)), SRGBToLinear(byte(b>>8)))
+		}
+	}
+	return sky
+}
+
+// Reads a Radiance RGBE image, with flat or run length encoded scanlines, like WriteHDR writes
+func ReadHDR(r io.Reader) (*Skybox, error) {
+	br := bufio.NewReader(r)
+	line, err := br.ReadString('\n')
+	if err != nil || !strings.HasPrefix(line, "#?") {
+		return nil, fmt.Errorf("Not a Radiance HDR image")
+	}
+	for {
+		line, err = br.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			break
+		}
+		if strings.HasPrefix(line, "FORMAT=") && line != "FORMAT=32-bit_rle_rgbe" {
+			return nil, fmt.Errorf("Unsupported HDR format: %v", line)
+		}
+	}
+	sky := &Skybox{}
+	if line, err = br.ReadString('\n'); err != nil {
+		return nil, err
+	}
+	if _, err := fmt.Sscanf(line, "-Y %d +X %d", &sky.Height, &sky.Width); err != nil {
+		return nil, fmt.Errorf("Unsupported HDR orientation: %v", strings.TrimSpace(line))
+	}
+	// Checking the sides first keeps the product from overflowing
+	if sky.Width <= 0 || sky.Height <= 0 || sky.Width > maxHDRSide || sky.Height > maxHDRSide ||
+		sky.Width*sky.Height > maxHDRPixels {
+		return nil, fmt.Errorf("Bad HDR size %v x %v", sky.Width, sky.Height)
+	}
+
+	// Grown as the scanlines arrive, so a header promising a huge image doesn't allocate it
+	sky.data = []float32{}
+	scanline := make([]byte, sky.Width*4)
+	for range sky.Height {
+		if err := readScanline(br, scanline); err != nil {
+			return nil, err
+		}
+		for x := range sky.Width {
+			rgbe := scanline[4*x : 4*x+4]
+			if rgbe[3] == 0 {
+				sky.data = append(sky.data, 0, 0, 0)
+				continue
+			}
+			scale := float32(math.Ldexp(1, int(rgbe[3])-128-8))
+			sky.data = append(sky.data, float32(rgbe[0])*scale, float32(rgbe[1])*scale, float32(rgbe[2])*scale)
+		}
+	}
+	return sky, nil
+}
+
+// Reads one scanline of RGBE pixels into out
+func readScanline(br *bufio.Reader, out []byte) error {
+	width := len(out) / 4
+	if _, err := io.ReadFull(br, out[:4]); err != nil {
+		return err
+	}
+	// Run length encoded scanlines start with 2, 2 and the width, and store each channel separately
+	if out[0] != 2 || out[1] != 2 || out[2]&0x80 != 0 || width < 8 || width > 0x7fff {
+		_, err := io.ReadFull(br, out[4:])
+		return err
+	}
+	if int(out[2])<<8|int(out[3]) != width {
+		return fmt.Errorf("HDR scanline width doesn't match the image")
+	}
+	channel := make([]byte, width)
+	for c := range 4 {
+		for x := 0; x < width; {
+			count, err := br.ReadByte()
+			if err != nil {
+				return err
+			}
+			if count > 128 {
+				run := int(count - 128)
+				value, err := br.ReadByte()
+				if err != nil {
+					return err
+				}
+				if x+run > width {
+					return fmt.Errorf("HDR run overflows its scanline")
+				}
+				for i := range run {
+					channel[x+i] = value
+				}
+				x += run
+			} else {
+				if count == 0 || x+int(count) > width {
+					return fmt.Errorf("HDR run overflows its scanline")
+				}
+				if _, err := io.ReadFull(br, channel[x:x+int(count)]); err != nil {
+					return err
+				}
+				x += int(count)
+			}
+		}
+		for x := range width {
+			out[4*x+c] = channel[x]
+		}
+	}
+	return nil
+}
+
+// Linear color of the sky in direction dir, which must be normalized. Bilinearly filtered
+func (sky *Skybox) Sample(dir te.Vector3) te.Vector3 {
+	u := 0.5 + math32.Atan2(dir.X, -dir.Z)/(2*math32.Pi)
+	v := math32.Acos(math32.Min(math32.Max(dir.Y, -1), 1)) / math32.Pi
+
+	fx, fy := u*float32(sky.Width)-0.5, v*float32(sky.Height)-0.5
+	x0, y0 := int(math32.Floor(fx)), int(math32.Floor(fy))
+	tx, ty := fx-float32(x0), fy-float32(y0)
+	texel := func(x, y int) te.Vector3 {
+		// Wraps around horizontally, stops at the poles
+		x = ((x % sky.Width) + sky.Width) % sky.Width
+		y = min(max(y, 0), sky.Height-1)
+		i := 3 * (y*sky.Width + x)
+		return te.Vec3(sky.data[i], sky.data[i+1], sky.data[i+2])
+	}
+	top := mix(texel(x0, y0), texel(x0+1, y0), tx)
+	bottom := mix(texel(x0, y0+1), texel(x0+1, y0+1), tx)
+	return mix(top, bottom, ty)
+}
@@ -0,0 +1,277 @@
+package render
+
+import (
+	"github.com/chewxy/math32"
+	te "github.com/zheskett/go-voxel/internal/tensor"
+)
+
+// Voxel and background colors are sRGB bytes, lighting happens in linear space and
+// the HDR buffer of Pixels holds linear colors where 1.0 is full brightness.
+// HDRPipeline turns that buffer into displayable bytes
+
+type ToneMapper int
+
+const (
+	ToneMapClamp ToneMapper = iota
+	ToneMapReinhard
+	ToneMapACES
+	ToneMapFilmic
+	toneMapperCount
+)
+
+func (tm ToneMapper) String() string {
+	switch tm {
+	case ToneMapClamp:
+		return "clamp"
+	case ToneMapReinhard:
+		return "reinhard"
+	case ToneMapACES:
+		return "aces"
+	case ToneMapFilmic:
+		return "filmic"
+	}
+	return "unknown"
+}
+
+// Tone mapper F4 switches to from this one, filmic goes back round to clamp
+func (tm ToneMapper) Next() ToneMapper {
+	return (tm + 1) % toneMapperCount
+}
+
+// Tone mapper for a -tonemap value: clamp, reinhard, aces or filmic
+func ParseToneMapper(name string) (ToneMapper, bool) {
+	for tm := range toneMapperCount {
+		if tm.String() == name {
+			return tm, true
+		}
+	}
+	return ToneMapClamp, false
+}
+
+// Maps a linear color of any brightness into [0, 1]
+func (tm ToneMapper) Map(c te.Vector3) te.Vector3 {
+	switch tm {
+	case ToneMapReinhard:
+		return te.Vec3(c.X/(1+c.X), c.Y/(1+c.Y), c.Z/(1+c.Z))
+	case ToneMapACES:
+		return te.Vec3(aces(c.X), aces(c.Y), aces(c.Z))
+	case ToneMapFilmic:
+		// Scaled so the white point maps to 1
+		white := 1.0 / hable(filmicWhite)
+		return te.Vec3(hable(c.X*2)*white, hable(c.Y*2)*white, hable(c.Z*2)*white).ComponentMin(1.0)
+	}
+	return c.ComponentMin(1.0)
+}
+
+// Narkowicz's fit of the ACES filmic curve
+func aces(x float32) float32 {
+	x = math32.Max(x, 0)
+	return math32.Min((x*(2.51*x+0.03))/(x*(2.43*x+0.59)+0.14), 1)
+}
+
+// Hable's Uncharted 2 curve
+const filmicWhite = 11.2
+
+func hable(x float32) float32 {
+	const a, b, c, d, e, f = 0.15, 0.50, 0.10, 0.20, 0.02, 0.30
+	x = math32.Max(x, 0)
+	return ((x*(a*x+c*b) + d*e) / (x*(a*x+b) + d*f)) - e/f
+}
+
+// Linear value halfway between each byte and the next, encoding is a binary search over these
+var srgbThresholds [255]float32
+
+func init() {
+	for i := range srgbThresholds {
+		srgbThresholds[i] = te.SRGBDecode((float32(i) + 0.5) / 255.0)
+	}
+}
+
+// Converts an sRGB byte to a linear value in [0, 1]
+func SRGBToLinear(c byte) float32 {
+	return te.SRGBByteToLinear(c)
+}
+
+// Converts a linear value to an sRGB byte, clamping to [0, 1]
+func LinearToSRGB(x float32) byte {
+	lo, hi := 0, len(srgbThresholds)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if srgbThresholds[mid] <= x {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return byte(lo)
+}
+
+// Converts an sRGB color to linear
+func SRGBColorToLinear(c [3]byte) te.Vector3 {
+	return te.SRGBColorToLinear(c)
+}
+
+// Writes a linear color to both buffers, the bytes get clamped and sRGB encoded.
+// HDRPipeline.Resolve can remap the bytes from the HDR buffer afterwards
+func (px *Pixels) SetPixelLinear(x, y int, color te.Vector3) {
+	px.SetPixelHDR(x, y, color)
+	px.SetPixel(x, y, LinearToSRGB(color.X), LinearToSRGB(color.Y), LinearToSRGB(color.Z))
+}
+
+// HDRPipeline exposes, blooms and tone maps the HDR buffer of Pixels into its bytes
+type HDRPipeline struct {
+	ToneMap ToneMapper
+	// Manual exposure in stops, added on top of auto exposure
+	Exposure float32
+
+	// Auto exposure scales the average luminance of the frame to Key,
+	// moving a fraction AdaptRate of the way there every frame
+	AutoExposure bool
+	Key          float32
+	AdaptRate    float32
+	MinExposure  float32
+	MaxExposure  float32
+
+	// Anything brighter than BloomThreshold after exposure bleeds into its
+	// surroundings over BloomRadius pixels
+	Bloom          bool
+	BloomThreshold float32
+	BloomIntensity float32
+	BloomRadius    int
+
+	adapted float32 // Current auto exposure multiplier, 0 until the first frame
+	bright  []float32
+	scratch []float32
+}
+
+func HDRPipelineInit() HDRPipeline {
+	return HDRPipeline{
+		ToneMap:        ToneMapACES,
+		Key:            0.18,
+		AdaptRate:      0.05,
+		MinExposure:    1.0 / 64.0,
+		MaxExposure:    64.0,
+		BloomThreshold: 1.0,
+		BloomIntensity: 0.3,
+		BloomRadius:    6,
+	}
+}
+
+// Current exposure multiplier, including auto exposure
+func (hp *HDRPipeline) ExposureScale() float32 {
+	scale := math32.Exp2(hp.Exposure)
+	if hp.AutoExposure && hp.adapted > 0 {
+		scale *= hp.adapted
+	}
+	return scale
+}
+
+// Rewrites the bytes of pix from its HDR buffer. Does nothing without HDR
+func (hp *HDRPipeline) Resolve(pix *Pixels) {
+	if !pix.HasHDR() {
+		return
+	}
+	if hp.AutoExposure {
+		hp.adapt(pix)
+	}
+	exposure := hp.ExposureScale()
+
+	var bloom []float32
+	if hp.Bloom && hp.BloomRadius > 0 {
+		bloom = hp.bloom(pix, exposure)
+	}
+
+	DefaultScheduler().RenderTiles(pix.Width, pix.Height, func(x0, y0, x1, y1 int) {
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				i := 3 * (pix.Width*y + x)
+				c := te.Vec3(pix.hdr[i+0], pix.hdr[i+1], pix.hdr[i+2]).Mul(exposure)
+				if bloom != nil {
+					c = c.Add(te.Vec3(bloom[i+0], bloom[i+1], bloom[i+2]).Mul(hp.BloomIntensity))
+				}
+				c = hp.ToneMap.Map(c)
+				pix.SetPixel(x, y, LinearToSRGB(c.X), LinearToSRGB(c.Y), LinearToSRGB(c.Z))
+			}
+		}
+	})
+}
+
+func luminance(r, g, b float32) float32 {
+	return 0.2126*r + 0.7152*g + 0.0722*b
+}
+
+// Moves the auto exposure towards putting the frame's log average luminance at Key
+func (hp *HDRPipeline) adapt(pix *Pixels) {
+	sum, count := float32(0), 0
+	// Every 4th pixel is plenty for an average
+	for i := 0; i < len(pix.hdr); i += 3 * 4 {
+		sum += math32.Log(1e-4 + luminance(pix.hdr[i], pix.hdr[i+1], pix.hdr[i+2]))
+		count++
+	}
+	if count == 0 {
+		return
+	}
+	average := math32.Exp(sum / float32(count))
+	target := math32.Min(math32.Max(hp.Key/average, hp.MinExposure), hp.MaxExposure)
+	if hp.adapted == 0 {
+		hp.adapted = target
+		return
+	}
+	hp.adapted += (target - hp.adapted) * hp.AdaptRate
+}
+
+// Bright pass followed by a separable gaussian blur
+func (hp *HDRPipeline) bloom(pix *Pixels, exposure float32) []float32 {
+	if len(hp.bright) != len(pix.hdr) {
+		hp.bright = make([]float32, len(pix.hdr))
+		hp.scratch = make([]float32, len(pix.hdr))
+	}
+	for i := 0; i < len(pix.hdr); i += 3 {
+		r, g, b := pix.hdr[i]*exposure, pix.hdr[i+1]*exposure, pix.hdr[i+2]*exposure
+		lum := luminance(r, g, b)
+		if lum <= hp.BloomThreshold {
+			hp.bright[i], hp.bright[i+1], hp.bright[i+2] = 0, 0, 0
+			continue
+		}
+		// Keep only the part over the threshold, with the pixel's hue
+		keep := (lum - hp.BloomThreshold) / lum
+		hp.bright[i], hp.bright[i+1], hp.bright[i+2] = r*keep, g*keep, b*keep
+	}
+
+	radius := hp.BloomRadius
+	weights := make([]float32, radius+1)
+	sigma := float32(radius) / 2
+	total := float32(0)
+	for i := range weights {
+		weights[i] = math32.Exp(-float32(i*i) / (2 * sigma * sigma))
+		total += weights[i]
+		if i > 0 {
+			total += weights[i]
+		}
+	}
+	for i := range weights {
+		weights[i] /= total
+	}
+
+	blur := func(src, dst []float32, dx, dy int) {
+		for y := range pix.Height {
+			for x := range pix.Width {
+				sum := [3]float32{}
+				for k := -radius; k <= radius; k++ {
+					sx := min(max(x+k*dx, 0), pix.Width-1)
+					sy := min(max(y+k*dy, 0), pix.Height-1)
+					j := 3 * (pix.Width*sy + sx)
+					w := weights[max(k, -k)]
+					sum[0] += src[j] * w
+					sum[1] += src[j+1] * w
+					sum[2] += src[j+2] * w
+				}
+				i := 3 * (pix.Width*y + x)
+				dst[i], dst[i+1], dst[i+2] = sum[0], sum[1], sum[2]
+			}
+		}
+	}
+	blur(hp.bright, hp.scratch, 1, 0)
+	blur(hp.scratch, hp.bright, 0, 1)
+	return hp.bright
+}
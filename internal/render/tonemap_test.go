@@ -0,0 +1,76 @@
+package render
+
+import (
+	"testing"
+
+	te "github.com/zheskett/go-voxel/internal/tensor"
+)
+
+// TestSRGBRoundTrip expects every byte to survive decoding to linear and back.
+func TestSRGBRoundTrip(t *testing.T) {
+	for c := range 256 {
+		if got := LinearToSRGB(SRGBToLinear(byte(c))); got != byte(c) {
+			t.Errorf("Expected %v, got %v", c, got)
+		}
+	}
+}
+
+// TestToneMappers expects every tone mapper to keep black black, stay in [0, 1]
+// and never get darker as the input gets brighter.
+func TestToneMappers(t *testing.T) {
+	for tm := range toneMapperCount {
+		if got := tm.Map(te.Vec3Zero()); got.Max() > 1e-4 {
+			t.Errorf("%v: expected black to stay black, got %v", tm, got)
+		}
+		prev := float32(0)
+		for x := float32(0.01); x < 1000; x *= 1.5 {
+			y := tm.Map(te.Vec3Splat(x)).X
+			if y < prev || y > 1 {
+				t.Errorf("%v: %v maps to %v after %v", tm, x, y, prev)
+				break
+			}
+			prev = y
+		}
+		if parsed, ok := ParseToneMapper(tm.String()); !ok || parsed != tm {
+			t.Errorf("%v: expected name to parse back", tm)
+		}
+	}
+}
+
+// TestAutoExposure expects auto exposure to bring a dim frame's average up to the key.
+func TestAutoExposure(t *testing.T) {
+	pix := PixelsInitHDR(8, 8)
+	for y := range pix.Height {
+		for x := range pix.Width {
+			pix.SetPixelHDR(x, y, te.Vec3Splat(0.01))
+		}
+	}
+	hp := HDRPipelineInit()
+	hp.ToneMap = ToneMapClamp
+	hp.AutoExposure = true
+	for range 200 {
+		hp.Resolve(&pix)
+	}
+	if got := hp.ExposureScale(); got < 17 || got > 19 {
+		t.Errorf("Expected exposure near 18, got %v", got)
+	}
+	if got, want := int(pix.GetPixel(3, 3)[0]), int(LinearToSRGB(0.18)); got < want-1 || got > want+1 {
+		t.Errorf("Expected pixel near %v, got %v", want, got)
+	}
+}
+
+// TestBloom expects a single bright pixel to light up its neighbors.
+func TestBloom(t *testing.T) {
+	pix := PixelsInitHDR(16, 16)
+	pix.SetPixelHDR(8, 8, te.Vec3Splat(50))
+	hp := HDRPipelineInit()
+	hp.Resolve(&pix)
+	if pix.GetPixel(10, 8) != [3]byte{} {
+		t.Fatalf("Expected no bloom when it's off, got %v", pix.GetPixel(10, 8))
+	}
+	hp.Bloom = true
+	hp.Resolve(&pix)
+	if pix.GetPixel(10, 8)[0] == 0 || pix.GetPixel(8, 10)[0] == 0 {
+		t.Errorf("Expected bloom around the bright pixel")
+	}
+}
@@ -0,0 +1,273 @@
+package scenes
+
+import (
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chewxy/math32"
+	ren "github.com/zheskett/go-voxel/internal/render"
+	te "github.com/zheskett/go-voxel/internal/tensor"
+	vxl "github.com/zheskett/go-voxel/internal/voxel"
+)
+
+// Golden image tests render every debug scene from fixed cameras and compare
+// against the PNGs in testdata/golden. After an intended change to the
+// renderer, regenerate them with
+//
+//	go test ./internal/scenes -run TestGolden -update
+//
+// and look over the new images before committing them.
+var (
+	update    = flag.Bool("update", false, "Regenerate the golden images instead of comparing against them")
+	large     = flag.Bool("large", false, "Also test the 512³ scenes, these need several GB of memory")
+	tolerance = flag.Int("tolerance", 8, "Largest per channel difference a pixel can have and still match")
+)
+
+const (
+	goldenWidth  = 96
+	goldenHeight = 72
+
+	// Fraction of pixels allowed past the tolerance, so a float difference
+	// flipping a single DDA step on another platform doesn't fail the test
+	maxBadFraction = 0.002
+)
+
+type goldenShot struct {
+	name       string
+	scene      int
+	large      bool
+	pos        te.Vector3
+	pitch, yaw float32 // Degrees
+}
+
+var goldenShots = []goldenShot{
+	{"small_corner", 0, false, te.Vec3(8, 12, 8), 15, 45},
+	{"small_wall", 0, false, te.Vec3(80, 20, 90), 10, 200},
+	{"big_ball", 1, false, te.Vec3(16, 20, 16), 15, 45},
+	{"big_floor", 1, false, te.Vec3(120, 40, 120), 45, 220},
+	{"room_objects", 2, false, te.Vec3(128, 60, 128), 30, 225},
+	{"room_bunny", 2, false, te.Vec3(128, 60, 128), 30, 45},
+	{"huge_bunny", 3, true, te.Vec3(16, 200, 16), 20, 45},
+	// The trees scene isn't here, it needs assets/sponza.vox, which isn't checked in
+}
+
+var goldenDir string
+
+// The scenes load their assets relative to the repo root
+func TestMain(m *testing.M) {
+	flag.Parse()
+	dir, err := filepath.Abs(filepath.Join("testdata", "golden"))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	goldenDir = dir
+	if err := os.Chdir(filepath.Join("..", "..")); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+func TestGolden(t *testing.T) {
+	worlds := map[int]*vxl.Voxels{}
+	dists := map[int]float32{}
+	for _, shot := range goldenShots {
+		t.Run(shot.name, func(t *testing.T) {
+			if shot.large && !*large {
+				t.Skip("Large scene, run with -large")
+			}
+			vox, ok := worlds[shot.scene]
+			if !ok {
+				// Free the last scene first, the large ones are big
+				clear(worlds)
+				world, dist, err := buildScene(shot.scene)
+				if err != nil {
+					t.Fatalf("Scene %v could not be built: %v", shot.scene, err)
+				}
+				vox = &world
+				worlds[shot.scene], dists[shot.scene] = vox, dist
+			}
+
+			cam := ren.CameraInit()
+			cam.Fov = 90
+			cam.Pos = shot.pos
+			cam.RenderDistance = dists[shot.scene]
+			cam.SetRotationFPS(shot.pitch*math32.Pi/180.0, shot.yaw*math32.Pi/180.0)
+			pix := ren.RenderHeadless(&cam, vox, goldenWidth, goldenHeight)
+			got := pix.Image()
+
+			path := filepath.Join(goldenDir, shot.name+".png")
+			if *update {
+				if err := writePNG(path, got); err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			want, err := readPNG(path)
+			if err != nil {
+				t.Fatalf("Missing golden image, run with -update to create it: %v", err)
+			}
+			if want.Bounds() != got.Bounds() {
+				t.Fatalf("Golden image is %v, render is %v", want.Bounds().Size(), got.Bounds().Size())
+			}
+
+			cmp := compareImages(want, got, *tolerance)
+			t.Logf("PSNR %.2f dB, SSIM %.4f, %v pixels past tolerance, max difference %v",
+				cmp.psnr, cmp.ssim, cmp.bad, cmp.maxDiff)
+			if float64(cmp.bad) <= maxBadFraction*float64(goldenWidth*goldenHeight) {
+				return
+			}
+
+			outDir := filepath.Join(os.TempDir(), "go-voxel-golden")
+			actual := filepath.Join(outDir, shot.name+"_actual.png")
+			diff := filepath.Join(outDir, shot.name+"_diff.png")
+			if err := os.MkdirAll(outDir, 0o755); err == nil {
+				writePNG(actual, got)
+				writePNG(diff, cmp.diff)
+			}
+			t.Errorf("Render differs from %v in %v pixels, wrote %v and %v", path, cmp.bad, actual, diff)
+		})
+	}
+}
+
+// The scenes panic when an asset is missing, turn that into an error so the shot fails cleanly
+func buildScene(scene int) (vox vxl.Voxels, dist float32, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	vox = vxl.VoxelsInit(256, 256, 256)
+	dist = Build(scene, &vox)
+	return vox, dist, nil
+}
+
+type imageComparison struct {
+	bad     int
+	maxDiff int
+	psnr    float64
+	ssim    float64
+	diff    *image.RGBA
+}
+
+// Compares two images of the same size. The diff image is the amplified
+// per channel difference, with pixels past the tolerance drawn in red
+func compareImages(want, got image.Image, tolerance int) imageComparison {
+	bounds := want.Bounds()
+	cmp := imageComparison{diff: image.NewRGBA(bounds)}
+	sqerr := 0.0
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			a, b := rgb(want.At(x, y)), rgb(got.At(x, y))
+			worst := 0
+			d := [3]int{}
+			for c := range 3 {
+				d[c] = abs(a[c] - b[c])
+				worst = max(worst, d[c])
+				sqerr += float64(d[c] * d[c])
+			}
+			cmp.maxDiff = max(cmp.maxDiff, worst)
+			if worst > tolerance {
+				cmp.bad++
+				cmp.diff.SetRGBA(x, y, color.RGBA{255, 0, 0, 255})
+				continue
+			}
+			cmp.diff.SetRGBA(x, y, color.RGBA{
+				byte(min(d[0]*16, 255)), byte(min(d[1]*16, 255)), byte(min(d[2]*16, 255)), 255,
+			})
+		}
+	}
+
+	mse := sqerr / float64(bounds.Dx()*bounds.Dy()*3)
+	cmp.psnr = math.Inf(1)
+	if mse > 0 {
+		cmp.psnr = 10 * math.Log10(255*255/mse)
+	}
+	cmp.ssim = ssim(want, got)
+	return cmp
+}
+
+// Mean SSIM of the luma over 8x8 windows
+func ssim(want, got image.Image) float64 {
+	const window = 8
+	c1, c2 := math.Pow(0.01*255, 2), math.Pow(0.03*255, 2)
+	bounds := want.Bounds()
+	total, count := 0.0, 0
+	for wy := bounds.Min.Y; wy+window <= bounds.Max.Y; wy += window {
+		for wx := bounds.Min.X; wx+window <= bounds.Max.X; wx += window {
+			var ma, mb, va, vb, cov float64
+			for y := wy; y < wy+window; y++ {
+				for x := wx; x < wx+window; x++ {
+					ma += luma(want.At(x, y))
+					mb += luma(got.At(x, y))
+				}
+			}
+			n := float64(window * window)
+			ma, mb = ma/n, mb/n
+			for y := wy; y < wy+window; y++ {
+				for x := wx; x < wx+window; x++ {
+					a, b := luma(want.At(x, y))-ma, luma(got.At(x, y))-mb
+					va += a * a
+					vb += b * b
+					cov += a * b
+				}
+			}
+			va, vb, cov = va/(n-1), vb/(n-1), cov/(n-1)
+			total += ((2*ma*mb + c1) * (2*cov + c2)) / ((ma*ma + mb*mb + c1) * (va + vb + c2))
+			count++
+		}
+	}
+	if count == 0 {
+		return 1
+	}
+	return total / float64(count)
+}
+
+func rgb(c color.Color) [3]int {
+	r, g, b, _ := c.RGBA()
+	return [3]int{int(r >> 8), int(g >> 8), int(b >> 8)}
+}
+
+func luma(c color.Color) float64 {
+	p := rgb(c)
+	return 0.299*float64(p[0]) + 0.587*float64(p[1]) + 0.114*float64(p[2])
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+func readPNG(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return png.Decode(file)
+}
+
+func writePNG(path string, img image.Image) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(file, img); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
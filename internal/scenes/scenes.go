@@ -8,6 +8,29 @@ import (
 	vxl "github.com/zheskett/go-voxel/internal/voxel"
 )
 
+// Menu of the debug scenes, in the order Build takes them
+const Menu = "1 for the big scene, 2 for room, 3 for big bunny, 4 for .vox objects, anything else for small scene"
+
+// Builds one of the debug scenes from Menu into vox.
+// Returns the render distance the scene is meant to be viewed with
+func Build(scene int, vox *vxl.Voxels) float32 {
+	switch scene {
+	case 1:
+		VoxelDebugSceneBig(vox)
+	case 2:
+		VoxelDebugEmptyScene(vox)
+	case 3:
+		VoxelDebugSceneHugeBunny(vox)
+		return 560.0
+	case 4:
+		VoxelDebugSceneTrees(vox)
+		return 560.0
+	default:
+		VoxelDebugSceneSmall(vox)
+	}
+	return 256.0
+}
+
 func VoxelDebugEmptyScene(vox *vxl.Voxels) {
 	brightness := 10000.0
 	vox.Lights = append(vox.Lights, vxl.Light{
@@ -185,7 +208,8 @@ func VoxelDebugSceneBig(vox *vxl.Voxels) {
 		vox.SetVoxel(25, i, 9, 30, 255, 30)
 	}
 	// A big ominous ball
-	// Also a bunch of random colored voxels
+	// Also a bunch of random colored voxels, seeded so the scene is the same every run
+	rng := rand.New(rand.NewSource(1))
 	center, radius := te.Vec3(64, 64, 64), 24
 	for i := 0; i < vox.Z; i++ {
 		for j := 0; j < vox.Y; j++ {
@@ -194,8 +218,8 @@ func VoxelDebugSceneBig(vox *vxl.Voxels) {
 				if center.Sub(point).LenSqr() < float32(radius*radius) {
 					vox.SetVoxel(k, j, i, 20, 20, 20)
 				}
-				if rand.Intn(2500) == 0 {
-					vox.SetVoxel(k, j, i, byte(rand.Intn(255)), byte(rand.Intn(255)), byte(rand.Intn(255)))
+				if rng.Intn(2500) == 0 {
+					vox.SetVoxel(k, j, i, byte(rng.Intn(255)), byte(rng.Intn(255)), byte(rng.Intn(255)))
 				}
 			}
 		}
@@ -245,41 +269,28 @@ func VoxelDebugSceneHugeBunny(vox *vxl.Voxels) {
 }
 
 func VoxelDebugSceneTrees(vox *vxl.Voxels) {
-	brightness := 30000
-	light := vxl.Light{
-		Position: te.Vec3(4, 2, 6),
-		Color:    te.Vec3(1.0, 1.0, 1.0).Mul(float32(brightness / 2)),
-	}
-	light2 := vxl.Light{
-		Position: te.Vec3(500, 32, 96),
-		Color:    te.Vec3(1.0, 1.0, 1.0).Mul(float32(brightness)),
-	}
-	light3 := vxl.Light{
-		Position: te.Vec3(500, 32, 500),
-		Color:    te.Vec3(1.0, 1.0, 1.0).Mul(float32(brightness)),
+	// A low sun does what eight point lights used to
+	sun := vxl.DirectionalLight{
+		Direction: te.Vec3(-0.6, -1.0, -0.4),
+		Color:     te.Vec3(1.0, 0.95, 0.85).Mul(1.2),
 	}
-	light4 := vxl.Light{
-		Position: te.Vec3(500, 32, 256),
-		Color:    te.Vec3(1.0, 1.0, 1.0).Mul(float32(brightness)),
-	}
-	light5 := vxl.Light{
+	// Soft fill from the open side so the shadows aren't black
+	sky := vxl.RectLight{
 		Position: te.Vec3(256, 400, 256),
-		Color:    te.Vec3(1.0, 1.0, 1.0).Mul(float32(brightness)),
-	}
-	light6 := vxl.Light{
-		Position: te.Vec3(180, 200, 200),
-		Color:    te.Vec3(1.0, 1.0, 1.0).Mul(float32(brightness / 4)),
-	}
-	light7 := vxl.Light{
-		Position: te.Vec3(1, 140, 250),
-		Color:    te.Vec3(1.0, 1.0, 1.0).Mul(float32(brightness / 3)),
-	}
-	light8 := vxl.Light{
-		Position: te.Vec3(1, 140, 0),
-		Color:    te.Vec3(1.0, 1.0, 1.0).Mul(float32(brightness / 3)),
+		U:        te.Vec3(128, 0, 0),
+		V:        te.Vec3(0, 0, 128),
+		Color:    te.Vec3(0.6, 0.7, 1.0).Mul(30000),
+		Count:    4,
+	}
+	lamp := vxl.SpotLight{
+		Position:  te.Vec3(4, 40, 6),
+		Direction: te.Vec3(1, -1, 1),
+		Color:     te.Vec3(1.0, 0.8, 0.6).Mul(15000),
+		Angle:     0.6,
+		Softness:  0.5,
 	}
 	*vox = vxl.VoxelsInit(512, 512, 512)
-	vox.Lights = append(vox.Lights, light, light2, light3, light4, light5, light6, light7, light8)
+	vox.Lights = append(vox.Lights, sun, sky, lamp)
 
 	// Fall Tree
 	fallTree, err := vxl.ConvertVoxPath("assets/FallTree.vox", false, true, false)
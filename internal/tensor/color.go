@@ -0,0 +1,38 @@
+package tensor
+
+import (
+	"github.com/chewxy/math32"
+)
+
+// Linear values of sRGB bytes, for SRGBByteToLinear
+var srgbTable = func() [256]float32 {
+	table := [256]float32{}
+	for i := range table {
+		table[i] = SRGBDecode(float32(i) / 255)
+	}
+	return table
+}()
+
+// Converts an sRGB value in [0, 1] to linear
+func SRGBDecode(c float32) float32 {
+	if c <= 0.04045 {
+		return c / 12.92
+	}
+	return math32.Pow((c+0.055)/1.055, 2.4)
+}
+
+// Converts an sRGB byte to a linear value in [0, 1], from a table
+func SRGBByteToLinear(c byte) float32 {
+	return srgbTable[c]
+}
+
+// Converts an sRGB color to linear, from a table
+func SRGBColorToLinear(c [3]byte) Vector3 {
+	return Vector3{srgbTable[c[0]], srgbTable[c[1]], srgbTable[c[2]]}
+}
+
+// 0 below edge0 and 1 above edge1, with a smooth Hermite curve in between
+func Smoothstep(edge0, edge1, x float32) float32 {
+	t := math32.Min(math32.Max((x-edge0)/(edge1-edge0), 0), 1)
+	return t * t * (3 - 2*t)
+}
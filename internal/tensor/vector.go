@@ -261,6 +261,17 @@ func (v1 Vector3) Cross(v2 Vector3) Vector3 {
 	}
 }
 
+// Returns two unit vectors perpendicular to the vector and to each other, which with a unit
+// vector make an orthonormal basis. Both are zero for the zero vector
+func (v Vector3) Perpendiculars() (Vector3, Vector3) {
+	helper := Vec3X()
+	if math32.Abs(v.X) > 0.9 {
+		helper = Vec3Y()
+	}
+	a := v.Cross(helper).NormalizedOrZero()
+	return a, v.Cross(a)
+}
+
 // Performs a min comparison for all the elements of the vector
 func (v Vector3) ComponentMin(c float32) Vector3 {
 	return Vector3{math32.Min(c, v.X), math32.Min(c, v.Y), math32.Min(c, v.Z)}
@@ -0,0 +1,94 @@
+package voxel
+
+import (
+	"sync"
+)
+
+const (
+	// Number of rays a worker takes at a time
+	rayBatchSize = 64
+)
+
+type rayJob struct {
+	fn         func(start, end int)
+	start, end int
+	done       *sync.WaitGroup
+}
+
+// RayPool traces batches of rays on a fixed set of worker goroutines
+type RayPool struct {
+	jobs    chan rayJob
+	workers sync.WaitGroup
+}
+
+// Starts a pool with the given number of workers, or one per CPU if workers < 1
+func RayPoolInit(workers int) *RayPool {
+	if workers < 1 {
+		workers = cpus
+	}
+	pool := &RayPool{jobs: make(chan rayJob, workers*4)}
+	for range workers {
+		pool.workers.Go(func() {
+			for job := range pool.jobs {
+				job.fn(job.start, job.end)
+				job.done.Done()
+			}
+		})
+	}
+	return pool
+}
+
+// Stops the workers, the pool can't be used afterwards
+func (pool *RayPool) Close() {
+	close(pool.jobs)
+	pool.workers.Wait()
+}
+
+// Finds the closest hit of every ray within its Tmax, hits must be at least as long as rays
+func (pool *RayPool) TraceRays(vox *Voxels, rays []Ray, hits []RayHit) {
+	if len(hits) < len(rays) {
+		panic("voxel: TraceRays hits shorter than rays")
+	}
+	pool.run(len(rays), func(start, end int) {
+		for i := start; i < end; i++ {
+			hits[i] = vox.MarchRay(rays[i])
+		}
+	})
+}
+
+// Same as TraceRays, but no ray travels further than maxDist
+func (pool *RayPool) TraceRaysMax(vox *Voxels, rays []Ray, maxDist float32, hits []RayHit) {
+	if len(hits) < len(rays) {
+		panic("voxel: TraceRaysMax hits shorter than rays")
+	}
+	pool.run(len(rays), func(start, end int) {
+		for i := start; i < end; i++ {
+			ray := rays[i]
+			ray.Tmax = min(ray.Tmax, maxDist)
+			hits[i] = vox.MarchRay(ray)
+		}
+	})
+}
+
+// Finds whether anything blocks each ray within its Tmax.
+// Stops at the first voxel hit, so this is much cheaper than TraceRays for shadows
+func (pool *RayPool) TraceShadowRays(vox *Voxels, rays []Ray, occluded []bool) {
+	if len(occluded) < len(rays) {
+		panic("voxel: TraceShadowRays occluded shorter than rays")
+	}
+	pool.run(len(rays), func(start, end int) {
+		for i := start; i < end; i++ {
+			occluded[i] = vox.Occluded(rays[i])
+		}
+	})
+}
+
+// Splits [0, n) into batches for the workers and waits for all of them to finish
+func (pool *RayPool) run(n int, fn func(start, end int)) {
+	done := sync.WaitGroup{}
+	for start := 0; start < n; start += rayBatchSize {
+		done.Add(1)
+		pool.jobs <- rayJob{fn, start, min(start+rayBatchSize, n), &done}
+	}
+	done.Wait()
+}
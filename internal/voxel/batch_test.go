@@ -0,0 +1,47 @@
+package voxel
+
+import (
+	"math/rand"
+	"testing"
+
+	te "github.com/zheskett/go-voxel/internal/tensor"
+)
+
+// TestBatchMatchesMarchRay traces random rays through a random world and
+// expects the batch results to match MarchRay one ray at a time.
+func TestBatchMatchesMarchRay(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	vox := VoxelsInit(32, 32, 32)
+	for range 2000 {
+		vox.SetVoxel(rng.Intn(32), rng.Intn(32), rng.Intn(32), 255, 255, 255)
+	}
+
+	rays := make([]Ray, 1000)
+	for i := range rays {
+		origin := te.Vec3(rng.Float32()*32, rng.Float32()*32, rng.Float32()*32)
+		dir := te.Vec3(rng.Float32()-0.5, rng.Float32()-0.5, rng.Float32()-0.5).Normalized()
+		rays[i] = Ray{Origin: origin, Dir: dir, Tmax: 40}
+	}
+
+	pool := RayPoolInit(4)
+	defer pool.Close()
+	hits := make([]RayHit, len(rays))
+	near := make([]RayHit, len(rays))
+	occluded := make([]bool, len(rays))
+	pool.TraceRays(&vox, rays, hits)
+	pool.TraceRaysMax(&vox, rays, 2, near)
+	pool.TraceShadowRays(&vox, rays, occluded)
+
+	for i, ray := range rays {
+		expected := vox.MarchRay(ray)
+		if hits[i] != expected {
+			t.Fatalf("Ray %v: expected %v, got %v", i, expected, hits[i])
+		}
+		if occluded[i] != expected.Hit {
+			t.Fatalf("Ray %v: expected occluded %v, got %v", i, expected.Hit, occluded[i])
+		}
+		if near[i].Hit && near[i].Time > 2 {
+			t.Fatalf("Ray %v: hit at %v past max distance", i, near[i].Time)
+		}
+	}
+}
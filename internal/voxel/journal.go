@@ -0,0 +1,246 @@
+package voxel
+
+import (
+	"sync"
+	"unsafe"
+)
+
+const (
+	DefaultJournalTransactions = 256
+	DefaultJournalBytes        = 64 << 20
+)
+
+// The contents of a single voxel cell
+type VoxelState struct {
+	Present  bool
+	Color    [3]byte
+	Material MaterialID
+}
+
+// A reversible edit of a single voxel
+type VoxelDelta struct {
+	X, Y, Z int
+	Before  VoxelState
+	After   VoxelState
+}
+
+// A group of edits that are undone and redone together, such as a brush stroke
+type Transaction struct {
+	Deltas []VoxelDelta
+}
+
+// Called with every delta that was applied to the world, whether from an edit, undo or redo
+type ChangeListener func(changes []VoxelDelta)
+
+// Journal records edits to a world so they can be undone and redone.
+// All edits that should be revertable have to go through the journal instead of the Voxels directly.
+type Journal struct {
+	vox     *Voxels
+	undo    []Transaction
+	redo    []Transaction
+	current *Transaction
+	bytes   int
+
+	listeners []ChangeListener
+
+	pendingLock sync.Mutex
+	pending     []func(*Journal) // Edits submitted while a frame was rendering
+
+	MaxTransactions int // Oldest transactions are dropped past this many
+	MaxBytes        int // Oldest transactions are dropped once the undo and redo stacks use this much memory
+}
+
+func JournalInit(vox *Voxels) *Journal {
+	return &Journal{
+		vox:             vox,
+		MaxTransactions: DefaultJournalTransactions,
+		MaxBytes:        DefaultJournalBytes,
+	}
+}
+
+// Subscribes a listener to all changes made through the journal
+func (journal *Journal) Subscribe(listener ChangeListener) {
+	journal.listeners = append(journal.listeners, listener)
+}
+
+// Queues an edit to be applied between frames by ApplyPending.
+// Safe to call from any goroutine, including while the world is being rendered
+func (journal *Journal) Submit(edit func(*Journal)) {
+	journal.pendingLock.Lock()
+	journal.pending = append(journal.pending, edit)
+	journal.pendingLock.Unlock()
+}
+
+// Applies all submitted edits in the order they were submitted.
+// Must not be called while anything is reading the world
+func (journal *Journal) ApplyPending() {
+	journal.pendingLock.Lock()
+	pending := journal.pending
+	journal.pending = nil
+	journal.pendingLock.Unlock()
+
+	for _, edit := range pending {
+		edit(journal)
+	}
+}
+
+// Starts grouping edits into a single transaction until Commit is called
+func (journal *Journal) Begin() {
+	if journal.current == nil {
+		journal.current = &Transaction{}
+	}
+}
+
+// Ends the current transaction and pushes it onto the undo stack
+func (journal *Journal) Commit() {
+	tx := journal.current
+	journal.current = nil
+	if tx == nil || len(tx.Deltas) == 0 {
+		return
+	}
+
+	for _, redo := range journal.redo {
+		journal.bytes -= redo.size()
+	}
+	journal.redo = journal.redo[:0]
+	journal.undo = append(journal.undo, *tx)
+	journal.bytes += tx.size()
+	journal.trim()
+}
+
+// Whether there is an uncommitted transaction
+func (journal *Journal) InTransaction() bool {
+	return journal.current != nil
+}
+
+func (journal *Journal) SetVoxel(x, y, z int, r, g, b byte) {
+	journal.edit(x, y, z, VoxelState{true, [3]byte{r, g, b}, MaterialDiffuse})
+}
+
+// Same as SetVoxel, but with a material other than MaterialDiffuse
+func (journal *Journal) SetVoxelMaterial(x, y, z int, r, g, b byte, material MaterialID) {
+	journal.edit(x, y, z, VoxelState{true, [3]byte{r, g, b}, material})
+}
+
+func (journal *Journal) ResetVoxel(x, y, z int) {
+	journal.edit(x, y, z, VoxelState{})
+}
+
+// Adds a voxel object to the world as a single transaction (or part of the current one).
+// The object's materials are added to the world's, undoing only takes the voxels back off
+func (journal *Journal) AddVoxelObj(vObj VoxelObj, x, y, z int) {
+	ids := make(map[byte]MaterialID, len(vObj.Materials))
+	for cIdx, mat := range vObj.Materials {
+		if id, err := journal.vox.AddMaterial(mat); err == nil {
+			ids[cIdx] = id
+		}
+	}
+	autoCommit := !journal.InTransaction()
+	journal.Begin()
+	for xyz, cIdx := range vObj.Voxels {
+		vx, vy, vz := int(xyz[0]), int(xyz[1]), int(xyz[2])
+		if journal.vox.Surrounds(x+vx, y+vy, z+vz) {
+			clr := vObj.ColorPalete[cIdx]
+			material, ok := ids[cIdx]
+			if !ok {
+				material = MaterialDiffuse
+			}
+			journal.SetVoxelMaterial(x+vx, y+vy, z+vz, clr.R, clr.G, clr.B, material)
+		}
+	}
+	if autoCommit {
+		journal.Commit()
+	}
+}
+
+// Reverts the most recent transaction, returns false if there was nothing to undo
+func (journal *Journal) Undo() bool {
+	journal.Commit()
+	if len(journal.undo) == 0 {
+		return false
+	}
+	tx := journal.undo[len(journal.undo)-1]
+	journal.undo = journal.undo[:len(journal.undo)-1]
+
+	reverted := make([]VoxelDelta, len(tx.Deltas))
+	for i, delta := range tx.Deltas {
+		delta.Before, delta.After = delta.After, delta.Before
+		reverted[len(tx.Deltas)-1-i] = delta
+	}
+	journal.apply(reverted)
+	journal.redo = append(journal.redo, tx)
+	return true
+}
+
+// Reapplies the most recently undone transaction, returns false if there was nothing to redo
+func (journal *Journal) Redo() bool {
+	journal.Commit()
+	if len(journal.redo) == 0 {
+		return false
+	}
+	tx := journal.redo[len(journal.redo)-1]
+	journal.redo = journal.redo[:len(journal.redo)-1]
+
+	journal.apply(tx.Deltas)
+	journal.undo = append(journal.undo, tx)
+	return true
+}
+
+// Drops all undo and redo history
+func (journal *Journal) Clear() {
+	journal.undo = nil
+	journal.redo = nil
+	journal.current = nil
+	journal.bytes = 0
+}
+
+func (journal *Journal) edit(x, y, z int, after VoxelState) {
+	if !journal.vox.Surrounds(x, y, z) {
+		return
+	}
+	idx := journal.vox.Index(x, y, z)
+	before := VoxelState{journal.vox.Presence.Get(idx), journal.vox.Color[idx], journal.vox.Material(idx)}
+	if before == after {
+		return
+	}
+
+	delta := VoxelDelta{x, y, z, before, after}
+	journal.apply([]VoxelDelta{delta})
+
+	// Single edits outside of a transaction are their own transaction
+	autoCommit := !journal.InTransaction()
+	journal.Begin()
+	journal.current.Deltas = append(journal.current.Deltas, delta)
+	if autoCommit {
+		journal.Commit()
+	}
+}
+
+func (journal *Journal) apply(deltas []VoxelDelta) {
+	vox := journal.vox
+	for _, delta := range deltas {
+		idx := vox.Index(delta.X, delta.Y, delta.Z)
+		vox.Presence.Put(idx, delta.After.Present)
+		vox.Color[idx] = delta.After.Color
+		vox.SetMaterial(delta.X, delta.Y, delta.Z, delta.After.Material)
+	}
+	for _, listener := range journal.listeners {
+		listener(deltas)
+	}
+}
+
+// Drops the oldest transactions until the journal is within its limits
+func (journal *Journal) trim() {
+	drop := 0
+	for drop < len(journal.undo) &&
+		(len(journal.undo)-drop > journal.MaxTransactions || journal.bytes > journal.MaxBytes) {
+		journal.bytes -= journal.undo[drop].size()
+		drop++
+	}
+	journal.undo = append(journal.undo[:0], journal.undo[drop:]...)
+}
+
+// Approximate memory used by the transaction
+func (tx *Transaction) size() int {
+	return len(tx.Deltas) * int(unsafe.Sizeof(VoxelDelta{}))
+}
@@ -0,0 +1,97 @@
+package voxel
+
+import (
+	"testing"
+
+	"github.com/zheskett/go-voxel/pkg/voxparse"
+)
+
+// TestJournalUndoRedo records a stroke as one transaction and expects undo
+// and redo to revert and reapply all of it.
+func TestJournalUndoRedo(t *testing.T) {
+	vox := VoxelsInit(8, 8, 8)
+	vox.SetVoxel(1, 1, 1, 10, 20, 30)
+	journal := JournalInit(&vox)
+	notified := 0
+	journal.Subscribe(func(changes []VoxelDelta) { notified += len(changes) })
+
+	journal.Begin()
+	journal.ResetVoxel(1, 1, 1)
+	journal.SetVoxel(2, 2, 2, 255, 255, 255)
+	journal.Commit()
+
+	if vox.Presence.Get(vox.Index(1, 1, 1)) || !vox.Presence.Get(vox.Index(2, 2, 2)) {
+		t.Fatalf("Expected edits to be applied")
+	}
+	if !journal.Undo() {
+		t.Fatalf("Expected undo to succeed")
+	}
+	if !vox.Presence.Get(vox.Index(1, 1, 1)) || vox.Color[vox.Index(1, 1, 1)] != [3]byte{10, 20, 30} {
+		t.Errorf("Expected (1, 1, 1) to be restored")
+	}
+	if vox.Presence.Get(vox.Index(2, 2, 2)) {
+		t.Errorf("Expected (2, 2, 2) to be removed")
+	}
+	if !journal.Redo() || vox.Presence.Get(vox.Index(1, 1, 1)) || !vox.Presence.Get(vox.Index(2, 2, 2)) {
+		t.Errorf("Expected redo to reapply the transaction")
+	}
+	if journal.Redo() {
+		t.Errorf("Expected nothing left to redo")
+	}
+	if notified != 6 {
+		t.Errorf("Expected 6 notified changes, got %v", notified)
+	}
+}
+
+// TestJournalLimits expects the oldest transactions to be dropped once the
+// journal is over its transaction limit.
+func TestJournalLimits(t *testing.T) {
+	vox := VoxelsInit(8, 8, 8)
+	journal := JournalInit(&vox)
+	journal.MaxTransactions = 2
+	for i := range 4 {
+		journal.SetVoxel(i, 0, 0, 1, 1, 1)
+	}
+
+	undone := 0
+	for journal.Undo() {
+		undone++
+	}
+	if undone != 2 {
+		t.Errorf("Expected 2 undoable transactions, got %v", undone)
+	}
+	if !vox.Presence.Get(vox.Index(1, 0, 0)) || vox.Presence.Get(vox.Index(2, 0, 0)) {
+		t.Errorf("Expected only the two newest edits to be undone")
+	}
+}
+
+// TestJournalAddVoxelObjMaterials expects an object added through the journal to keep
+// its materials, same as adding it to the world directly, and undo to take them off.
+func TestJournalAddVoxelObjMaterials(t *testing.T) {
+	glow := Material{Kind: KindEmissive, Emission: 2, Light: true}
+	obj := VoxelObj{
+		X: 2, Y: 1, Z: 1,
+		Voxels:      map[[3]int16]byte{{0, 0, 0}: 1, {1, 0, 0}: 2},
+		ColorPalete: voxparse.DefaultPalette,
+		Materials:   map[byte]Material{1: glow},
+	}
+	direct := VoxelsInit(8, 8, 8)
+	direct.AddVoxelObj(obj, 3, 3, 3)
+	vox := VoxelsInit(8, 8, 8)
+	journal := JournalInit(&vox)
+	journal.AddVoxelObj(obj, 3, 3, 3)
+
+	lit, plain := vox.Index(3, 3, 3), vox.Index(4, 3, 3)
+	if vox.MaterialProps(vox.Material(lit)) != glow || vox.Material(lit) != direct.Material(lit) {
+		t.Errorf("Expected the emissive voxel to get its material, got %v", vox.MaterialProps(vox.Material(lit)))
+	}
+	if vox.Material(plain) != MaterialDiffuse || direct.Material(plain) != MaterialDiffuse {
+		t.Errorf("Expected the voxel without a material to stay diffuse")
+	}
+	if !journal.Undo() || vox.Presence.Get(lit) || vox.Material(lit) != MaterialDiffuse {
+		t.Errorf("Expected undo to remove the object and its materials")
+	}
+	if !journal.Redo() || vox.MaterialProps(vox.Material(lit)) != glow {
+		t.Errorf("Expected redo to bring the emissive material back")
+	}
+}
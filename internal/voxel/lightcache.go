@@ -0,0 +1,288 @@
+package voxel
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/chewxy/math32"
+	te "github.com/zheskett/go-voxel/internal/tensor"
+)
+
+const (
+	// Edits are tracked in cubes of this many voxels per side
+	DirtyRegionSize = 8
+	// Light intensity below which a light is treated as not reaching a voxel.
+	// Shaded colors are intensity * [0, 255], so this is under half a color step
+	LightCutoff = 1.0 / 512.0
+	// Past this many dirty regions it's cheaper to just throw away the whole cache
+	maxDirtyRegions = 512
+)
+
+// Keeps track of what has been cached and what has changed since,
+// so the lighting cache only gets invalidated where it needs to be
+type lightCache struct {
+	lock   sync.Mutex
+	cached [][]int // Indices of voxels with cached lighting, by the region they're in
+
+	dirty                  BitArray
+	dirtyX, dirtyY, dirtyZ int
+	hasDirty               atomic.Bool
+
+	lights []LightSource // The lights the cache was computed with
+	edits  atomic.Uint64 // Total MarkDirty calls
+}
+
+func lightCacheInit(x, y, z int) *lightCache {
+	dx := (x + DirtyRegionSize - 1) / DirtyRegionSize
+	dy := (y + DirtyRegionSize - 1) / DirtyRegionSize
+	dz := (z + DirtyRegionSize - 1) / DirtyRegionSize
+	return &lightCache{cached: make([][]int, dx*dy*dz), dirty: BitArrayInit(dx * dy * dz), dirtyX: dx, dirtyY: dy, dirtyZ: dz}
+}
+
+// Index of the region holding the voxel, in the dirty bits and cached
+func (cache *lightCache) region(x, y, z int) int {
+	rx, ry, rz := x/DirtyRegionSize, y/DirtyRegionSize, z/DirtyRegionSize
+	return cache.dirtyX*cache.dirtyY*rz + cache.dirtyX*ry + rx
+}
+
+// Low corner of the region
+func (cache *lightCache) regionCorner(region int) te.Vector3 {
+	rz := region / (cache.dirtyX * cache.dirtyY)
+	ry := (region / cache.dirtyX) % cache.dirtyY
+	rx := region % cache.dirtyX
+	return te.Vec3(float32(rx), float32(ry), float32(rz)).Mul(DirtyRegionSize)
+}
+
+// Radius past which the light's contribution falls under LightCutoff
+func (light Light) Radius() float32 {
+	return math32.Sqrt(light.Color.Max() / LightCutoff)
+}
+
+// Marks the voxel as edited so cached lighting that could see it gets invalidated.
+// Like the edit itself it belongs between frames, see Journal.Submit
+func (vox *Voxels) MarkDirty(x, y, z int) {
+	cache := vox.lightCache
+	cache.dirty.Set(cache.region(x, y, z))
+	cache.hasDirty.Store(true)
+	cache.edits.Add(1)
+	if vox.propagation != nil {
+		vox.propagation.markDirty(x, y, z)
+	}
+}
+
+// Counts every edit made to the voxels, so renderers can tell when the world has changed
+func (vox *Voxels) Edits() uint64 {
+	return vox.lightCache.edits.Load()
+}
+
+// Stores the lighting for a voxel until it is invalidated by an edit or a light change.
+// Safe to call from multiple goroutines
+func (vox *Voxels) CacheLighting(idx int, light CachedLighting) {
+	cache := vox.lightCache
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	// Another goroutine may have got here first and readers could already be using its lighting
+	if vox.LightCached.Get(idx) {
+		return
+	}
+	vox.Lighting[idx] = light
+	vox.LightCached.Set(idx)
+	region := cache.region(idx%vox.X, (idx/vox.X)%vox.Y, idx/(vox.X*vox.Y))
+	cache.cached[region] = append(cache.cached[region], idx)
+}
+
+// Throws away all cached lighting
+func (vox *Voxels) ClearLightCache() {
+	cache := vox.lightCache
+	vox.LightCached.Clear()
+	for region := range cache.cached {
+		cache.cached[region] = cache.cached[region][:0]
+	}
+	cache.dirty.Clear()
+	cache.hasDirty.Store(false)
+	cache.lights = append(cache.lights[:0], vox.Lights...)
+}
+
+// Invalidates cached lighting affected by edits and light changes since the last update.
+// Should be called once per frame before rendering
+func (vox *Voxels) UpdateLightCache() {
+	cache := vox.lightCache
+
+	changed := changedLights(cache.lights, vox.Lights)
+	if !cache.hasDirty.Load() && len(changed) == 0 {
+		return
+	}
+
+	regions := vox.dirtyRegions()
+	if len(regions) > maxDirtyRegions {
+		vox.ClearLightCache()
+		return
+	}
+	// Lights as bright as the scenes' are still over LightCutoff across the whole world,
+	// so changing one invalidates everything and checking voxel by voxel is wasted
+	for _, light := range changed {
+		if vox.reachesEverywhere(light) {
+			vox.ClearLightCache()
+			return
+		}
+	}
+
+	// Regions of cached voxels are checked as a whole first, so only the voxels of the ones
+	// near an edit, or in one of its shadows, get checked one by one
+	near := [][2]te.Vector3{}
+	for region, cached := range cache.cached {
+		if len(cached) == 0 {
+			continue
+		}
+		near = vox.regionsNear(cache.regionCorner(region), regions, near[:0])
+		if len(near) == 0 && len(changed) == 0 {
+			continue
+		}
+		kept := cached[:0]
+		for _, idx := range cached {
+			z := idx / (vox.X * vox.Y)
+			y := (idx / vox.X) % vox.Y
+			x := idx % vox.X
+			center := te.Vec3(float32(x)+0.5, float32(y)+0.5, float32(z)+0.5)
+			if vox.lightingAffected(center, changed, near) {
+				vox.LightCached.Put(idx, false)
+			} else {
+				kept = append(kept, idx)
+			}
+		}
+		cache.cached[region] = kept
+	}
+	cache.dirty.Clear()
+	cache.hasDirty.Store(false)
+	cache.lights = append(cache.lights[:0], vox.Lights...)
+}
+
+// Low and high corners of each dirty region, grown by a voxel to cover shadow rays
+// that start from the corner of their voxel
+func (vox *Voxels) dirtyRegions() [][2]te.Vector3 {
+	cache := vox.lightCache
+	regions := [][2]te.Vector3{}
+	if !cache.hasDirty.Load() {
+		return regions
+	}
+	for bucket, bits := range cache.dirty.bits {
+		for bit := 0; bits != 0 && bit < 64; bit++ {
+			if bits&(1<<bit) == 0 {
+				continue
+			}
+			bits &^= 1 << bit
+			low := cache.regionCorner(bucket*64 + bit).Sub(te.Vec3Splat(1))
+			high := low.Add(te.Vec3Splat(DirtyRegionSize + 2))
+			regions = append(regions, [2]te.Vector3{low, high})
+			if len(regions) > maxDirtyRegions {
+				return regions
+			}
+		}
+	}
+	return regions
+}
+
+// Appends the dirty regions that could affect any voxel of the region with its low corner
+// at corner to near. Same checks as lightingAffected, made from the region's center with
+// the dirty regions grown to cover every voxel around it
+func (vox *Voxels) regionsNear(corner te.Vector3, regions, near [][2]te.Vector3) [][2]te.Vector3 {
+	const halfSize = DirtyRegionSize / 2
+	center := corner.Add(te.Vec3Splat(halfSize))
+	// Shadow rays from the region's voxels stay this close to the ones from its center
+	reach := halfSize * math32.Sqrt(3)
+	for _, region := range regions {
+		grow := te.Vec3Splat(reach)
+		if pointInBox(center, region[0].Sub(grow), region[1].Add(grow)) {
+			near = append(near, region)
+			continue
+		}
+		for _, light := range vox.Lights {
+			anchor, spread := light.Anchor(center)
+			grow := te.Vec3Splat(reach + spread)
+			if segmentHitsBox(center, anchor, region[0].Sub(grow), region[1].Add(grow)) {
+				near = append(near, region)
+				break
+			}
+		}
+	}
+	return near
+}
+
+// Whether a voxel's cached lighting could have changed, either from a changed
+// light reaching it or from a dirty region blocking or unblocking one of its shadow rays
+func (vox *Voxels) lightingAffected(center te.Vector3, changed []LightSource, regions [][2]te.Vector3) bool {
+	for _, light := range changed {
+		if light.Reaches(center) {
+			return true
+		}
+	}
+	for _, region := range regions {
+		if pointInBox(center, region[0], region[1]) {
+			return true
+		}
+		for _, light := range vox.Lights {
+			if !light.Reaches(center) {
+				continue
+			}
+			// Shadow rays to lights with an area fan out, grow the region to cover all of them
+			anchor, spread := light.Anchor(center)
+			grow := te.Vec3Splat(spread)
+			if segmentHitsBox(center, anchor, region[0].Sub(grow), region[1].Add(grow)) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Whether the light reaches every voxel of the world. Only lights that reach a ball
+// around them are checked, the ball covers the world if it covers all its corners
+func (vox *Voxels) reachesEverywhere(light LightSource) bool {
+	switch light.(type) {
+	case DirectionalLight:
+		return true
+	case Light, VoxelLight, SphereLight, RectLight:
+	default:
+		return false
+	}
+	for corner := range 8 {
+		x, y, z := float32(vox.X*(corner&1)), float32(vox.Y*(corner>>1&1)), float32(vox.Z*(corner>>2&1))
+		if !light.Reaches(te.Vec3(x, y, z)) {
+			return false
+		}
+	}
+	return true
+}
+
+func pointInBox(p, low, high te.Vector3) bool {
+	return p.X >= low.X && p.Y >= low.Y && p.Z >= low.Z && p.X <= high.X && p.Y <= high.Y && p.Z <= high.Z
+}
+
+// Slab test of the segment from a to b against a box
+func segmentHitsBox(a, b, low, high te.Vector3) bool {
+	dir := b.Sub(a)
+	tmin, tmax := float32(0.0), float32(1.0)
+	origin := [3]float32{a.X, a.Y, a.Z}
+	d := [3]float32{dir.X, dir.Y, dir.Z}
+	lo := [3]float32{low.X, low.Y, low.Z}
+	hi := [3]float32{high.X, high.Y, high.Z}
+	for i := range 3 {
+		if math32.Abs(d[i]) < 1e-9 {
+			if origin[i] < lo[i] || origin[i] > hi[i] {
+				return false
+			}
+			continue
+		}
+		inv := 1.0 / d[i]
+		t0, t1 := (lo[i]-origin[i])*inv, (hi[i]-origin[i])*inv
+		if t0 > t1 {
+			t0, t1 = t1, t0
+		}
+		tmin, tmax = max(tmin, t0), min(tmax, t1)
+		if tmin > tmax {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,94 @@
+package voxel
+
+import (
+	"slices"
+	"testing"
+
+	te "github.com/zheskett/go-voxel/internal/tensor"
+)
+
+// TestLightCacheInvalidation caches two voxels and expects an edit between one
+// of them and the light to only invalidate that voxel.
+func TestLightCacheInvalidation(t *testing.T) {
+	vox := VoxelsInit(64, 64, 64)
+	vox.Lights = append(vox.Lights, Light{Position: te.Vec3(10, 10, 10), Color: te.Vec3(1, 1, 1)})
+	vox.UpdateLightCache()
+
+	near, far := vox.Index(10, 0, 10), vox.Index(50, 0, 50)
+	vox.CacheLighting(near, CachedLighting{})
+	vox.CacheLighting(far, CachedLighting{})
+	vox.UpdateLightCache()
+	if !vox.LightCached.Get(near) || !vox.LightCached.Get(far) {
+		t.Fatalf("Expected cache to survive a frame without changes")
+	}
+
+	vox.SetVoxel(10, 5, 10, 255, 255, 255)
+	vox.UpdateLightCache()
+	if vox.LightCached.Get(near) {
+		t.Errorf("Expected voxel under the edit to be invalidated")
+	}
+	if !vox.LightCached.Get(far) {
+		t.Errorf("Expected voxel outside the light's radius to stay cached")
+	}
+
+	vox.Lights[0] = Light{Position: te.Vec3(50, 10, 50), Color: te.Vec3(1, 1, 1)}
+	vox.UpdateLightCache()
+	if vox.LightCached.Get(far) {
+		t.Errorf("Expected voxel near the moved light to be invalidated")
+	}
+}
+
+// TestLightCacheSceneBrightness uses lights as bright as the scenes', which reach the
+// whole world. Edits should still only invalidate voxels whose shadow rays they cross,
+// and changing one of the lights should throw the whole cache away.
+func TestLightCacheSceneBrightness(t *testing.T) {
+	vox := VoxelsInit(64, 64, 64)
+	vox.Lights = append(vox.Lights,
+		Light{Position: te.Vec3(32, 70, 32), Color: te.Vec3(1.0, 0.95, 0.95).Mul(10000)},
+		Light{Position: te.Vec3(10, 10, 10), Color: te.Vec3(1.0, 0.3, 0.3).Mul(2500)},
+	)
+	vox.UpdateLightCache()
+	for _, light := range vox.Lights {
+		if !vox.reachesEverywhere(light) {
+			t.Fatalf("Expected %v to reach the whole world", light)
+		}
+	}
+
+	near, far := vox.Index(10, 0, 10), vox.Index(50, 0, 50)
+	vox.CacheLighting(near, CachedLighting{})
+	vox.CacheLighting(far, CachedLighting{})
+	vox.SetVoxel(50, 3, 50, 255, 255, 255)
+	vox.UpdateLightCache()
+	if vox.LightCached.Get(far) {
+		t.Errorf("Expected voxel under the edit to be invalidated")
+	}
+	if !vox.LightCached.Get(near) {
+		t.Errorf("Expected voxel whose shadow rays miss the edit to stay cached")
+	}
+
+	vox.Lights[1] = Light{Position: te.Vec3(54, 10, 10), Color: te.Vec3(1.0, 0.3, 0.3).Mul(2500)}
+	vox.UpdateLightCache()
+	if vox.LightCached.Get(near) || slices.ContainsFunc(vox.lightCache.cached, func(cached []int) bool { return len(cached) != 0 }) {
+		t.Errorf("Expected moving a light that reaches everything to clear the cache")
+	}
+}
+
+// TestLightCacheShadowAcrossRegions expects an edit to invalidate a voxel in another
+// region whose shadow ray it crosses, and not its neighbour whose shadow ray misses it.
+func TestLightCacheShadowAcrossRegions(t *testing.T) {
+	vox := VoxelsInit(64, 64, 64)
+	vox.Lights = append(vox.Lights, Light{Position: te.Vec3(10.5, 40, 10.5), Color: te.Vec3(4, 4, 4)})
+	vox.UpdateLightCache()
+
+	under, beside := vox.Index(10, 0, 10), vox.Index(30, 0, 10)
+	vox.CacheLighting(under, CachedLighting{})
+	vox.CacheLighting(beside, CachedLighting{})
+	vox.SetVoxel(10, 20, 10, 255, 255, 255)
+	vox.UpdateLightCache()
+	if vox.LightCached.Get(under) {
+		t.Errorf("Expected the voxel the edit shadows to be invalidated")
+	}
+	if !vox.LightCached.Get(beside) {
+		t.Errorf("Expected the voxel whose shadow ray misses the edit to stay cached")
+	}
+}
@@ -0,0 +1,226 @@
+package voxel
+
+import (
+	"reflect"
+	"slices"
+
+	"github.com/chewxy/math32"
+	te "github.com/zheskett/go-voxel/internal/tensor"
+)
+
+const (
+	// How far away directional lights are treated as being, for shadow rays and the lighting cache
+	DirectionalLightDistance = 4096
+)
+
+// LightSource is anything that lights the world. Caches tell when one has changed
+// with SameLights, so implementations don't need to be comparable
+type LightSource interface {
+	// Picks a point on the light to shade pos with. u and v in [0, 1) choose the
+	// point on lights with an area, the rest ignore them
+	Sample(pos te.Vector3, u, v float32) LightSample
+	// Number of shadow samples needed for the light's shadows to look right, 1 for hard shadows
+	Samples() int
+	// Whether the light can reach pos at all, bright enough to matter
+	Reaches(pos te.Vector3) bool
+	// Point shadow rays from pos head towards, and how far from it the sampled points can be
+	Anchor(pos te.Vector3) (te.Vector3, float32)
+}
+
+// Whether the lists hold the same lights in the same order. Compares them by value,
+// but without ==, which panics on implementations holding slices or maps
+func SameLights(a, b []LightSource) bool {
+	return slices.EqualFunc(a, b, sameLight)
+}
+
+func sameLight(a, b LightSource) bool {
+	return reflect.DeepEqual(a, b)
+}
+
+// LightSample is light arriving at a point from one point on a light
+type LightSample struct {
+	Dir      te.Vector3 // Normalized, from the shaded point towards the light
+	Dist     float32    // Distance to the point on the light, +Inf for directional lights
+	Radiance te.Vector3 // Light arriving at the shaded point, before the surface's cosine term
+}
+
+func (light Light) Sample(pos te.Vector3, _, _ float32) LightSample {
+	return pointSample(pos, light.Position, light.Color)
+}
+
+func (light Light) Samples() int {
+	return 1
+}
+
+func (light Light) Reaches(pos te.Vector3) bool {
+	radius := light.Radius()
+	return light.Position.Sub(pos).LenSqr() < radius*radius
+}
+
+func (light Light) Anchor(pos te.Vector3) (te.Vector3, float32) {
+	return light.Position, 0
+}
+
+// A light infinitely far away, like the sun. Doesn't fall off with distance
+type DirectionalLight struct {
+	Direction te.Vector3 // Direction the light travels in, doesn't need to be normalized
+	Color     te.Vector3
+}
+
+func (light DirectionalLight) Sample(pos te.Vector3, _, _ float32) LightSample {
+	return LightSample{Dir: light.Direction.Normalized().Neg(), Dist: math32.Inf(1), Radiance: light.Color}
+}
+
+func (light DirectionalLight) Samples() int {
+	return 1
+}
+
+func (light DirectionalLight) Reaches(pos te.Vector3) bool {
+	return true
+}
+
+func (light DirectionalLight) Anchor(pos te.Vector3) (te.Vector3, float32) {
+	return pos.Sub(light.Direction.Normalized().Mul(DirectionalLightDistance)), 0
+}
+
+// A point light that only shines in a cone
+type SpotLight struct {
+	Position  te.Vector3
+	Direction te.Vector3 // Center of the cone, doesn't need to be normalized
+	Color     te.Vector3
+	Angle     float32 // Half angle of the cone in radians
+	Softness  float32 // Fraction of the cone, from the edge in, that fades out. 0 is a hard edge
+}
+
+func (light SpotLight) Sample(pos te.Vector3, _, _ float32) LightSample {
+	sample := pointSample(pos, light.Position, light.Color)
+	sample.Radiance = sample.Radiance.Mul(light.cone(sample.Dir))
+	return sample
+}
+
+// How much of the light goes out along -dir, 1 inside the cone and 0 outside
+func (light SpotLight) cone(dir te.Vector3) float32 {
+	outer := math32.Cos(light.Angle)
+	inner := math32.Cos(light.Angle * (1 - math32.Min(math32.Max(light.Softness, 0), 1)))
+	cosine := light.Direction.Normalized().Dot(dir.Neg())
+	if inner <= outer {
+		if cosine >= outer {
+			return 1
+		}
+		return 0
+	}
+	return te.Smoothstep(outer, inner, cosine)
+}
+
+func (light SpotLight) Samples() int {
+	return 1
+}
+
+func (light SpotLight) Reaches(pos te.Vector3) bool {
+	toPos := pos.Sub(light.Position)
+	radius := Light{Color: light.Color}.Radius()
+	if toPos.LenSqr() >= radius*radius {
+		return false
+	}
+	return toPos.LenSqr() == 0 || light.cone(toPos.Normalized().Neg()) > 0
+}
+
+func (light SpotLight) Anchor(pos te.Vector3) (te.Vector3, float32) {
+	return light.Position, 0
+}
+
+// A flat rectangle of light, shining from both sides. Brightest seen face on and
+// as bright as a point light of the same color there
+type RectLight struct {
+	Position te.Vector3 // Center
+	U, V     te.Vector3 // Half of each edge, so the corners are Position +- U +- V
+	Color    te.Vector3
+	Count    int // Shadow samples per shaded point, at least 1
+}
+
+func (light RectLight) Sample(pos te.Vector3, u, v float32) LightSample {
+	point := light.Position.Add(light.U.Mul(2*u - 1)).Add(light.V.Mul(2*v - 1))
+	sample := pointSample(pos, point, light.Color)
+	normal := light.U.Cross(light.V).NormalizedOrZero()
+	sample.Radiance = sample.Radiance.Mul(math32.Abs(normal.Dot(sample.Dir)))
+	return sample
+}
+
+func (light RectLight) Samples() int {
+	return max(light.Count, 1)
+}
+
+func (light RectLight) Reaches(pos te.Vector3) bool {
+	radius := Light{Color: light.Color}.Radius() + light.U.Len() + light.V.Len()
+	return light.Position.Sub(pos).LenSqr() < radius*radius
+}
+
+func (light RectLight) Anchor(pos te.Vector3) (te.Vector3, float32) {
+	return light.Position, light.U.Len() + light.V.Len()
+}
+
+// A glowing ball, as bright as a point light of the same color at its center
+type SphereLight struct {
+	Position te.Vector3
+	Radius   float32
+	Color    te.Vector3
+	Count    int // Shadow samples per shaded point, at least 1
+}
+
+func (light SphereLight) Sample(pos te.Vector3, u, v float32) LightSample {
+	// From pos the ball looks like a disk facing it, pick a point on that
+	a, b := light.Position.Sub(pos).NormalizedOrZero().Perpendiculars()
+	r, theta := light.Radius*math32.Sqrt(u), 2*math32.Pi*v
+	point := light.Position.Add(a.Mul(r * math32.Cos(theta))).Add(b.Mul(r * math32.Sin(theta)))
+	return pointSample(pos, point, light.Color)
+}
+
+func (light SphereLight) Samples() int {
+	return max(light.Count, 1)
+}
+
+func (light SphereLight) Reaches(pos te.Vector3) bool {
+	radius := Light{Color: light.Color}.Radius() + light.Radius
+	return light.Position.Sub(pos).LenSqr() < radius*radius
+}
+
+func (light SphereLight) Anchor(pos te.Vector3) (te.Vector3, float32) {
+	return light.Position, light.Radius
+}
+
+// A point light in the middle of a voxel, for emissive voxels that light the world.
+// Shadow rays stop at the voxel's surface so the voxel doesn't shade its own light
+type VoxelLight struct {
+	Position te.Vector3 // Center of the voxel
+	Color    te.Vector3
+}
+
+// Farthest the surface of a voxel is from its center, plus a little
+const voxelLightInset = 0.8660254 + VoxelRayDelta
+
+func (light VoxelLight) Sample(pos te.Vector3, _, _ float32) LightSample {
+	sample := pointSample(pos, light.Position, light.Color)
+	sample.Dist = max(sample.Dist-voxelLightInset, 0)
+	return sample
+}
+
+func (light VoxelLight) Samples() int {
+	return 1
+}
+
+func (light VoxelLight) Reaches(pos te.Vector3) bool {
+	return Light{Position: light.Position, Color: light.Color}.Reaches(pos)
+}
+
+func (light VoxelLight) Anchor(pos te.Vector3) (te.Vector3, float32) {
+	return light.Position, 0
+}
+
+func pointSample(pos, point, color te.Vector3) LightSample {
+	offset := point.Sub(pos)
+	dist := offset.Len()
+	if dist == 0 {
+		return LightSample{Dir: te.Vec3Y(), Dist: 0, Radiance: te.Vec3Zero()}
+	}
+	return LightSample{Dir: offset.Div(dist), Dist: dist, Radiance: color.Div(dist * dist)}
+}
@@ -0,0 +1,85 @@
+package voxel
+
+import (
+	"testing"
+
+	"github.com/chewxy/math32"
+	te "github.com/zheskett/go-voxel/internal/tensor"
+)
+
+// TestSpotLightCone expects a spot light to light points inside its cone and
+// nothing outside of it.
+func TestSpotLightCone(t *testing.T) {
+	spot := SpotLight{
+		Position:  te.Vec3(0, 10, 0),
+		Direction: te.Vec3(0, -1, 0),
+		Color:     te.Vec3Splat(100),
+		Angle:     math32.Pi / 6,
+		Softness:  0.2,
+	}
+	if got := spot.Sample(te.Vec3(0, 0, 0), 0, 0); !closeTo(got.Radiance.X, 1) {
+		t.Errorf("Expected full brightness under the light, got %v", got.Radiance)
+	}
+	if got := spot.Sample(te.Vec3(10, 0, 0), 0, 0); got.Radiance.Max() != 0 {
+		t.Errorf("Expected no light outside the cone, got %v", got.Radiance)
+	}
+	if spot.Reaches(te.Vec3(0, 20, 0)) || !spot.Reaches(te.Vec3(1, 0, 0)) {
+		t.Errorf("Expected Reaches to follow the cone")
+	}
+}
+
+// TestDirectionalLight expects the same light everywhere, coming from against its direction.
+func TestDirectionalLight(t *testing.T) {
+	sun := DirectionalLight{Direction: te.Vec3(0, -2, 0), Color: te.Vec3Splat(0.5)}
+	for _, pos := range []te.Vector3{te.Vec3(0, 0, 0), te.Vec3(100, -50, 3)} {
+		got := sun.Sample(pos, 0, 0)
+		if got.Dir != te.Vec3(0, 1, 0) || got.Radiance != te.Vec3Splat(0.5) || !math32.IsInf(got.Dist, 1) {
+			t.Errorf("Unexpected sample at %v: %v", pos, got)
+		}
+	}
+}
+
+// TestAreaLightSamples expects samples to land on the light's surface.
+func TestAreaLightSamples(t *testing.T) {
+	rect := RectLight{Position: te.Vec3(0, 10, 0), U: te.Vec3(2, 0, 0), V: te.Vec3(0, 0, 1), Color: te.Vec3Splat(100)}
+	sphere := SphereLight{Position: te.Vec3(0, 10, 0), Radius: 2, Color: te.Vec3Splat(100)}
+	for _, uv := range [][2]float32{{0, 0}, {0.99, 0.99}, {0.25, 0.75}} {
+		s := rect.Sample(te.Vec3Zero(), uv[0], uv[1])
+		point := s.Dir.Mul(s.Dist)
+		if !closeTo(point.Y, 10) || math32.Abs(point.X) > 2 || math32.Abs(point.Z) > 1 {
+			t.Errorf("Rect sample %v landed off the light at %v", uv, point)
+		}
+		s = sphere.Sample(te.Vec3Zero(), uv[0], uv[1])
+		if point := s.Dir.Mul(s.Dist); point.Sub(sphere.Position).Len() > 2+1e-3 {
+			t.Errorf("Sphere sample %v landed off the light at %v", uv, point)
+		}
+	}
+
+	// Edge on, a flat light gives nothing
+	if got := rect.Sample(te.Vec3(20, 10, 0), 0.5, 0.5); got.Radiance.Max() > 1e-6 {
+		t.Errorf("Expected no light edge on, got %v", got.Radiance)
+	}
+}
+
+// A light that can't be compared with ==
+type pointsLight struct {
+	Light
+	Points []te.Vector3
+}
+
+// TestSameLights expects lights to be compared by value, without panicking on ones
+// that can't be compared with ==.
+func TestSameLights(t *testing.T) {
+	before := []LightSource{Light{Color: te.Vec3Splat(1)}, pointsLight{Points: []te.Vector3{te.Vec3X()}}}
+	after := []LightSource{Light{Color: te.Vec3Splat(1)}, pointsLight{Points: []te.Vector3{te.Vec3X()}}}
+	if !SameLights(before, after) {
+		t.Errorf("Expected equal lights to be the same")
+	}
+	after[1] = pointsLight{Points: []te.Vector3{te.Vec3Y()}}
+	if SameLights(before, after) {
+		t.Errorf("Expected a moved point to make the lights differ")
+	}
+	if changed := changedLights(before, after); len(changed) != 2 {
+		t.Errorf("Expected the light before and after the change, got %v", changed)
+	}
+}
@@ -0,0 +1,183 @@
+package voxel
+
+import (
+	"fmt"
+
+	"github.com/chewxy/math32"
+	te "github.com/zheskett/go-voxel/internal/tensor"
+)
+
+// Identifies how a voxel is shaded, an index into its world's Materials
+type MaterialID uint8
+
+// The materials every world starts with. Worlds can add more with AddMaterial
+const (
+	MaterialDiffuse  MaterialID = iota // Plain opaque voxel, what every voxel is by default
+	MaterialEmissive                   // Glows with its own color
+	MaterialGlass                      // Clear glass, tinted by the voxel's color
+	MaterialMetal                      // Slightly rough metal, tinted by the voxel's color
+	builtinMaterials
+)
+
+type MaterialKind uint8
+
+const (
+	KindDiffuse  MaterialKind = iota
+	KindEmissive              // Diffuse, plus light of its own
+	KindGlass                 // Refracts and reflects, and tints the light passing through it
+	KindMetal                 // Reflects, tinted by the voxel's color
+)
+
+// Material is how light interacts with a voxel. Colors come from the voxel itself,
+// so one material covers voxels of every color
+type Material struct {
+	Kind MaterialKind
+	// Emissive: light given off, relative to the voxel's color
+	Emission float32
+	// Emissive: also lights the world through EmissiveLights, instead of only glowing
+	Light bool
+	// Glass and metal: blurs reflections and refractions, 0 is a perfect mirror
+	Roughness float32
+	// Glass: index of refraction, 1 doesn't bend light at all
+	IOR float32
+	// Glass: how strongly it tints light per voxel crossed. At 1 light is the voxel's color after one voxel
+	Density float32
+}
+
+func defaultMaterials() []Material {
+	return []Material{
+		MaterialDiffuse:  {Kind: KindDiffuse},
+		MaterialEmissive: {Kind: KindEmissive, Emission: 4},
+		MaterialGlass:    {Kind: KindGlass, IOR: 1.5, Density: 0.5},
+		MaterialMetal:    {Kind: KindMetal, Roughness: 0.1},
+	}
+}
+
+// Whether light can pass through the material
+func (mat Material) Transparent() bool {
+	return mat.Kind == KindGlass
+}
+
+// Fraction of light left of each color after crossing dist of a voxel with this material
+func (mat Material) Absorb(color [3]byte, dist float32) te.Vector3 {
+	if mat.Density <= 0 || dist <= 0 {
+		return te.Vec3Splat(1)
+	}
+	power := mat.Density * dist
+	return te.Vec3(
+		math32.Pow(te.SRGBByteToLinear(color[0]), power),
+		math32.Pow(te.SRGBByteToLinear(color[1]), power),
+		math32.Pow(te.SRGBByteToLinear(color[2]), power),
+	)
+}
+
+// Returns the material of the voxel at idx
+func (vox *Voxels) Material(idx int) MaterialID {
+	return vox.materials[idx]
+}
+
+// Sets the material of the voxel, which is reset back to MaterialDiffuse when the voxel is removed
+func (vox *Voxels) SetMaterial(x, y, z int, material MaterialID) {
+	idx := vox.Index(x, y, z)
+	if material == MaterialDiffuse {
+		delete(vox.materials, idx)
+	} else {
+		vox.materials[idx] = material
+	}
+	vox.MarkDirty(x, y, z)
+}
+
+// Properties of a material, MaterialDiffuse's for ids past the end of Materials
+func (vox *Voxels) MaterialProps(id MaterialID) Material {
+	if int(id) < len(vox.Materials) {
+		return vox.Materials[id]
+	}
+	return vox.Materials[MaterialDiffuse]
+}
+
+// Finds the material in Materials, adding it if it isn't there yet.
+// Errors once all 256 ids are taken
+func (vox *Voxels) AddMaterial(mat Material) (MaterialID, error) {
+	for id, existing := range vox.Materials {
+		if existing == mat {
+			return MaterialID(id), nil
+		}
+	}
+	if len(vox.Materials) > 255 {
+		return MaterialDiffuse, fmt.Errorf("Too many materials")
+	}
+	vox.Materials = append(vox.Materials, mat)
+	return MaterialID(len(vox.Materials) - 1), nil
+}
+
+// Light along the ray that makes it to Tmax, tinted by the transparent voxels it
+// passes through. Zero when an opaque voxel blocks it.
+// Same as Occluded for worlds without transparent materials
+func (vox *Voxels) Transmittance(ray Ray) te.Vector3 {
+	through := te.Vec3Splat(1)
+	for {
+		hit := vox.marchRay(ray, true)
+		if !hit.Hit {
+			return through
+		}
+		mat := vox.MaterialProps(vox.Material(hit.Index))
+		if !mat.Transparent() {
+			return te.Vec3Zero()
+		}
+
+		// Light is tinted over the length of the ray inside the voxel, without bending
+		low := te.Vec3(float32(hit.IntPos[0]), float32(hit.IntPos[1]), float32(hit.IntPos[2]))
+		enter, exit := rayBoxTimes(ray.Origin, ray.Dir, low, low.Add(te.Vec3Splat(1)))
+		through = through.MulComponent(mat.Absorb(vox.Color[hit.Index], exit-max(enter, 0)))
+		if through.Max() < LightCutoff {
+			return te.Vec3Zero()
+		}
+
+		exit += VoxelRayDelta
+		if exit >= ray.Tmax {
+			return through
+		}
+		ray.Origin = ray.Origin.Add(ray.Dir.Mul(exit))
+		ray.Tmax -= exit
+		// The nudged origin can be inside the next voxel, which should still count
+		ray.ReportStart = true
+	}
+}
+
+// Times the ray enters and leaves a box it's known to touch
+func rayBoxTimes(origin, dir, low, high te.Vector3) (float32, float32) {
+	o := [3]float32{origin.X, origin.Y, origin.Z}
+	d := [3]float32{dir.X, dir.Y, dir.Z}
+	lo := [3]float32{low.X, low.Y, low.Z}
+	hi := [3]float32{high.X, high.Y, high.Z}
+	enter, exit := math32.Inf(-1), math32.Inf(1)
+	for i := range 3 {
+		if math32.Abs(d[i]) < 1e-9 {
+			continue
+		}
+		t0, t1 := (lo[i]-o[i])/d[i], (hi[i]-o[i])/d[i]
+		enter, exit = max(enter, min(t0, t1)), min(exit, max(t0, t1))
+	}
+	return enter, exit
+}
+
+// A light for every emissive voxel whose material has Light set, to add to Lights.
+// They're saved with the rest of the lights, so only add them once
+func (vox *Voxels) EmissiveLights() []LightSource {
+	lights := []LightSource{}
+	for idx, id := range vox.materials {
+		mat := vox.MaterialProps(id)
+		if mat.Kind != KindEmissive || !mat.Light || !vox.Presence.Get(idx) {
+			continue
+		}
+		z := idx / (vox.X * vox.Y)
+		y := (idx / vox.X) % vox.Y
+		x := idx % vox.X
+		color := vox.Color[idx]
+		lights = append(lights, VoxelLight{
+			Position: te.Vec3(float32(x)+0.5, float32(y)+0.5, float32(z)+0.5),
+			Color:    te.SRGBColorToLinear(color).Mul(mat.Emission),
+		})
+	}
+	return lights
+}
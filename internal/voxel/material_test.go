@@ -0,0 +1,93 @@
+package voxel
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/chewxy/math32"
+	te "github.com/zheskett/go-voxel/internal/tensor"
+)
+
+// TestTransmittance expects light through glass to be tinted by its color and
+// dimmed the more of it there is, and blocked by anything opaque.
+func TestTransmittance(t *testing.T) {
+	vox := VoxelsInit(16, 16, 16)
+	vox.SetVoxel(4, 8, 8, 255, 64, 64)
+	vox.SetMaterial(4, 8, 8, MaterialGlass)
+	ray := Ray{Origin: te.Vec3(0.5, 8.5, 8.5), Dir: te.Vec3(1, 0, 0), Tmax: 14}
+
+	one := vox.Transmittance(ray)
+	if one.X != 1 || one.Y <= 0 || one.Y >= 1 || one.Y != one.Z {
+		t.Errorf("Expected red light through one glass voxel, got %v", one)
+	}
+	vox.SetVoxel(5, 8, 8, 255, 64, 64)
+	vox.SetMaterial(5, 8, 8, MaterialGlass)
+	if two := vox.Transmittance(ray); math32.Abs(two.Y-one.Y*one.Y) > 0.01 {
+		t.Errorf("Expected two voxels to tint twice as much, got %v after %v", two, one)
+	}
+
+	vox.SetVoxel(10, 8, 8, 255, 255, 255)
+	if got := vox.Transmittance(ray); got.Max() != 0 {
+		t.Errorf("Expected an opaque voxel to block the light, got %v", got)
+	}
+	if got := vox.Transmittance(Ray{Origin: ray.Origin, Dir: ray.Dir, Tmax: 9}); got.X != 1 {
+		t.Errorf("Expected light stopping short of the opaque voxel to get through, got %v", got)
+	}
+}
+
+// TestMediumRayLeavesGlass expects a ray marching through glass to stop where the glass ends.
+func TestMediumRayLeavesGlass(t *testing.T) {
+	vox := VoxelsInit(16, 16, 16)
+	for x := 4; x < 7; x++ {
+		vox.SetVoxel(x, 8, 8, 200, 200, 255)
+		vox.SetMaterial(x, 8, 8, MaterialGlass)
+	}
+	hit := vox.MarchRay(Ray{Origin: te.Vec3(4.001, 8.5, 8.5), Dir: te.Vec3(1, 0, 0), Tmax: 14, ReportStart: true, Medium: MaterialGlass})
+	if !hit.Hit || hit.IntPos != [3]int{7, 8, 8} || !closeTo(hit.Position.X, 7) {
+		t.Errorf("Expected to leave the glass at x 7, got %+v", hit)
+	}
+	if hit.Normal != te.Vec3(-1, 0, 0) {
+		t.Errorf("Expected the normal to face back into the glass, got %v", hit.Normal)
+	}
+}
+
+// TestMaterialsRoundTrip expects added materials and emissive voxel lights to survive saving.
+func TestMaterialsRoundTrip(t *testing.T) {
+	vox := VoxelsInit(8, 8, 8)
+	lamp, err := vox.AddMaterial(Material{Kind: KindEmissive, Emission: 2, Light: true})
+	if err != nil {
+		t.Fatalf("AddMaterial: %v", err)
+	}
+	if again, _ := vox.AddMaterial(vox.Materials[lamp]); again != lamp {
+		t.Errorf("Expected the same material to get the same id, got %v and %v", lamp, again)
+	}
+	vox.SetVoxel(3, 3, 3, 255, 0, 0)
+	vox.SetMaterial(3, 3, 3, lamp)
+	vox.SetVoxel(5, 3, 3, 255, 0, 0)
+	vox.SetMaterial(5, 3, 3, MaterialEmissive)
+
+	lights := vox.EmissiveLights()
+	if len(lights) != 1 {
+		t.Fatalf("Expected one light for the emissive voxel with Light set, got %v", lights)
+	}
+	want := VoxelLight{Position: te.Vec3(3.5, 3.5, 3.5), Color: te.Vec3(2, 0, 0)}
+	if lights[0] != want {
+		t.Errorf("Expected %v, got %v", want, lights[0])
+	}
+	vox.Lights = append(vox.Lights, lights...)
+
+	buf := bytes.Buffer{}
+	if err := vox.Save(&buf); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	loaded, err := Load(&buf)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(loaded.Materials) != len(vox.Materials) || loaded.Materials[lamp] != vox.Materials[lamp] {
+		t.Errorf("Expected materials %v, got %v", vox.Materials, loaded.Materials)
+	}
+	if len(loaded.Lights) != 1 || loaded.Lights[0] != want {
+		t.Errorf("Expected the voxel light to survive, got %v", loaded.Lights)
+	}
+}
@@ -17,6 +17,8 @@ type VoxelObj struct {
 	// Voxels is a map of [x, y, z] to the color index
 	Voxels      map[[3]int16]byte
 	ColorPalete voxparse.VoxPalette
+	// Materials of the color indices that aren't diffuse, can be nil
+	Materials map[byte]Material
 }
 
 type ConnectivityDistance int
@@ -82,10 +84,49 @@ func ConvertVox(vox voxparse.Vox, flipX, flipY, flipZ bool) (VoxelObj, error) {
 	}
 
 	vObj.ColorPalete = vox.Palette
+	vObj.Materials = convertVoxMaterials(vox)
 
 	return vObj, nil
 }
 
+// Picks the closest Material for every palette index with a MATL material, or
+// with palette alpha under 255, which MagicaVoxel draws as see through
+func convertVoxMaterials(vox voxparse.Vox) map[byte]Material {
+	materials := make(map[byte]Material)
+	if !vox.PaletteIsDefault {
+		for i, color := range vox.Palette {
+			if i > 0 && i < 256 && color.A < 0xff {
+				materials[byte(i)] = Material{Kind: KindGlass, IOR: 1, Density: float32(color.A) / 0xff}
+			}
+		}
+	}
+	for id, voxMat := range vox.Materials {
+		if id < 1 || id > 255 {
+			continue
+		}
+		float := func(key string, fallback float64) float32 { return float32(voxMat.Float(key, fallback)) }
+		// Transparency is in _alpha in older files and _trans in newer ones
+		density := 1 - float("_trans", float64(float("_alpha", 0.5)))
+		switch voxMat.Type {
+		case "_metal":
+			materials[byte(id)] = Material{Kind: KindMetal, Roughness: float("_rough", 0.1)}
+		case "_glass":
+			// MagicaVoxel stores the index of refraction minus 1
+			ior := float("_ior", 0.5)
+			if ior < 1 {
+				ior++
+			}
+			materials[byte(id)] = Material{Kind: KindGlass, Roughness: float("_rough", 0), IOR: ior, Density: density}
+		case "_blend", "_media":
+			materials[byte(id)] = Material{Kind: KindGlass, IOR: 1, Density: density}
+		case "_emit":
+			// _flux is a power of the emission's brightness
+			materials[byte(id)] = Material{Kind: KindEmissive, Emission: 4 * float("_emit", 1) * (1 + float("_flux", 0))}
+		}
+	}
+	return materials
+}
+
 // Same as Voxelize(ParseObj(path), ...) basically
 func VoxelizePath(path string, flipX, flipY, flipZ bool, cd ConnectivityDistance,
 	resolution int, color [3]byte) (VoxelObj, error) {
@@ -122,7 +163,7 @@ func Voxelize(obj parser.Obj, cd ConnectivityDistance, resolution int, color [3]
 	Z := int16(math32.Ceil(float32(resolution) * obj.MaxVertsPos.Z))
 
 	imgColor := clr.RGBA{color[0], color[1], color[2], 0xff}
-	vObj := VoxelObj{X, Y, Z, make(map[[3]int16]byte), voxparse.VoxPalette{clr.RGBA{0, 0, 0, 0}, imgColor}}
+	vObj := VoxelObj{X, Y, Z, make(map[[3]int16]byte), voxparse.VoxPalette{clr.RGBA{0, 0, 0, 0}, imgColor}, nil}
 	setChan := make(chan [][3]int16, setChanSize)
 
 	var wg sync.WaitGroup
@@ -0,0 +1,406 @@
+package voxel
+
+import (
+	"sync"
+
+	"github.com/chewxy/math32"
+	te "github.com/zheskett/go-voxel/internal/tensor"
+)
+
+const (
+	// Light propagation stores light per cube of this many voxels per side
+	PropagationCellSize = 4
+)
+
+// LightPropagation is a cheap stand in for global illumination. Surfaces lit by the
+// lights bounce some of it back out, tinted by their color, and that light floods
+// through the open cells around them, getting dimmer with every cell it crosses.
+//
+// Light is stored per cell of PropagationCellSize voxels, so walls thinner than a
+// cell can leak some of it. Enable it with Voxels.EnablePropagation
+type LightPropagation struct {
+	Attenuation float32 // Fraction of light kept per cell it floods through, below 1
+	Bounce      float32 // Fraction of the direct light surfaces send back out, before their color
+
+	X, Y, Z int // Size in cells
+
+	light  []te.Vector3 // Flooded light, what shading reads
+	inject []te.Vector3 // Light bounced into each cell by the surfaces in and around it
+	albedo []te.Vector3 // Summed linear color of the filled voxels in each cell
+	solid  []int        // Filled voxels in each cell
+	full   BitArray     // Cells with every voxel filled, which light can't flood through
+
+	dirty      BitArray // Cells edited since the last update
+	dirtyCells []int
+	lights     []LightSource // The lights the injection was computed with
+	maxInject  float32
+}
+
+func lightPropagationInit(x, y, z int) *LightPropagation {
+	cx := (x + PropagationCellSize - 1) / PropagationCellSize
+	cy := (y + PropagationCellSize - 1) / PropagationCellSize
+	cz := (z + PropagationCellSize - 1) / PropagationCellSize
+	cells := cx * cy * cz
+	return &LightPropagation{
+		Attenuation: 0.75,
+		Bounce:      0.5,
+		X:           cx,
+		Y:           cy,
+		Z:           cz,
+		light:       make([]te.Vector3, cells),
+		inject:      make([]te.Vector3, cells),
+		albedo:      make([]te.Vector3, cells),
+		solid:       make([]int, cells),
+		full:        BitArrayInit(cells),
+		dirty:       BitArrayInit(cells),
+	}
+}
+
+// Starts flooding bounce light through the world, see LightPropagation.
+// Builds it from scratch, so it's slow on big worlds
+func (vox *Voxels) EnablePropagation() {
+	vox.propagation = lightPropagationInit(vox.X, vox.Y, vox.Z)
+	vox.RebuildPropagation()
+}
+
+// Throws the propagated light away, IndirectLight is zero afterwards
+func (vox *Voxels) DisablePropagation() {
+	vox.propagation = nil
+}
+
+// The light propagation, nil when it isn't enabled. Rebuild after changing its settings
+func (vox *Voxels) Propagation() *LightPropagation {
+	return vox.propagation
+}
+
+// Propagated light at pos, zero when propagation isn't enabled.
+// Safe to call from multiple goroutines, like the other reads
+func (vox *Voxels) IndirectLight(pos te.Vector3) te.Vector3 {
+	p := vox.propagation
+	if p == nil {
+		return te.Vec3Zero()
+	}
+
+	// Trilinear between the centers of the cells around pos, leaving out full
+	// cells so walls don't darken the light in front of them
+	f := pos.Div(PropagationCellSize).Sub(te.Vec3Splat(0.5))
+	x0, y0, z0 := int(math32.Floor(f.X)), int(math32.Floor(f.Y)), int(math32.Floor(f.Z))
+	fx, fy, fz := f.X-float32(x0), f.Y-float32(y0), f.Z-float32(z0)
+	sum := te.Vec3Zero()
+	weight := float32(0.0)
+	for i := range 8 {
+		cx, cy, cz := x0+i&1, y0+(i>>1)&1, z0+(i>>2)&1
+		if !p.surrounds(cx, cy, cz) {
+			continue
+		}
+		c := p.index(cx, cy, cz)
+		if p.full.Get(c) {
+			continue
+		}
+		w := lerpWeight(fx, i&1) * lerpWeight(fy, (i>>1)&1) * lerpWeight(fz, (i>>2)&1)
+		sum = sum.Add(p.light[c].Mul(w))
+		weight += w
+	}
+	if weight <= 0 {
+		return te.Vec3Zero()
+	}
+	return sum.Div(weight)
+}
+
+func lerpWeight(f float32, side int) float32 {
+	if side == 0 {
+		return 1 - f
+	}
+	return f
+}
+
+// Recomputes all of the propagated light
+func (vox *Voxels) RebuildPropagation() {
+	p := vox.propagation
+	if p == nil {
+		return
+	}
+	for c := range p.light {
+		p.updateCell(vox, c)
+	}
+	cells := []int{}
+	for c := range p.inject {
+		p.inject[c] = te.Vec3Zero()
+		if p.nearSurface(c) {
+			cells = append(cells, c)
+		}
+	}
+	p.maxInject = 0
+	for i, light := range p.computeInjection(vox, cells) {
+		p.inject[cells[i]] = light
+		p.maxInject = math32.Max(p.maxInject, light.Max())
+	}
+	p.dirty.Clear()
+	p.dirtyCells = p.dirtyCells[:0]
+	p.lights = append(p.lights[:0], vox.Lights...)
+	p.reflood(nil, true)
+}
+
+// Updates the propagated light for edits and light changes since the last update,
+// only reflooding around what changed. Belongs between frames, like UpdateLightCache
+func (vox *Voxels) UpdatePropagation() {
+	p := vox.propagation
+	if p == nil {
+		return
+	}
+	changed := changedLights(p.lights, vox.Lights)
+	if len(p.dirtyCells) == 0 && len(changed) == 0 {
+		return
+	}
+
+	// Grown by a whole cell so the neighbors, whose injection uses the edited cell's
+	// voxels, count as inside
+	regions := make([][2]te.Vector3, 0, len(p.dirtyCells))
+	for _, c := range p.dirtyCells {
+		p.updateCell(vox, c)
+		low := p.cellLow(c).Sub(te.Vec3Splat(PropagationCellSize))
+		regions = append(regions, [2]te.Vector3{low, low.Add(te.Vec3Splat(3 * PropagationCellSize))})
+	}
+	if len(regions) > maxDirtyRegions {
+		vox.RebuildPropagation()
+		return
+	}
+
+	cells := []int{}
+	for c := range p.inject {
+		if (p.nearSurface(c) || p.inject[c].Max() > 0) && vox.lightingAffected(p.cellCenter(c), changed, regions) {
+			cells = append(cells, c)
+		}
+	}
+	seeds := append([]int{}, p.dirtyCells...)
+	for i, light := range p.computeInjection(vox, cells) {
+		if c := cells[i]; light != p.inject[c] {
+			p.inject[c] = light
+			p.maxInject = math32.Max(p.maxInject, light.Max())
+			seeds = append(seeds, c)
+		}
+	}
+	for _, c := range p.dirtyCells {
+		p.dirty.Reset(c)
+	}
+	p.dirtyCells = p.dirtyCells[:0]
+	p.lights = append(p.lights[:0], vox.Lights...)
+	p.reflood(seeds, false)
+}
+
+// Lights that moved, changed color, were added or were removed between two lists
+func changedLights(before, after []LightSource) []LightSource {
+	changed := []LightSource{}
+	for i := range max(len(before), len(after)) {
+		switch {
+		case i >= len(before):
+			changed = append(changed, after[i])
+		case i >= len(after):
+			changed = append(changed, before[i])
+		case !sameLight(before[i], after[i]):
+			changed = append(changed, before[i], after[i])
+		}
+	}
+	return changed
+}
+
+func (p *LightPropagation) markDirty(x, y, z int) {
+	c := p.index(x/PropagationCellSize, y/PropagationCellSize, z/PropagationCellSize)
+	if !p.dirty.Get(c) {
+		p.dirty.Set(c)
+		p.dirtyCells = append(p.dirtyCells, c)
+	}
+}
+
+func (p *LightPropagation) index(cx, cy, cz int) int {
+	return p.X*p.Y*cz + p.X*cy + cx
+}
+
+func (p *LightPropagation) coords(c int) (int, int, int) {
+	return c % p.X, (c / p.X) % p.Y, c / (p.X * p.Y)
+}
+
+func (p *LightPropagation) surrounds(cx, cy, cz int) bool {
+	return cx >= 0 && cy >= 0 && cz >= 0 && cx < p.X && cy < p.Y && cz < p.Z
+}
+
+func (p *LightPropagation) cellLow(c int) te.Vector3 {
+	cx, cy, cz := p.coords(c)
+	return te.Vec3(float32(cx), float32(cy), float32(cz)).Mul(PropagationCellSize)
+}
+
+func (p *LightPropagation) cellCenter(c int) te.Vector3 {
+	return p.cellLow(c).Add(te.Vec3Splat(PropagationCellSize / 2))
+}
+
+// Calls fn with the cell and its six neighbors inside the grid
+func (p *LightPropagation) neighborhood(c int, fn func(n int)) {
+	cx, cy, cz := p.coords(c)
+	fn(c)
+	for _, d := range [6][3]int{{-1, 0, 0}, {1, 0, 0}, {0, -1, 0}, {0, 1, 0}, {0, 0, -1}, {0, 0, 1}} {
+		if nx, ny, nz := cx+d[0], cy+d[1], cz+d[2]; p.surrounds(nx, ny, nz) {
+			fn(p.index(nx, ny, nz))
+		}
+	}
+}
+
+// Whether the cell is open with filled voxels in or next to it, the only cells that get bounce light
+func (p *LightPropagation) nearSurface(c int) bool {
+	if p.full.Get(c) {
+		return false
+	}
+	near := false
+	p.neighborhood(c, func(n int) {
+		near = near || p.solid[n] > 0
+	})
+	return near
+}
+
+// Recounts the filled voxels in the cell and their color
+func (p *LightPropagation) updateCell(vox *Voxels, c int) {
+	cx, cy, cz := p.coords(c)
+	solid, total := 0, 0
+	albedo := te.Vec3Zero()
+	for z := cz * PropagationCellSize; z < min((cz+1)*PropagationCellSize, vox.Z); z++ {
+		for y := cy * PropagationCellSize; y < min((cy+1)*PropagationCellSize, vox.Y); y++ {
+			for x := cx * PropagationCellSize; x < min((cx+1)*PropagationCellSize, vox.X); x++ {
+				total++
+				idx := vox.Index(x, y, z)
+				if !vox.Presence.Get(idx) {
+					continue
+				}
+				solid++
+				color := vox.Color[idx]
+				albedo = albedo.Add(te.SRGBColorToLinear(color))
+			}
+		}
+	}
+	p.solid[c] = solid
+	p.albedo[c] = albedo
+	p.full.Put(c, solid == total)
+}
+
+// Bounce light for each of the cells, spread over all CPUs since every cell sends shadow rays
+func (p *LightPropagation) computeInjection(vox *Voxels, cells []int) []te.Vector3 {
+	out := make([]te.Vector3, len(cells))
+	chunk := max((len(cells)+cpus-1)/cpus, 1)
+	var wg sync.WaitGroup
+	for start := 0; start < len(cells); start += chunk {
+		end := min(start+chunk, len(cells))
+		wg.Go(func() {
+			for i := start; i < end; i++ {
+				out[i] = p.injection(vox, cells[i])
+			}
+		})
+	}
+	wg.Wait()
+	return out
+}
+
+// Direct light at the cell's center, bounced off the voxels in and around it.
+// Covering a whole side of the cell bounces all of it
+func (p *LightPropagation) injection(vox *Voxels, c int) te.Vector3 {
+	if !p.nearSurface(c) {
+		return te.Vec3Zero()
+	}
+	solid := 0
+	albedo := te.Vec3Zero()
+	p.neighborhood(c, func(n int) {
+		solid += p.solid[n]
+		albedo = albedo.Add(p.albedo[n])
+	})
+	cover := math32.Min(float32(solid)/(PropagationCellSize*PropagationCellSize), 1)
+
+	center := p.cellCenter(c)
+	direct := te.Vec3Zero()
+	for _, light := range vox.Lights {
+		if !light.Reaches(center) {
+			continue
+		}
+		// The middle of lights with an area is close enough for light this blurry
+		sample := light.Sample(center, 0.5, 0.5)
+		if sample.Radiance.Max() <= 0 {
+			continue
+		}
+		ray := Ray{Origin: center, Dir: sample.Dir, Tmax: math32.Min(sample.Dist, DirectionalLightDistance)}
+		if !vox.Occluded(ray) {
+			direct = direct.Add(sample.Radiance)
+		}
+	}
+	return direct.MulComponent(albedo.Div(float32(solid))).Mul(p.Bounce * cover)
+}
+
+// Cells light can still be seen after flooding through, the furthest a change can reach
+func (p *LightPropagation) reach() int {
+	if p.maxInject <= LightCutoff {
+		return 1
+	}
+	if p.Attenuation <= 0 || p.Attenuation >= 1 {
+		return max(p.X, p.Y, p.Z)
+	}
+	return int(math32.Ceil(math32.Log(LightCutoff/p.maxInject)/math32.Log(p.Attenuation))) + 1
+}
+
+// Resets the light around the seed cells back to just their injection and floods
+// it again, along with the light coming in from the cells around them. Light is
+// only ever raised while flooding, so everything that could have come from the
+// seeds has to be reset first, which is every cell within reach of them
+func (p *LightPropagation) reflood(seeds []int, all bool) {
+	low, high := [3]int{0, 0, 0}, [3]int{p.X - 1, p.Y - 1, p.Z - 1}
+	if !all {
+		if len(seeds) == 0 {
+			return
+		}
+		reach := p.reach()
+		low, high = [3]int{p.X, p.Y, p.Z}, [3]int{-1, -1, -1}
+		for _, c := range seeds {
+			cx, cy, cz := p.coords(c)
+			low = [3]int{min(low[0], cx-reach), min(low[1], cy-reach), min(low[2], cz-reach)}
+			high = [3]int{max(high[0], cx+reach), max(high[1], cy+reach), max(high[2], cz+reach)}
+		}
+		low = [3]int{max(low[0], 0), max(low[1], 0), max(low[2], 0)}
+		high = [3]int{min(high[0], p.X-1), min(high[1], p.Y-1), min(high[2], p.Z-1)}
+	}
+
+	queue := []int{}
+	for cz := max(low[2]-1, 0); cz <= min(high[2]+1, p.Z-1); cz++ {
+		for cy := max(low[1]-1, 0); cy <= min(high[1]+1, p.Y-1); cy++ {
+			for cx := max(low[0]-1, 0); cx <= min(high[0]+1, p.X-1); cx++ {
+				c := p.index(cx, cy, cz)
+				inside := cx >= low[0] && cy >= low[1] && cz >= low[2] && cx <= high[0] && cy <= high[1] && cz <= high[2]
+				if inside {
+					p.light[c] = p.inject[c]
+				}
+				if p.light[c].Max() > 0 && !p.full.Get(c) {
+					queue = append(queue, c)
+				}
+			}
+		}
+	}
+	p.flood(queue)
+}
+
+// Spreads light out from the queued cells, keeping the brightest of what reaches
+// each cell per channel, until it falls under LightCutoff
+func (p *LightPropagation) flood(queue []int) {
+	for head := 0; head < len(queue); head++ {
+		c := queue[head]
+		out := p.light[c].Mul(p.Attenuation)
+		if out.Max() < LightCutoff {
+			continue
+		}
+		p.neighborhood(c, func(n int) {
+			cur := p.light[n]
+			if n == c || p.full.Get(n) || (out.X <= cur.X && out.Y <= cur.Y && out.Z <= cur.Z) {
+				return
+			}
+			p.light[n] = te.Vec3(math32.Max(cur.X, out.X), math32.Max(cur.Y, out.Y), math32.Max(cur.Z, out.Z))
+			queue = append(queue, n)
+		})
+		// Drop what's been processed now and then so long floods don't hold on to it
+		if head > 1<<16 && head > len(queue)/2 {
+			queue = append(queue[:0], queue[head+1:]...)
+			head = -1
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package voxel
+
+import (
+	"slices"
+	"testing"
+
+	te "github.com/zheskett/go-voxel/internal/tensor"
+)
+
+// A red floor with a light over it, split in two by a wall a cell thick with a doorway at low z
+func propagationWorld() Voxels {
+	vox := VoxelsInit(64, 32, 64)
+	for x := range vox.X {
+		for z := range vox.Z {
+			vox.SetVoxel(x, 0, z, 200, 40, 40)
+		}
+	}
+	for x := 32; x < 32+PropagationCellSize; x++ {
+		for y := range vox.Y {
+			for z := 16; z < vox.Z; z++ {
+				vox.SetVoxel(x, y, z, 200, 200, 200)
+			}
+		}
+	}
+	vox.Lights = append(vox.Lights, Light{Position: te.Vec3(16, 20, 40), Color: te.Vec3Splat(2000)})
+	return vox
+}
+
+// TestPropagationFloods expects red light off the floor near the light, less of
+// it through the doorway and none behind the wall far from it.
+func TestPropagationFloods(t *testing.T) {
+	vox := propagationWorld()
+	if got := vox.IndirectLight(te.Vec3(16, 2, 40)); got.Max() != 0 {
+		t.Fatalf("Expected no indirect light before enabling, got %v", got)
+	}
+	vox.EnablePropagation()
+
+	lit := vox.IndirectLight(te.Vec3(16, 2, 40))
+	if lit.X <= 0 || lit.X <= 2*lit.Y {
+		t.Errorf("Expected red bounce light by the light, got %v", lit)
+	}
+	door := vox.IndirectLight(te.Vec3(42, 2, 8))
+	if door.X <= 0 || door.X >= lit.X {
+		t.Errorf("Expected dimmer light past the doorway, got %v of %v", door, lit)
+	}
+	if behind := vox.IndirectLight(te.Vec3(56, 2, 60)); behind.X >= door.X {
+		t.Errorf("Expected light to fade away from the doorway, got %v past %v", behind, door)
+	}
+
+	vox.DisablePropagation()
+	if got := vox.IndirectLight(te.Vec3(16, 2, 40)); got.Max() != 0 {
+		t.Errorf("Expected no indirect light after disabling, got %v", got)
+	}
+}
+
+// TestPropagationUpdateMatchesRebuild expects updating after edits and a moved
+// light to end up with the same light as building from scratch.
+func TestPropagationUpdateMatchesRebuild(t *testing.T) {
+	vox := propagationWorld()
+	vox.EnablePropagation()
+	p := vox.Propagation()
+
+	steps := []func(){
+		func() {
+			// Close the doorway
+			for y := range vox.Y {
+				for z := range 16 {
+					vox.SetVoxel(33, y, z, 200, 200, 200)
+				}
+			}
+		},
+		func() { vox.SetVoxel(16, 10, 40, 40, 40, 200) },
+		func() { vox.ResetVoxel(33, 1, 4) },
+		func() { vox.Lights[0] = Light{Position: te.Vec3(48, 20, 40), Color: te.Vec3Splat(1500)} },
+		func() {
+			vox.Lights = append(vox.Lights, DirectionalLight{Direction: te.Vec3(1, -2, 0), Color: te.Vec3Splat(0.5)})
+		},
+	}
+	for i, step := range steps {
+		before := slices.Clone(p.light)
+		step()
+		vox.UpdatePropagation()
+		updated := slices.Clone(p.light)
+		if slices.Equal(before, updated) {
+			t.Errorf("Step %v: expected the light to change", i)
+		}
+		vox.RebuildPropagation()
+		for c := range updated {
+			if diff := updated[c].Sub(p.light[c]).Abs().Max(); diff > 1e-5 {
+				t.Fatalf("Step %v: cell %v has %v updated but %v rebuilt", i, c, updated[c], p.light[c])
+			}
+		}
+	}
+}
@@ -0,0 +1,274 @@
+package voxel
+
+import (
+	"fmt"
+	clr "image/color"
+	"iter"
+	"math"
+	"math/bits"
+	"sync/atomic"
+
+	"github.com/chewxy/math32"
+	te "github.com/zheskett/go-voxel/internal/tensor"
+	"github.com/zheskett/go-voxel/pkg/voxparse"
+)
+
+// Region queries all work on half open boxes, [low, high), clamped to the world.
+// They scan the presence bits a whole word at a time, so empty space costs
+// very little even in huge sparse worlds.
+
+// Returns the low corner of the box (inclusive)
+func (bb *AABB) Low() [3]int {
+	return bb.low
+}
+
+// Returns the high corner of the box (exclusive)
+func (bb *AABB) High() [3]int {
+	return bb.high
+}
+
+// Whether the box contains no voxels
+func (bb *AABB) Empty() bool {
+	return bb.high[0] <= bb.low[0] || bb.high[1] <= bb.low[1] || bb.high[2] <= bb.low[2]
+}
+
+// Returns the box clamped to the bounds of the world
+func (vox *Voxels) Clamp(bb AABB) AABB {
+	return AABBInit(
+		max(bb.low[0], 0), max(bb.low[1], 0), max(bb.low[2], 0),
+		min(bb.high[0], vox.X), min(bb.high[1], vox.Y), min(bb.high[2], vox.Z),
+	)
+}
+
+// Iterates the coordinates of every occupied voxel in the box in x, y, z order
+func (vox *Voxels) Occupied(bb AABB) iter.Seq[[3]int] {
+	return func(yield func([3]int) bool) {
+		bb = vox.Clamp(bb)
+		if bb.Empty() {
+			return
+		}
+		for z := bb.low[2]; z < bb.high[2]; z++ {
+			for y := bb.low[1]; y < bb.high[1]; y++ {
+				row := vox.Index(0, y, z)
+				cont := vox.scanRange(row+bb.low[0], row+bb.high[0], func(idx int) bool {
+					return yield([3]int{idx - row, y, z})
+				})
+				if !cont {
+					return
+				}
+			}
+		}
+	}
+}
+
+// Counts the occupied voxels in the box
+func (vox *Voxels) Count(bb AABB) int {
+	bb = vox.Clamp(bb)
+	if bb.Empty() {
+		return 0
+	}
+	count := 0
+	for z := bb.low[2]; z < bb.high[2]; z++ {
+		for y := bb.low[1]; y < bb.high[1]; y++ {
+			row := vox.Index(0, y, z)
+			count += vox.countRange(row+bb.low[0], row+bb.high[0])
+		}
+	}
+	return count
+}
+
+// Whether any voxel in the box is occupied
+func (vox *Voxels) OverlapsBox(bb AABB) bool {
+	for range vox.Occupied(bb) {
+		return true
+	}
+	return false
+}
+
+// Iterates the occupied voxels whose centers are within radius of center
+func (vox *Voxels) OccupiedInSphere(center te.Vector3, radius float32) iter.Seq[[3]int] {
+	return func(yield func([3]int) bool) {
+		for xyz := range vox.Occupied(sphereBounds(center, radius)) {
+			if voxelCenter(xyz).Sub(center).LenSqr() <= radius*radius && !yield(xyz) {
+				return
+			}
+		}
+	}
+}
+
+// Whether any voxel center is within radius of center
+func (vox *Voxels) OverlapsSphere(center te.Vector3, radius float32) bool {
+	for range vox.OccupiedInSphere(center, radius) {
+		return true
+	}
+	return false
+}
+
+// Returns the smallest box containing every occupied voxel, false if the world is empty
+func (vox *Voxels) Bounds() (AABB, bool) {
+	low := [3]int{vox.X, vox.Y, vox.Z}
+	high := [3]int{0, 0, 0}
+	found := false
+	for z := range vox.Z {
+		for y := range vox.Y {
+			row := vox.Index(0, y, z)
+			// Only the first and last voxel of each row can grow the box in x
+			first, last := -1, -1
+			vox.scanRange(row, row+vox.X, func(idx int) bool {
+				if first < 0 {
+					first = idx - row
+				}
+				last = idx - row
+				return true
+			})
+			if first < 0 {
+				continue
+			}
+			found = true
+			low = [3]int{min(low[0], first), min(low[1], y), min(low[2], z)}
+			high = [3]int{max(high[0], last+1), max(high[1], y+1), max(high[2], z+1)}
+		}
+	}
+	return AABBInit(low[0], low[1], low[2], high[0], high[1], high[2]), found
+}
+
+// Finds the occupied voxel whose center is closest to pos, within maxDist.
+// Searches outwards in cube shells so nearby hits return quickly
+func (vox *Voxels) NearestOccupied(pos te.Vector3, maxDist float32) ([3]int, bool) {
+	cx, cy, cz := int(math32.Floor(pos.X)), int(math32.Floor(pos.Y)), int(math32.Floor(pos.Z))
+	best, bestDist := [3]int{}, maxDist*maxDist
+	found := false
+	maxShell := int(math32.Ceil(maxDist)) + 1
+	for r := 0; r <= maxShell; r++ {
+		// Nothing in this shell or further out can be closer than r - 0.5
+		if found && float32(r)-0.5 > math32.Sqrt(bestDist) {
+			break
+		}
+		for _, shell := range cubeShell(cx, cy, cz, r) {
+			for xyz := range vox.Occupied(shell) {
+				dist := voxelCenter(xyz).Sub(pos).LenSqr()
+				if dist <= bestDist {
+					best, bestDist, found = xyz, dist, true
+				}
+			}
+		}
+	}
+	return best, found
+}
+
+// Copies the voxels in the box into a new VoxelObj with the box's low corner at the origin.
+// Fails if the region has more colors, counting each material separately, than fit in a palette,
+// or is too big for VoxelObj's int16 coordinates
+func (vox *Voxels) ExtractObj(bb AABB) (VoxelObj, error) {
+	bb = vox.Clamp(bb)
+	if bb.Empty() {
+		return VoxelObj{}, fmt.Errorf("Empty region")
+	}
+	sx, sy, sz := bb.high[0]-bb.low[0], bb.high[1]-bb.low[1], bb.high[2]-bb.low[2]
+	if sx > math.MaxInt16 || sy > math.MaxInt16 || sz > math.MaxInt16 {
+		return VoxelObj{}, fmt.Errorf("Region of %v x %v x %v is too big for a VoxelObj", sx, sy, sz)
+	}
+	vObj := VoxelObj{
+		X:           int16(sx),
+		Y:           int16(sy),
+		Z:           int16(sz),
+		Voxels:      make(map[[3]int16]byte),
+		ColorPalete: voxparse.VoxPalette{clr.RGBA{0, 0, 0, 0}},
+	}
+
+	type entry struct {
+		color    [3]byte
+		material MaterialID
+	}
+	indices := make(map[entry]byte)
+	for xyz := range vox.Occupied(bb) {
+		idx := vox.Index(xyz[0], xyz[1], xyz[2])
+		key := entry{vox.Color[idx], vox.Material(idx)}
+		cIdx, ok := indices[key]
+		if !ok {
+			if len(vObj.ColorPalete) >= 256 {
+				return VoxelObj{}, fmt.Errorf("Too many colors in region for a palette")
+			}
+			cIdx = byte(len(vObj.ColorPalete))
+			indices[key] = cIdx
+			vObj.ColorPalete = append(vObj.ColorPalete, clr.RGBA{key.color[0], key.color[1], key.color[2], 0xff})
+			if key.material != MaterialDiffuse {
+				if vObj.Materials == nil {
+					vObj.Materials = make(map[byte]Material)
+				}
+				vObj.Materials[cIdx] = vox.MaterialProps(key.material)
+			}
+		}
+		local := [3]int16{int16(xyz[0] - bb.low[0]), int16(xyz[1] - bb.low[1]), int16(xyz[2] - bb.low[2])}
+		vObj.Voxels[local] = cIdx
+	}
+	return vObj, nil
+}
+
+// Calls fn with every set presence index in [start, end), stops early if fn returns false
+func (vox *Voxels) scanRange(start, end int, fn func(idx int) bool) bool {
+	for idx := start; idx < end; {
+		bucket := idx / 64
+		word := atomic.LoadUint64(&vox.Presence.bits[bucket]) >> (idx % 64)
+		if word == 0 {
+			idx = (bucket + 1) * 64
+			continue
+		}
+		idx += bits.TrailingZeros64(word)
+		if idx >= end {
+			break
+		}
+		if !fn(idx) {
+			return false
+		}
+		idx++
+	}
+	return true
+}
+
+// Number of set presence bits in [start, end)
+func (vox *Voxels) countRange(start, end int) int {
+	count := 0
+	for idx := start; idx < end; {
+		bucket := idx / 64
+		word := atomic.LoadUint64(&vox.Presence.bits[bucket]) >> (idx % 64)
+		span := min(64-idx%64, end-idx)
+		if span < 64 {
+			word &= (uint64(1) << span) - 1
+		}
+		count += bits.OnesCount64(word)
+		idx += span
+	}
+	return count
+}
+
+func voxelCenter(xyz [3]int) te.Vector3 {
+	return te.Vec3(float32(xyz[0])+0.5, float32(xyz[1])+0.5, float32(xyz[2])+0.5)
+}
+
+// Box of every voxel whose center could be within radius of center
+func sphereBounds(center te.Vector3, radius float32) AABB {
+	low := center.Sub(te.Vec3Splat(radius + 0.5))
+	high := center.Add(te.Vec3Splat(radius + 0.5))
+	return AABBInit(
+		int(math32.Floor(low.X)), int(math32.Floor(low.Y)), int(math32.Floor(low.Z)),
+		int(math32.Floor(high.X))+1, int(math32.Floor(high.Y))+1, int(math32.Floor(high.Z))+1,
+	)
+}
+
+// The boxes making up the surface of the cube of radius r around a cell, without overlap
+func cubeShell(cx, cy, cz, r int) []AABB {
+	if r == 0 {
+		return []AABB{AABBInit(cx, cy, cz, cx+1, cy+1, cz+1)}
+	}
+	lx, ly, lz := cx-r, cy-r, cz-r
+	hx, hy, hz := cx+r+1, cy+r+1, cz+r+1
+	return []AABB{
+		AABBInit(lx, ly, lz, hx, hy, lz+1),         // Front
+		AABBInit(lx, ly, hz-1, hx, hy, hz),         // Back
+		AABBInit(lx, ly, lz+1, hx, ly+1, hz-1),     // Bottom
+		AABBInit(lx, hy-1, lz+1, hx, hy, hz-1),     // Top
+		AABBInit(lx, ly+1, lz+1, lx+1, hy-1, hz-1), // Left
+		AABBInit(hx-1, ly+1, lz+1, hx, hy-1, hz-1), // Right
+	}
+}
@@ -0,0 +1,100 @@
+package voxel
+
+import (
+	"math"
+	"testing"
+
+	te "github.com/zheskett/go-voxel/internal/tensor"
+)
+
+func queryWorld() Voxels {
+	vox := VoxelsInit(100, 50, 40)
+	vox.SetVoxel(3, 4, 5, 1, 2, 3)
+	vox.SetVoxel(70, 4, 5, 1, 2, 3)
+	vox.SetVoxel(99, 49, 39, 4, 5, 6)
+	vox.SetVoxel(63, 10, 20, 7, 8, 9)
+	vox.SetVoxel(64, 10, 20, 7, 8, 9)
+	return vox
+}
+
+// TestRegionQueries checks iteration, counting and overlap queries against a
+// handful of known voxels, including ones on either side of a word boundary.
+func TestRegionQueries(t *testing.T) {
+	vox := queryWorld()
+
+	all := AABBInit(0, 0, 0, vox.X, vox.Y, vox.Z)
+	if count := vox.Count(all); count != 5 {
+		t.Errorf("Expected 5 voxels, got %v", count)
+	}
+	row := AABBInit(0, 10, 20, 64, 11, 21)
+	if count := vox.Count(row); count != 1 {
+		t.Errorf("Expected 1 voxel left of x = 64, got %v", count)
+	}
+
+	found := [][3]int{}
+	for xyz := range vox.Occupied(AABBInit(-10, 0, 0, 80, 20, 30)) {
+		found = append(found, xyz)
+	}
+	expected := [][3]int{{3, 4, 5}, {70, 4, 5}, {63, 10, 20}, {64, 10, 20}}
+	if len(found) != len(expected) {
+		t.Fatalf("Expected %v, got %v", expected, found)
+	}
+	for i := range expected {
+		if found[i] != expected[i] {
+			t.Errorf("Expected %v, got %v", expected, found)
+		}
+	}
+
+	if vox.OverlapsBox(AABBInit(4, 0, 0, 60, 50, 40)) {
+		t.Errorf("Expected no overlap with empty box")
+	}
+	if !vox.OverlapsSphere(te.Vec3(3.5, 4.5, 8), 3) || vox.OverlapsSphere(te.Vec3(3.5, 4.5, 8), 2) {
+		t.Errorf("Expected sphere overlap only within 3 voxels of (3, 4, 5)")
+	}
+
+	bounds, ok := vox.Bounds()
+	if !ok || bounds.Low() != [3]int{3, 4, 5} || bounds.High() != [3]int{100, 50, 40} {
+		t.Errorf("Unexpected bounds %v", bounds)
+	}
+}
+
+// TestNearestOccupied expects the closest voxel to be found, and nothing past maxDist.
+func TestNearestOccupied(t *testing.T) {
+	vox := queryWorld()
+
+	xyz, ok := vox.NearestOccupied(te.Vec3(60, 10.5, 20.5), 10)
+	if !ok || xyz != [3]int{63, 10, 20} {
+		t.Errorf("Expected (63, 10, 20), got %v %v", xyz, ok)
+	}
+	if _, ok := vox.NearestOccupied(te.Vec3(30, 30, 30), 5); ok {
+		t.Errorf("Expected nothing within 5 voxels")
+	}
+}
+
+// TestExtractObj expects a region to round trip through a VoxelObj, and regions
+// too big for one to fail.
+func TestExtractObj(t *testing.T) {
+	vox := queryWorld()
+	vObj, err := vox.ExtractObj(AABBInit(60, 0, 0, 100, 50, 40))
+	if err != nil {
+		t.Fatalf("ExtractObj: %v", err)
+	}
+	if len(vObj.Voxels) != 4 {
+		t.Fatalf("Expected 4 voxels, got %v", len(vObj.Voxels))
+	}
+
+	copied := VoxelsInit(100, 50, 40)
+	copied.AddVoxelObj(vObj, 60, 0, 0)
+	if copied.Color[copied.Index(99, 49, 39)] != [3]byte{4, 5, 6} || copied.Count(AABBInit(0, 0, 0, 100, 50, 40)) != 4 {
+		t.Errorf("Expected extracted voxels to be placed back where they came from")
+	}
+
+	long := VoxelsInit(math.MaxInt16+1, 1, 1)
+	long.SetVoxel(0, 0, 0, 1, 2, 3)
+	if _, err := long.ExtractObj(AABBInit(0, 0, 0, long.X, 1, 1)); err == nil {
+		t.Errorf("Expected an error for a region %v long", long.X)
+	}
+	if _, err := long.ExtractObj(AABBInit(0, 0, 0, math.MaxInt16, 1, 1)); err != nil {
+		t.Errorf("Expected the longest region that fits to work, got %v", err)
+	}
+}
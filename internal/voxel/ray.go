@@ -9,6 +9,9 @@ const (
 	// the shared face of two neighbor voxels. This distance offset is used in:
 	// vox = hit_position - hit_normal * VoxelRayDelta
 	// to find the actual voxel the ray hit
+	//
+	// Prefer RayHit.IntPos and RayHit.LastEmpty, this is only still needed
+	// for nudging points that aren't ray hits
 	VoxelRayDelta = 0.05
 )
 
@@ -16,6 +19,16 @@ type Ray struct {
 	Origin te.Vector3
 	Dir    te.Vector3
 	Tmax   float32
+
+	// Rays starting inside an occupied voxel skip it unless this is set,
+	// in which case they hit it at time 0
+	ReportStart bool
+	// Fill in the RayHit fields marked as details, off by default to keep the common case fast
+	Details bool
+	// Marches through the voxels of this material instead of stopping at them, and hits
+	// the first cell that isn't one, empty or not. For rays inside glass. The default,
+	// MaterialDiffuse, marches normally
+	Medium MaterialID
 }
 
 type RayHit struct {
@@ -23,6 +36,15 @@ type RayHit struct {
 	Time     float32
 	Color    [3]byte
 	IntPos   [3]int
+	Index    int // Index of IntPos into the Voxels arrays
 	Position te.Vector3
 	Normal   te.Vector3
+	Steps    int // Number of cells stepped through before the hit, or before giving up for misses
+
+	// Details
+	LastEmpty [3]int     // The cell the ray was in before the hit, may be outside of the world
+	Material  MaterialID // Material of the voxel hit
+	UVW       te.Vector3 // Hit position inside the voxel, each component in [0, 1]
+	FaceUV    te.Vector2 // Hit position on the face, each component in [0, 1]
+	Through   float32    // Distance the ray would travel inside the hit voxel before leaving it
 }
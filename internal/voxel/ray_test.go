@@ -0,0 +1,162 @@
+package voxel
+
+import (
+	"testing"
+
+	"github.com/chewxy/math32"
+	te "github.com/zheskett/go-voxel/internal/tensor"
+)
+
+func filledWorld(size int) Voxels {
+	vox := VoxelsInit(size, size, size)
+	for z := range size {
+		for y := range size {
+			for x := range size {
+				vox.SetVoxel(x, y, z, 255, 255, 255)
+			}
+		}
+	}
+	return vox
+}
+
+func closeTo(a, b float32) bool {
+	return math32.Abs(a-b) < 1e-3
+}
+
+// TestRayEntersFromEverySide fires a ray at the center of each face of a filled
+// world from outside and expects it to hit the entry voxel with that face's normal.
+func TestRayEntersFromEverySide(t *testing.T) {
+	vox := filledWorld(8)
+	cases := []struct {
+		origin te.Vector3
+		dir    te.Vector3
+		voxel  [3]int
+	}{
+		{te.Vec3(-10, 4.5, 4.5), te.Vec3(1, 0, 0), [3]int{0, 4, 4}},
+		{te.Vec3(18, 4.5, 4.5), te.Vec3(-1, 0, 0), [3]int{7, 4, 4}},
+		{te.Vec3(4.5, -10, 4.5), te.Vec3(0, 1, 0), [3]int{4, 0, 4}},
+		{te.Vec3(4.5, 18, 4.5), te.Vec3(0, -1, 0), [3]int{4, 7, 4}},
+		{te.Vec3(4.5, 4.5, -10), te.Vec3(0, 0, 1), [3]int{4, 4, 0}},
+		{te.Vec3(4.5, 4.5, 18), te.Vec3(0, 0, -1), [3]int{4, 4, 7}},
+	}
+	for _, c := range cases {
+		hit := vox.MarchRay(Ray{Origin: c.origin, Dir: c.dir, Tmax: 100})
+		if !hit.Hit {
+			t.Errorf("Ray from %v: expected hit", c.origin)
+			continue
+		}
+		if hit.IntPos != c.voxel {
+			t.Errorf("Ray from %v: expected voxel %v, got %v", c.origin, c.voxel, hit.IntPos)
+		}
+		if hit.Normal != c.dir.Neg() {
+			t.Errorf("Ray from %v: expected normal %v, got %v", c.origin, c.dir.Neg(), hit.Normal)
+		}
+		if !closeTo(hit.Time, 10) {
+			t.Errorf("Ray from %v: expected time 10, got %v", c.origin, hit.Time)
+		}
+	}
+}
+
+// TestRayEntersFromEveryCorner fires a ray at each corner of a filled world
+// along the diagonal and expects it to hit the corner voxel.
+func TestRayEntersFromEveryCorner(t *testing.T) {
+	vox := filledWorld(8)
+	for _, corner := range [][3]int{
+		{0, 0, 0}, {1, 0, 0}, {0, 1, 0}, {0, 0, 1},
+		{1, 1, 0}, {1, 0, 1}, {0, 1, 1}, {1, 1, 1},
+	} {
+		// Aim just inside the corner so the entry face isn't a three way tie
+		target := te.Vec3(float32(corner[0]*8), float32(corner[1]*8), float32(corner[2]*8))
+		outward := te.Vec3(float32(corner[0]*2-1), float32(corner[1]*2-1), float32(corner[2]*2-1))
+		target = target.Sub(outward.Mul(0.25))
+		origin := target.Add(outward.Mul(5)).Add(te.Vec3(outward.X*0.1, 0, 0))
+		dir := target.Sub(origin).Normalized()
+
+		hit := vox.MarchRay(Ray{Origin: origin, Dir: dir, Tmax: 100})
+		expected := [3]int{corner[0] * 7, corner[1] * 7, corner[2] * 7}
+		if !hit.Hit || hit.IntPos != expected {
+			t.Errorf("Ray to corner %v: expected voxel %v, got %v", corner, expected, hit.IntPos)
+			continue
+		}
+		if hit.Normal.Dot(dir) >= 0 || hit.Normal.LenSqr() != 1 {
+			t.Errorf("Ray to corner %v: expected unit normal facing the ray, got %v", corner, hit.Normal)
+		}
+	}
+}
+
+// TestRayMissesWorld expects rays that never enter the world, or that point
+// away from it, to miss.
+func TestRayMissesWorld(t *testing.T) {
+	vox := filledWorld(8)
+	for _, ray := range []Ray{
+		{Origin: te.Vec3(-10, 20, 4), Dir: te.Vec3(1, 0, 0), Tmax: 100},
+		{Origin: te.Vec3(-10, 4, 4), Dir: te.Vec3(-1, 0, 0), Tmax: 100},
+		{Origin: te.Vec3(-10, 4, 4), Dir: te.Vec3(1, 0, 0), Tmax: 5},
+		{Origin: te.Vec3(-1, -1, -1), Dir: te.Vec3(1, -1, 1).Normalized(), Tmax: 100},
+	} {
+		if hit := vox.MarchRay(ray); hit.Hit {
+			t.Errorf("Ray %v: expected miss, got hit at %v", ray, hit.IntPos)
+		}
+	}
+}
+
+// TestRayStartVoxel expects a ray starting inside an occupied voxel to skip it
+// by default, and to hit it with a normal when ReportStart is set.
+func TestRayStartVoxel(t *testing.T) {
+	vox := VoxelsInit(8, 8, 8)
+	vox.SetVoxel(2, 2, 2, 255, 255, 255)
+	vox.SetVoxel(5, 2, 2, 255, 255, 255)
+
+	ray := Ray{Origin: te.Vec3(2.5, 2.5, 2.5), Dir: te.Vec3(1, 0, 0), Tmax: 100}
+	hit := vox.MarchRay(ray)
+	if !hit.Hit || hit.IntPos != [3]int{5, 2, 2} {
+		t.Errorf("Expected start voxel to be skipped, got %v", hit.IntPos)
+	}
+
+	ray.ReportStart = true
+	hit = vox.MarchRay(ray)
+	if !hit.Hit || hit.IntPos != [3]int{2, 2, 2} || hit.Time != 0 {
+		t.Errorf("Expected start voxel at time 0, got %v at %v", hit.IntPos, hit.Time)
+	}
+	if hit.Normal != te.Vec3(-1, 0, 0) {
+		t.Errorf("Expected normal (-1, 0, 0), got %v", hit.Normal)
+	}
+}
+
+// TestRayDetails expects detailed hits to report the cell in front of the face,
+// the hit's position on the face and the voxel's material.
+func TestRayDetails(t *testing.T) {
+	vox := VoxelsInit(8, 8, 8)
+	vox.SetVoxel(4, 2, 2, 255, 255, 255)
+	vox.SetMaterial(4, 2, 2, MaterialID(3))
+
+	ray := Ray{Origin: te.Vec3(0.5, 2.25, 2.75), Dir: te.Vec3(1, 0, 0), Tmax: 100, Details: true}
+	hit := vox.MarchRay(ray)
+	if !hit.Hit || hit.IntPos != [3]int{4, 2, 2} || hit.Index != vox.Index(4, 2, 2) {
+		t.Fatalf("Expected hit at (4, 2, 2), got %v", hit.IntPos)
+	}
+	if hit.LastEmpty != [3]int{3, 2, 2} {
+		t.Errorf("Expected last empty cell (3, 2, 2), got %v", hit.LastEmpty)
+	}
+	if hit.Steps != 4 {
+		t.Errorf("Expected 4 steps, got %v", hit.Steps)
+	}
+	// Misses count the steps up to leaving the world
+	if miss := vox.MarchRay(Ray{Origin: ray.Origin, Dir: te.Vec3(0, 0, 1), Tmax: 100}); miss.Hit || miss.Steps != 6 {
+		t.Errorf("Expected a miss after 6 steps, got %v after %v", miss.Hit, miss.Steps)
+	}
+	if !closeTo(hit.Through, 1) {
+		t.Errorf("Expected 1 voxel through, got %v", hit.Through)
+	}
+	// Clipping a corner is a shorter way through
+	corner := vox.MarchRay(Ray{Origin: te.Vec3(3.5, 2.25, 2.5), Dir: te.Vec3(1, 1, 0).Normalized(), Tmax: 100, Details: true})
+	if !corner.Hit || corner.IntPos != [3]int{4, 2, 2} || !closeTo(corner.Through, 0.25*math32.Sqrt2) {
+		t.Errorf("Expected %v through the corner of (4, 2, 2), got %v through %v", 0.25*math32.Sqrt2, corner.Through, corner.IntPos)
+	}
+	if hit.Material != MaterialID(3) {
+		t.Errorf("Expected material 3, got %v", hit.Material)
+	}
+	if !closeTo(hit.FaceUV.X, 0.75) || !closeTo(hit.FaceUV.Y, 0.25) || !closeTo(hit.UVW.X, 0) {
+		t.Errorf("Unexpected face coordinates %v %v", hit.FaceUV, hit.UVW)
+	}
+}
@@ -1,12 +1,23 @@
+// Package voxel holds the voxel world and the ray marching through it.
+//
+// Concurrency: any number of goroutines may read the world (MarchRay, Presence.Get, ...)
+// at once, and the lighting cache (CacheLighting, LightCached) may be filled from all of
+// them. Edits to the world must not overlap with those reads, so while a frame is rendering
+// other goroutines submit edits with Journal.Submit, and the engine applies them between
+// frames with Journal.ApplyPending. UpdateLightCache, ClearLightCache and
+// UpdatePropagation also belong between frames.
 package voxel
 
 import (
+	"sync/atomic"
+
 	"github.com/chewxy/math32"
-	"github.com/go-gl/glfw/v3.3/glfw"
 	"github.com/zheskett/go-voxel/internal/tensor"
 )
 
 // Compact storage for an array of bools
+//
+// All operations are atomic, so bits can be read and written from many goroutines at once
 type BitArray struct {
 	bits []uint64
 }
@@ -27,24 +38,21 @@ func (bits *BitArray) Get(index int) bool {
 	bucket := index / 64
 	shift := index % 64
 	mask := uint64(1) << shift
-	return bits.bits[bucket]&mask != 0
+	return atomic.LoadUint64(&bits.bits[bucket])&mask != 0
 }
 
 func (bits *BitArray) Set(index int) {
 	bucket := index / 64
 	shift := index % 64
 	mask := uint64(1) << shift
-	bits.bits[bucket] |= mask
+	atomic.OrUint64(&bits.bits[bucket], mask)
 }
 
 func (bits *BitArray) Put(index int, value bool) {
-	bucket := index / 64
-	shift := index % 64
-	mask := uint64(1) << shift
 	if value {
-		bits.bits[bucket] |= mask
+		bits.Set(index)
 	} else {
-		bits.bits[bucket] &= ^mask
+		bits.Reset(index)
 	}
 }
 
@@ -52,16 +60,16 @@ func (bits *BitArray) Reset(index int) {
 	bucket := index / 64
 	shift := index % 64
 	mask := uint64(1) << shift
-	bits.bits[bucket] ^= mask
+	atomic.AndUint64(&bits.bits[bucket], ^mask)
 }
 
 func (bits *BitArray) Clear() {
 	for i := range bits.bits {
-		bits.bits[i] = 0
+		atomic.StoreUint64(&bits.bits[i], 0)
 	}
 }
 
-// Just a point light
+// A point light with inverse square falloff, see lights.go for the other kinds
 type Light struct {
 	Position tensor.Vector3
 	Color    tensor.Vector3 // Can have mag > 1 for a bright light
@@ -81,10 +89,16 @@ type Voxels struct {
 
 	// Actually, this would be really easy to bake lighting as long as we aren't moving the lights at runtime
 	// Doing realtime lighting just seems more interesting tho
-	LightCached BitArray // Whether or not we already having lighting data for that frame
+	LightCached BitArray // Whether or not we already have valid lighting data for that voxel
 	Lighting    []CachedLighting
 
-	Lights []Light // Shouldn't be in here probably, maybe in another larger structure holding all worlds stuff
+	Lights []LightSource // Shouldn't be in here probably, maybe in another larger structure holding all worlds stuff
+	// Indexed by MaterialID, starts out with the built in materials. Call ClearLightCache after changing one
+	Materials []Material
+
+	materials   map[int]MaterialID // Sparse, voxels not in here are MaterialDiffuse
+	lightCache  *lightCache
+	propagation *LightPropagation // nil unless EnablePropagation was called
 }
 
 func VoxelsInit(x, y, z int) Voxels {
@@ -92,23 +106,28 @@ func VoxelsInit(x, y, z int) Voxels {
 	color := make([][3]byte, z*y*x)
 	lighting := make([]CachedLighting, z*y*x)
 	lightcache := BitArrayInit(z * y * x)
-	lights := make([]Light, 0)
+	lights := make([]LightSource, 0)
 	for i := 0; i < z*y*x; i++ {
 		color[i] = [3]byte{0, 0, 0}
 	}
-	return Voxels{z, y, x, presence, color, lightcache, lighting, lights}
+	materials := make(map[int]MaterialID)
+	cache := lightCacheInit(x, y, z)
+	return Voxels{z, y, x, presence, color, lightcache, lighting, lights, defaultMaterials(), materials, cache, nil}
 }
 
 func (vox *Voxels) SetVoxel(x, y, z int, r, g, b byte) {
 	idx := vox.Index(x, y, z)
 	vox.Presence.Set(idx)
 	vox.Color[idx] = [3]byte{r, g, b}
+	vox.MarkDirty(x, y, z)
 }
 
 func (vox *Voxels) ResetVoxel(x, y, z int) {
 	idx := vox.Index(x, y, z)
 	vox.Presence.Reset(idx)
 	vox.Color[idx] = [3]byte{0, 0, 0}
+	delete(vox.materials, idx)
+	vox.MarkDirty(x, y, z)
 }
 
 func (vox *Voxels) Index(x, y, z int) int {
@@ -131,13 +150,40 @@ const (
 )
 
 func (vox *Voxels) MarchRay(ray Ray) RayHit {
+	return vox.marchRay(ray, false)
+}
+
+// Whether anything blocks the ray before Tmax. Faster than MarchRay as it stops
+// at the first hit, filling in only where it is. Transparent voxels block it too, see Transmittance
+func (vox *Voxels) Occluded(ray Ray) bool {
+	return vox.marchRay(ray, true).Hit
+}
+
+func (vox *Voxels) marchRay(ray Ray, anyHit bool) RayHit {
 	rayhit := RayHit{Hit: false}
 	origin, direc, tmax := ray.Origin, ray.Dir, ray.Tmax
 
+	// Clip the ray to the world so rays from outside start where they enter it,
+	// and rays that never enter (or leave before Tmax) stop right away
+	tenter, texit, entryside, ok := vox.clipRay(origin, direc)
+	if !ok || tenter > tmax {
+		return rayhit
+	}
+	tmax = math32.Min(tmax, texit)
+	start := float32(0.0)
+	if tenter > 0 {
+		start = tenter
+		origin = origin.Add(direc.Mul(tenter))
+	}
+
 	ox, oy, oz := origin.Elms()
 	dx, dy, dz := direc.Elms()
 
 	x, y, z := int(math32.Floor(ox)), int(math32.Floor(oy)), int(math32.Floor(oz))
+	if start > 0 {
+		// The entry point lies on the world's surface, so it can round into the cell just outside
+		x, y, z = min(max(x, 0), vox.X-1), min(max(y, 0), vox.Y-1), min(max(z, 0), vox.Z-1)
+	}
 	adx, ady, adz := math32.Abs(dx), math32.Abs(dy), math32.Abs(dz)
 	fractx, fracty, fractz := ox-float32(x), oy-float32(y), oz-float32(z)
 
@@ -189,18 +235,52 @@ func (vox *Voxels) MarchRay(ray Ray) RayHit {
 		}
 	}
 
+	timex, timey, timez = timex+start, timey+start, timez+start
+
 	side := none
-	time := float32(0.0)
+	skip := !ray.ReportStart
+	if start > 0 {
+		side = entryside
+		skip = false
+	} else if ray.ReportStart {
+		// Report the start voxel as if the ray entered it through the face it is heading away from
+		switch {
+		case adx >= ady && adx >= adz:
+			side = axisX
+		case ady >= adz:
+			side = axisY
+		default:
+			side = axisZ
+		}
+	}
+
+	time := start
+	steps := 0
+	medium := ray.Medium != MaterialDiffuse
 	for {
 		if time > tmax {
 			break
 		}
-		if vox.Surrounds(x, y, z) {
+		// Leaving the world means there is nothing left to hit
+		if !vox.Surrounds(x, y, z) {
+			break
+		}
+		if skip {
+			skip = false
+		} else {
 			idx := vox.Index(x, y, z)
-			if vox.Presence.Get(idx) {
+			stop := vox.Presence.Get(idx)
+			if medium {
+				stop = !stop || vox.materials[idx] != ray.Medium
+			}
+			if stop {
 				rayhit.Hit = true
 				rayhit.Time = time
 				rayhit.IntPos = [3]int{x, y, z}
+				rayhit.Index = idx
+				if anyHit {
+					break
+				}
 				rayhit.Position = ray.Origin.Add(ray.Dir.Mul(time))
 				rayhit.Color = vox.Color[idx]
 				switch side {
@@ -213,10 +293,14 @@ func (vox *Voxels) MarchRay(ray Ray) RayHit {
 				default:
 					rayhit.Normal = tensor.Vec3(0, 0, 0)
 				}
+				if ray.Details {
+					vox.fillDetails(&rayhit, side, min(timex, timey, timez)-time)
+				}
 				break
 			}
 		}
 
+		steps++
 		if timex < timey {
 			if timex < timez {
 				x += stepx
@@ -244,34 +328,83 @@ func (vox *Voxels) MarchRay(ray Ray) RayHit {
 		}
 	}
 
+	rayhit.Steps = steps
 	return rayhit
 }
 
-// Adds a voxel object to the world
+// Fills in the RayHit fields that are only wanted for some rays.
+// through is how far the ray goes from the hit to the next cell boundary
+func (vox *Voxels) fillDetails(rayhit *RayHit, side axis, through float32) {
+	x, y, z := rayhit.IntPos[0], rayhit.IntPos[1], rayhit.IntPos[2]
+	nx, ny, nz := int(rayhit.Normal.X), int(rayhit.Normal.Y), int(rayhit.Normal.Z)
+	rayhit.LastEmpty = [3]int{x + nx, y + ny, z + nz}
+	rayhit.Material = vox.Material(rayhit.Index)
+	rayhit.Through = through
+
+	// Clamp as the position can be a rounding error outside of the voxel
+	local := rayhit.Position.Sub(tensor.Vec3(float32(x), float32(y), float32(z)))
+	rayhit.UVW = local.ComponentClamp(0, 1)
+	switch side {
+	case axisX:
+		rayhit.FaceUV = tensor.Vec2(rayhit.UVW.Z, rayhit.UVW.Y)
+	case axisY:
+		rayhit.FaceUV = tensor.Vec2(rayhit.UVW.X, rayhit.UVW.Z)
+	case axisZ:
+		rayhit.FaceUV = tensor.Vec2(rayhit.UVW.X, rayhit.UVW.Y)
+	}
+}
+
+// Slab test of the ray against the world's bounds.
+// Returns the times the ray enters and exits, the axis of the face it enters through,
+// and false if the ray misses the world
+func (vox *Voxels) clipRay(origin, direc tensor.Vector3) (float32, float32, axis, bool) {
+	o := [3]float32{origin.X, origin.Y, origin.Z}
+	d := [3]float32{direc.X, direc.Y, direc.Z}
+	size := [3]float32{float32(vox.X), float32(vox.Y), float32(vox.Z)}
+
+	tenter, texit := math32.Inf(-1), math32.Inf(1)
+	entryside := none
+	for i := range 3 {
+		if math32.Abs(d[i]) < 1e-9 {
+			if o[i] < 0 || o[i] > size[i] {
+				return 0, 0, none, false
+			}
+			continue
+		}
+		inv := 1.0 / d[i]
+		t0, t1 := -o[i]*inv, (size[i]-o[i])*inv
+		if t0 > t1 {
+			t0, t1 = t1, t0
+		}
+		if t0 > tenter {
+			tenter = t0
+			entryside = axis(i)
+		}
+		texit = math32.Min(texit, t1)
+	}
+	if tenter > texit || texit < 0 {
+		return 0, 0, none, false
+	}
+	return tenter, texit, entryside, true
+}
+
+// Adds a voxel object to the world. The object's materials are added to Materials,
+// voxels whose material doesn't fit are left diffuse
 func (vox *Voxels) AddVoxelObj(vObj VoxelObj, x, y, z int) {
+	ids := make(map[byte]MaterialID, len(vObj.Materials))
+	for cIdx, mat := range vObj.Materials {
+		if id, err := vox.AddMaterial(mat); err == nil {
+			ids[cIdx] = id
+		}
+	}
 	for xyz, cIdx := range vObj.Voxels {
 		vx, vy, vz := int(xyz[0]), int(xyz[1]), int(xyz[2])
 		if vox.Surrounds(x+vx, y+vy, z+vz) {
 			clr := vObj.ColorPalete[cIdx]
 			vox.SetVoxel(x+vx, y+vy, z+vz, clr.R, clr.G, clr.B)
+			if id, ok := ids[cIdx]; ok {
+				vox.SetMaterial(x+vx, y+vy, z+vz, id)
+			}
 		}
 	}
 }
-
-// This is super temporary and just a proof of concept
-func (vox *Voxels) UpdateInputs(window *glfw.Window, pos tensor.Vector3, dir tensor.Vector3) {
-	ray := Ray{Origin: pos, Dir: dir, Tmax: 100.0}
-	hit := vox.MarchRay(ray)
-	if !hit.Hit {
-		return
-	}
-	if window.GetMouseButton(glfw.MouseButtonLeft) == glfw.Press {
-		x, y, z := hit.IntPos[0], hit.IntPos[1], hit.IntPos[2]
-		vox.ResetVoxel(x, y, z)
-	}
-	if window.GetMouseButton(glfw.MouseButtonRight) == glfw.Press {
-		voxel := hit.Position.Add(hit.Normal.Mul(VoxelRayDelta))
-		x, y, z := int(voxel.X), int(voxel.Y), int(voxel.Z)
-		vox.SetVoxel(x, y, z, 255, 255, 255)
-	}
-}
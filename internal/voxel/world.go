@@ -0,0 +1,559 @@
+package voxel
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+
+	"github.com/chewxy/math32"
+	te "github.com/zheskett/go-voxel/internal/tensor"
+)
+
+// World files are a magic string and version followed by a list of tagged chunks:
+//
+//	tag [4]byte | size uint32 | payload [size]byte
+//
+// Readers skip any tag they don't know about, so new chunk types can be added
+// without bumping the version. Everything is little endian.
+const (
+	worldMagicString = "GVXW"
+	WorldVersion     = 1
+
+	dimsTag     = "DIMS" // X, Y, Z as uint32
+	materialTag = "MATL" // count uint32, then count RGB triples. Material 0 is always empty
+	lightTag    = "LGHT" // count uint32, then count (position, color) float32 triples for the point lights, for older readers
+	sourceTag   = "LSRC" // (kind byte, size byte, size bytes of float32s) for every light in order. Replaces LGHT when present
+	chunkTag    = "CHNK" // chunk origin as 3 uint32, then (run, material) uvarint pairs
+	voxMatTag   = "VMAT" // (index delta uvarint, MaterialID byte) pairs for voxels that aren't MaterialDiffuse
+	propsTag    = "MPRP" // (kind byte, flags byte, size byte, size bytes of float32s) for every entry of Materials
+	endTag      = "END "
+
+	// Side length of a world chunk, chunks that are entirely empty are not written
+	WorldChunkSize = 32
+
+	// Largest tag payload Load accepts, anything bigger is a corrupt or hostile file
+	maxTagSize = 64 << 20
+	// VMAT is split into tags of about this size, so Save never writes one Load rejects
+	voxMatTagSize = 1 << 20
+	// Payloads are read this much at a time, so a tag that promises more than the
+	// file has fails before all of it is allocated
+	payloadStep = 1 << 20
+	// Largest world Load accepts, per axis and in total. Voxels take about 27 bytes each
+	// with their color and cached lighting, so the total keeps it to a few GB
+	maxWorldSize   = 4096
+	maxWorldVoxels = 1 << 27
+)
+
+// Save writes the voxels and lights to w in the native world format.
+// Chunks are encoded one at a time so this never holds more than a single
+// chunk's worth of extra data in memory.
+func (vox *Voxels) Save(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	if _, err := bw.WriteString(worldMagicString); err != nil {
+		return err
+	}
+	if err := binary.Write(bw, binary.LittleEndian, uint32(WorldVersion)); err != nil {
+		return err
+	}
+
+	payload := bytes.Buffer{}
+	binary.Write(&payload, binary.LittleEndian, [3]uint32{uint32(vox.X), uint32(vox.Y), uint32(vox.Z)})
+	if err := writeWorldChunk(bw, dimsTag, payload.Bytes()); err != nil {
+		return err
+	}
+
+	// Unique colors become the material table, material 0 is reserved for empty voxels
+	materials := make(map[[3]byte]uint64)
+	table := [][3]byte{}
+	for idx := range vox.Color {
+		if !vox.Presence.Get(idx) {
+			continue
+		}
+		if _, ok := materials[vox.Color[idx]]; !ok {
+			table = append(table, vox.Color[idx])
+			materials[vox.Color[idx]] = uint64(len(table))
+		}
+	}
+	payload.Reset()
+	binary.Write(&payload, binary.LittleEndian, uint32(len(table)))
+	for _, color := range table {
+		payload.Write(color[:])
+	}
+	if err := writeWorldChunk(bw, materialTag, payload.Bytes()); err != nil {
+		return err
+	}
+
+	// Every light goes in LSRC so the order survives, the point lights go in LGHT too
+	// for older readers that only know about those
+	points := []Light{}
+	sources := bytes.Buffer{}
+	for _, source := range vox.Lights {
+		if light, ok := source.(Light); ok {
+			points = append(points, light)
+		}
+		kind, floats := encodeLightSource(source)
+		if kind == 0 {
+			return fmt.Errorf("Can't save light of type %T", source)
+		}
+		sources.WriteByte(kind)
+		sources.WriteByte(byte(len(floats) * 4))
+		binary.Write(&sources, binary.LittleEndian, floats)
+	}
+	payload.Reset()
+	binary.Write(&payload, binary.LittleEndian, uint32(len(points)))
+	for _, light := range points {
+		binary.Write(&payload, binary.LittleEndian, [6]float32{
+			light.Position.X, light.Position.Y, light.Position.Z,
+			light.Color.X, light.Color.Y, light.Color.Z,
+		})
+	}
+	if err := writeWorldChunk(bw, lightTag, payload.Bytes()); err != nil {
+		return err
+	}
+	if sources.Len() > 0 {
+		if err := writeWorldChunk(bw, sourceTag, sources.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	varint := make([]byte, binary.MaxVarintLen64)
+	for cz := 0; cz < vox.Z; cz += WorldChunkSize {
+		for cy := 0; cy < vox.Y; cy += WorldChunkSize {
+			for cx := 0; cx < vox.X; cx += WorldChunkSize {
+				payload.Reset()
+				binary.Write(&payload, binary.LittleEndian, [3]uint32{uint32(cx), uint32(cy), uint32(cz)})
+
+				// Run length encode the material of every cell in the chunk
+				occupied := false
+				run, current := uint64(0), uint64(0)
+				flush := func() {
+					payload.Write(varint[:binary.PutUvarint(varint, run)])
+					payload.Write(varint[:binary.PutUvarint(varint, current)])
+				}
+				vox.forChunkCells(cx, cy, cz, func(idx int) {
+					material := uint64(0)
+					if vox.Presence.Get(idx) {
+						material = materials[vox.Color[idx]]
+						occupied = true
+					}
+					if run > 0 && material != current {
+						flush()
+						run = 0
+					}
+					current = material
+					run++
+				})
+				if !occupied {
+					continue
+				}
+				flush()
+
+				if err := writeWorldChunk(bw, chunkTag, payload.Bytes()); err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	if len(vox.materials) > 0 {
+		indices := make([]int, 0, len(vox.materials))
+		for idx := range vox.materials {
+			indices = append(indices, idx)
+		}
+		slices.Sort(indices)
+		// Each tag's first delta is from 0, so the tags can be read on their own
+		payload.Reset()
+		prev := 0
+		for i, idx := range indices {
+			payload.Write(varint[:binary.PutUvarint(varint, uint64(idx-prev))])
+			payload.WriteByte(byte(vox.materials[idx]))
+			prev = idx
+			if payload.Len() < voxMatTagSize && i < len(indices)-1 {
+				continue
+			}
+			if err := writeWorldChunk(bw, voxMatTag, payload.Bytes()); err != nil {
+				return err
+			}
+			payload.Reset()
+			prev = 0
+		}
+	}
+
+	payload.Reset()
+	for _, mat := range vox.Materials {
+		flags := byte(0)
+		if mat.Light {
+			flags |= 1
+		}
+		floats := []float32{mat.Emission, mat.Roughness, mat.IOR, mat.Density}
+		payload.Write([]byte{byte(mat.Kind), flags, byte(len(floats) * 4)})
+		binary.Write(&payload, binary.LittleEndian, floats)
+	}
+	if err := writeWorldChunk(bw, propsTag, payload.Bytes()); err != nil {
+		return err
+	}
+
+	if err := writeWorldChunk(bw, endTag, nil); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+// Load reads a world written by Save
+func Load(r io.Reader) (Voxels, error) {
+	br := bufio.NewReader(r)
+	header := make([]byte, len(worldMagicString)+4)
+	if _, err := io.ReadFull(br, header); err != nil {
+		return Voxels{}, err
+	}
+	if string(header[:len(worldMagicString)]) != worldMagicString {
+		return Voxels{}, fmt.Errorf("Invalid world file, magic string not found")
+	}
+	version := binary.LittleEndian.Uint32(header[len(worldMagicString):])
+	if version > WorldVersion {
+		return Voxels{}, fmt.Errorf("Unsupported world version: %v", version)
+	}
+
+	vox := Voxels{}
+	sized := false
+	table := [][3]byte{{0, 0, 0}}
+	payload := []byte{}
+	points, sources := []LightSource{}, []LightSource(nil)
+	for {
+		tagHeader := make([]byte, 8)
+		if _, err := io.ReadFull(br, tagHeader); err != nil {
+			return Voxels{}, fmt.Errorf("Malformed world file, missing END tag: %w", err)
+		}
+		tag := string(tagHeader[:4])
+		size := int64(binary.LittleEndian.Uint32(tagHeader[4:]))
+
+		switch tag {
+		case dimsTag, materialTag, lightTag, sourceTag, chunkTag, voxMatTag, propsTag:
+			if size > maxTagSize {
+				return Voxels{}, fmt.Errorf("Malformed world file, %v tag of %v bytes is too big", tag, size)
+			}
+			var err error
+			if payload, err = readPayload(br, int(size), payload); err != nil {
+				return Voxels{}, err
+			}
+		case endTag:
+			if !sized {
+				return Voxels{}, fmt.Errorf("Malformed world file, missing DIMS tag")
+			}
+			vox.Lights = points
+			if sources != nil {
+				vox.Lights = sources
+			}
+			return vox, nil
+		default:
+			// Chunk from a newer writer, skip it
+			if _, err := io.CopyN(io.Discard, br, size); err != nil {
+				return Voxels{}, err
+			}
+			continue
+		}
+
+		if tag != dimsTag && !sized {
+			return Voxels{}, fmt.Errorf("Malformed world file, %v tag before DIMS tag", tag)
+		}
+
+		var err error
+		switch tag {
+		case dimsTag:
+			if size < 12 || sized {
+				return Voxels{}, fmt.Errorf("Malformed DIMS tag")
+			}
+			x := uint64(binary.LittleEndian.Uint32(payload[0:]))
+			y := uint64(binary.LittleEndian.Uint32(payload[4:]))
+			z := uint64(binary.LittleEndian.Uint32(payload[8:]))
+			// Each side is at most 2^12, so the product can't overflow
+			if x == 0 || y == 0 || z == 0 || x > maxWorldSize || y > maxWorldSize || z > maxWorldSize || x*y*z > maxWorldVoxels {
+				return Voxels{}, fmt.Errorf("Bad world size %v x %v x %v", x, y, z)
+			}
+			vox = VoxelsInit(int(x), int(y), int(z))
+			sized = true
+		case materialTag:
+			table, err = readMaterials(payload)
+		case lightTag:
+			points, err = readLights(payload)
+		case sourceTag:
+			var lights []LightSource
+			lights, err = readLightSources(payload)
+			sources = append(slices.Clip(sources), lights...)
+		case chunkTag:
+			err = vox.readChunk(payload, table)
+		case voxMatTag:
+			err = vox.readVoxelMaterials(payload)
+		case propsTag:
+			err = vox.readMaterialProps(payload)
+		}
+		if err != nil {
+			return Voxels{}, err
+		}
+	}
+}
+
+// Reads size bytes into buf, growing it as the data arrives rather than all at once
+func readPayload(r io.Reader, size int, buf []byte) ([]byte, error) {
+	buf = buf[:0]
+	for len(buf) < size {
+		start := len(buf)
+		n := min(size-start, payloadStep)
+		buf = slices.Grow(buf, n)[:start+n]
+		if _, err := io.ReadFull(r, buf[start:]); err != nil {
+			return nil, err
+		}
+	}
+	return buf, nil
+}
+
+// Same as Save, but creates the file at path
+func (vox *Voxels) SavePath(path string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := vox.Save(file); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// Same as Load, but opens the file at path
+func LoadPath(path string) (Voxels, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Voxels{}, err
+	}
+	defer file.Close()
+
+	return Load(file)
+}
+
+func writeWorldChunk(w io.Writer, tag string, payload []byte) error {
+	header := make([]byte, 8)
+	copy(header, tag)
+	binary.LittleEndian.PutUint32(header[4:], uint32(len(payload)))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(payload)
+	return err
+}
+
+// Calls fn with the index of every cell of the chunk at the given origin, in x, y, z order
+func (vox *Voxels) forChunkCells(cx, cy, cz int, fn func(idx int)) {
+	for z := cz; z < min(cz+WorldChunkSize, vox.Z); z++ {
+		for y := cy; y < min(cy+WorldChunkSize, vox.Y); y++ {
+			for x := cx; x < min(cx+WorldChunkSize, vox.X); x++ {
+				fn(vox.Index(x, y, z))
+			}
+		}
+	}
+}
+
+func readMaterials(payload []byte) ([][3]byte, error) {
+	if len(payload) < 4 {
+		return nil, fmt.Errorf("Malformed MATL tag")
+	}
+	count := int(binary.LittleEndian.Uint32(payload))
+	if len(payload) < 4+count*3 {
+		return nil, fmt.Errorf("Not enough MATL data")
+	}
+	table := make([][3]byte, count+1)
+	for i := range count {
+		copy(table[i+1][:], payload[4+i*3:])
+	}
+	return table, nil
+}
+
+func readLights(payload []byte) ([]LightSource, error) {
+	if len(payload) < 4 {
+		return nil, fmt.Errorf("Malformed LGHT tag")
+	}
+	count := int(binary.LittleEndian.Uint32(payload))
+	if len(payload) < 4+count*24 {
+		return nil, fmt.Errorf("Not enough LGHT data")
+	}
+	lights := make([]LightSource, count)
+	for i := range count {
+		f := [6]float32{}
+		for j := range f {
+			f[j] = math32.Float32frombits(binary.LittleEndian.Uint32(payload[4+i*24+j*4:]))
+		}
+		lights[i] = Light{Position: te.Vec3(f[0], f[1], f[2]), Color: te.Vec3(f[3], f[4], f[5])}
+	}
+	return lights, nil
+}
+
+// Kinds of light in the LSRC tag
+const (
+	directionalKind = 1 // Direction, Color
+	spotKind        = 2 // Position, Direction, Color, Angle, Softness
+	rectKind        = 3 // Position, U, V, Color, Count
+	sphereKind      = 4 // Position, Color, Radius, Count
+	voxelKind       = 5 // Position, Color
+	pointKind       = 6 // Position, Color
+)
+
+// Number of float32s each kind is stored with
+var lightKindFloats = map[byte]int{directionalKind: 6, spotKind: 11, rectKind: 13, sphereKind: 8, voxelKind: 6, pointKind: 6}
+
+func encodeLightSource(source LightSource) (byte, []float32) {
+	vec := func(floats []float32, vs ...te.Vector3) []float32 {
+		for _, v := range vs {
+			floats = append(floats, v.X, v.Y, v.Z)
+		}
+		return floats
+	}
+	switch light := source.(type) {
+	case Light:
+		return pointKind, vec(nil, light.Position, light.Color)
+	case DirectionalLight:
+		return directionalKind, vec(nil, light.Direction, light.Color)
+	case SpotLight:
+		return spotKind, append(vec(nil, light.Position, light.Direction, light.Color), light.Angle, light.Softness)
+	case RectLight:
+		return rectKind, append(vec(nil, light.Position, light.U, light.V, light.Color), float32(light.Count))
+	case SphereLight:
+		return sphereKind, append(vec(nil, light.Position, light.Color), light.Radius, float32(light.Count))
+	case VoxelLight:
+		return voxelKind, vec(nil, light.Position, light.Color)
+	}
+	return 0, nil
+}
+
+func readLightSources(payload []byte) ([]LightSource, error) {
+	lights := []LightSource{}
+	for len(payload) > 0 {
+		if len(payload) < 2 || len(payload) < 2+int(payload[1]) || payload[1]%4 != 0 {
+			return nil, fmt.Errorf("Not enough LSRC data")
+		}
+		kind, size := payload[0], int(payload[1])
+		f := make([]float32, size/4)
+		for i := range f {
+			f[i] = math32.Float32frombits(binary.LittleEndian.Uint32(payload[2+i*4:]))
+		}
+		payload = payload[2+size:]
+
+		vec := func(i int) te.Vector3 { return te.Vec3(f[i], f[i+1], f[i+2]) }
+		need, ok := lightKindFloats[kind]
+		if !ok {
+			// Kind from a newer writer, skip it
+			continue
+		}
+		if len(f) < need {
+			return nil, fmt.Errorf("Malformed LSRC light of kind %v", kind)
+		}
+		switch kind {
+		case directionalKind:
+			lights = append(lights, DirectionalLight{Direction: vec(0), Color: vec(3)})
+		case spotKind:
+			lights = append(lights, SpotLight{Position: vec(0), Direction: vec(3), Color: vec(6), Angle: f[9], Softness: f[10]})
+		case rectKind:
+			lights = append(lights, RectLight{Position: vec(0), U: vec(3), V: vec(6), Color: vec(9), Count: int(f[12])})
+		case sphereKind:
+			lights = append(lights, SphereLight{Position: vec(0), Color: vec(3), Radius: f[6], Count: int(f[7])})
+		case voxelKind:
+			lights = append(lights, VoxelLight{Position: vec(0), Color: vec(3)})
+		case pointKind:
+			lights = append(lights, Light{Position: vec(0), Color: vec(3)})
+		}
+	}
+	return lights, nil
+}
+
+func (vox *Voxels) readChunk(payload []byte, table [][3]byte) error {
+	if len(payload) < 12 {
+		return fmt.Errorf("Malformed CHNK tag")
+	}
+	cx := int(binary.LittleEndian.Uint32(payload[0:]))
+	cy := int(binary.LittleEndian.Uint32(payload[4:]))
+	cz := int(binary.LittleEndian.Uint32(payload[8:]))
+	if !vox.Surrounds(cx, cy, cz) {
+		return fmt.Errorf("CHNK origin (%v, %v, %v) outside of world", cx, cy, cz)
+	}
+
+	runs := bytes.NewReader(payload[12:])
+	remaining, material := uint64(0), uint64(0)
+	var err error
+	vox.forChunkCells(cx, cy, cz, func(idx int) {
+		if err != nil {
+			return
+		}
+		for remaining == 0 {
+			if remaining, err = binary.ReadUvarint(runs); err != nil {
+				err = fmt.Errorf("Not enough CHNK data")
+				return
+			}
+			if material, err = binary.ReadUvarint(runs); err != nil {
+				err = fmt.Errorf("Not enough CHNK data")
+				return
+			}
+			if material >= uint64(len(table)) {
+				err = fmt.Errorf("CHNK material %v not in MATL table", material)
+				return
+			}
+		}
+		remaining--
+		if material != 0 {
+			vox.Presence.Set(idx)
+			vox.Color[idx] = table[material]
+		}
+	})
+	return err
+}
+
+func (vox *Voxels) readVoxelMaterials(payload []byte) error {
+	entries := bytes.NewReader(payload)
+	idx := uint64(0)
+	for entries.Len() > 0 {
+		delta, err := binary.ReadUvarint(entries)
+		if err != nil {
+			return fmt.Errorf("Malformed VMAT tag")
+		}
+		material, err := entries.ReadByte()
+		if err != nil {
+			return fmt.Errorf("Not enough VMAT data")
+		}
+		idx += delta
+		if idx >= uint64(len(vox.Color)) {
+			return fmt.Errorf("VMAT index %v outside of world", idx)
+		}
+		vox.materials[int(idx)] = MaterialID(material)
+	}
+	return nil
+}
+
+// Replaces Materials with the saved ones, keeping the built in ones the file doesn't have
+func (vox *Voxels) readMaterialProps(payload []byte) error {
+	materials := []Material{}
+	for len(payload) > 0 {
+		if len(payload) < 3 || len(payload) < 3+int(payload[2]) || payload[2]%4 != 0 {
+			return fmt.Errorf("Not enough MPRP data")
+		}
+		f := make([]float32, max(int(payload[2])/4, 4))
+		for i := range int(payload[2]) / 4 {
+			f[i] = math32.Float32frombits(binary.LittleEndian.Uint32(payload[3+i*4:]))
+		}
+		materials = append(materials, Material{
+			Kind:      MaterialKind(payload[0]),
+			Light:     payload[1]&1 != 0,
+			Emission:  f[0],
+			Roughness: f[1],
+			IOR:       f[2],
+			Density:   f[3],
+		})
+		payload = payload[3+int(payload[2]):]
+	}
+	if len(materials) > 256 {
+		return fmt.Errorf("Too many materials in MPRP tag")
+	}
+	vox.Materials = append(materials, vox.Materials[min(len(materials), len(vox.Materials)):]...)
+	return nil
+}
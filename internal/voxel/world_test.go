@@ -0,0 +1,180 @@
+package voxel
+
+import (
+	"bytes"
+	"encoding/binary"
+	"runtime"
+	"testing"
+
+	te "github.com/zheskett/go-voxel/internal/tensor"
+)
+
+// TestWorldRoundTrip saves a world spanning several chunks and expects Load
+// to return identical voxels and lights.
+func TestWorldRoundTrip(t *testing.T) {
+	vox := VoxelsInit(70, 40, 33)
+	for i := range vox.X {
+		for j := range vox.Z {
+			vox.SetVoxel(i, 0, j, 200, 180, 180)
+		}
+	}
+	vox.SetVoxel(69, 39, 32, 1, 2, 3)
+	vox.SetVoxel(35, 20, 10, 20, 20, 20)
+	vox.SetMaterial(35, 20, 10, MaterialID(2))
+	vox.Lights = append(vox.Lights, Light{Position: te.Vec3(1, 2, 3), Color: te.Vec3(500, 400, 300)})
+
+	buf := bytes.Buffer{}
+	if err := vox.Save(&buf); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	loaded, err := Load(&buf)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	if loaded.X != vox.X || loaded.Y != vox.Y || loaded.Z != vox.Z {
+		t.Fatalf("Expected dims %v %v %v, got %v %v %v", vox.X, vox.Y, vox.Z, loaded.X, loaded.Y, loaded.Z)
+	}
+	for idx := range vox.Color {
+		if vox.Presence.Get(idx) != loaded.Presence.Get(idx) || vox.Color[idx] != loaded.Color[idx] {
+			t.Fatalf("Voxel %v differs after round trip", idx)
+		}
+	}
+	if loaded.Material(loaded.Index(35, 20, 10)) != MaterialID(2) {
+		t.Errorf("Expected material to survive round trip")
+	}
+	if len(loaded.Lights) != 1 || loaded.Lights[0] != vox.Lights[0] {
+		t.Errorf("Expected lights %v, got %v", vox.Lights, loaded.Lights)
+	}
+}
+
+// TestWorldLightSources expects every kind of light to survive a round trip, in order.
+func TestWorldLightSources(t *testing.T) {
+	vox := VoxelsInit(4, 4, 4)
+	vox.Lights = append(vox.Lights,
+		DirectionalLight{Direction: te.Vec3(1, -2, 0), Color: te.Vec3(1, 1, 0.9)},
+		Light{Position: te.Vec3(1, 2, 3), Color: te.Vec3(4, 5, 6)},
+		SpotLight{Position: te.Vec3(1, 2, 3), Direction: te.Vec3(0, -1, 0), Color: te.Vec3Splat(7), Angle: 0.5, Softness: 0.25},
+		RectLight{Position: te.Vec3(2, 3, 2), U: te.Vec3(1, 0, 0), V: te.Vec3(0, 0, 1), Color: te.Vec3Splat(8), Count: 4},
+		SphereLight{Position: te.Vec3(2, 1, 2), Radius: 0.5, Color: te.Vec3Splat(9), Count: 6},
+	)
+
+	buf := bytes.Buffer{}
+	if err := vox.Save(&buf); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	loaded, err := Load(&buf)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	expected := vox.Lights
+	if len(loaded.Lights) != len(expected) {
+		t.Fatalf("Expected %v lights, got %v", len(expected), len(loaded.Lights))
+	}
+	for i := range expected {
+		if loaded.Lights[i] != expected[i] {
+			t.Errorf("Expected light %v to be %v, got %v", i, expected[i], loaded.Lights[i])
+		}
+	}
+}
+
+// TestWorldSkipsUnknownTags inserts a chunk with an unknown tag and expects
+// Load to ignore it.
+func TestWorldSkipsUnknownTags(t *testing.T) {
+	vox := VoxelsInit(4, 4, 4)
+	vox.SetVoxel(1, 2, 3, 9, 8, 7)
+
+	buf := bytes.Buffer{}
+	if err := vox.Save(&buf); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	data := buf.Bytes()
+	header := len(worldMagicString) + 4
+	unknown := []byte("ZZZZ\x03\x00\x00\x00abc")
+	data = append(data[:header:header], append(unknown, data[header:]...)...)
+
+	loaded, err := Load(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if !loaded.Presence.Get(loaded.Index(1, 2, 3)) || loaded.Color[loaded.Index(1, 2, 3)] != [3]byte{9, 8, 7} {
+		t.Errorf("Expected voxel at (1, 2, 3) to survive unknown tag")
+	}
+}
+
+// TestWorldRejectsNewerVersion expects Load to fail on a version it doesn't know.
+func TestWorldRejectsNewerVersion(t *testing.T) {
+	data := []byte(worldMagicString)
+	data = binary.LittleEndian.AppendUint32(data, WorldVersion+1)
+	if _, err := Load(bytes.NewReader(data)); err == nil {
+		t.Errorf("Expected error, got nil")
+	}
+}
+
+// TestWorldRejectsBadSizes expects Load to fail, without allocating what the file
+// claims, on sizes no real world has.
+func TestWorldRejectsBadSizes(t *testing.T) {
+	file := func(tags ...[]byte) []byte {
+		data := binary.LittleEndian.AppendUint32([]byte(worldMagicString), WorldVersion)
+		for _, tag := range tags {
+			data = append(data, tag...)
+		}
+		return append(data, "END \x00\x00\x00\x00"...)
+	}
+	tag := func(name string, size uint32, payload ...uint32) []byte {
+		data := binary.LittleEndian.AppendUint32([]byte(name), size)
+		for _, v := range payload {
+			data = binary.LittleEndian.AppendUint32(data, v)
+		}
+		return data
+	}
+
+	cases := map[string][]byte{
+		"empty world":       file(tag(dimsTag, 12, 0, 4, 4)),
+		"huge side":         file(tag(dimsTag, 12, 1<<20, 1, 1)),
+		"overflowing sides": file(tag(dimsTag, 12, 1<<31, 1<<31, 1<<31)),
+		"too many voxels":   file(tag(dimsTag, 12, 4096, 4096, 4096)),
+		"too big to load":   file(tag(dimsTag, 12, 4096, 4096, 256)),
+		"huge payload":      file(tag(dimsTag, 12, 4, 4, 4), tag(chunkTag, 0xffffffff)),
+		"missing payload":   file(tag(dimsTag, 12, 4, 4, 4), tag(chunkTag, maxTagSize)),
+	}
+	for name, data := range cases {
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		if _, err := Load(bytes.NewReader(data)); err == nil {
+			t.Errorf("%v: expected error, got nil", name)
+		}
+		runtime.ReadMemStats(&after)
+		if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 4<<20 {
+			t.Errorf("%v: expected to fail early, allocated %v bytes first", name, allocated)
+		}
+	}
+}
+
+// TestWorldSplitsVoxelMaterials expects materials on more voxels than fit in one
+// VMAT tag to survive a round trip.
+func TestWorldSplitsVoxelMaterials(t *testing.T) {
+	vox := VoxelsInit(96, 96, 96)
+	for idx := range vox.Color {
+		vox.materials[idx] = MaterialID(1 + idx%3)
+	}
+	buf := bytes.Buffer{}
+	if err := vox.Save(&buf); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if tags := bytes.Count(buf.Bytes(), []byte(voxMatTag)); tags < 2 {
+		t.Fatalf("Expected several VMAT tags, got %v", tags)
+	}
+	loaded, err := Load(&buf)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(loaded.materials) != len(vox.materials) {
+		t.Fatalf("Expected %v voxel materials, got %v", len(vox.materials), len(loaded.materials))
+	}
+	for idx, mat := range vox.materials {
+		if loaded.materials[idx] != mat {
+			t.Fatalf("Voxel %v has material %v, expected %v", idx, loaded.materials[idx], mat)
+		}
+	}
+}
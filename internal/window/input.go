@@ -0,0 +1,116 @@
+package window
+
+import (
+	"github.com/go-gl/glfw/v3.3/glfw"
+	"github.com/zheskett/go-voxel/internal/render"
+	te "github.com/zheskett/go-voxel/internal/tensor"
+	vxl "github.com/zheskett/go-voxel/internal/voxel"
+)
+
+// Returns how far the cursor moved since the last call
+func GetMouseDelta(data *render.FrameData, window *glfw.Window) (float32, float32) {
+	mx_f64, my_f64 := window.GetCursorPos()
+	mx, my := float32(mx_f64), float32(my_f64)
+	dx, dy := data.Mouse.X-mx, data.Mouse.Y-my
+	data.Mouse = te.Vec2(mx, my)
+
+	return dx, dy
+}
+
+// This is super temporary and just a proof of concept
+//
+// Every mouse stroke is recorded as a single transaction in the journal
+func UpdateEditInputs(journal *vxl.Journal, vox *vxl.Voxels, window *glfw.Window, pos te.Vector3, dir te.Vector3) {
+	left := window.GetMouseButton(glfw.MouseButtonLeft) == glfw.Press
+	right := window.GetMouseButton(glfw.MouseButtonRight) == glfw.Press
+	if !left && !right {
+		journal.Commit()
+		return
+	}
+	journal.Begin()
+
+	ray := vxl.Ray{Origin: pos, Dir: dir, Tmax: 100.0, Details: true}
+	hit := vox.MarchRay(ray)
+	if !hit.Hit {
+		return
+	}
+	if left {
+		x, y, z := hit.IntPos[0], hit.IntPos[1], hit.IntPos[2]
+		journal.ResetVoxel(x, y, z)
+	}
+	if right {
+		x, y, z := hit.LastEmpty[0], hit.LastEmpty[1], hit.LastEmpty[2]
+		journal.SetVoxel(x, y, z, 255, 255, 255)
+	}
+}
+
+func UpdateCamInputGLFW(cam *render.Camera, window *glfw.Window, frame *render.FrameData) {
+	rx, ry, rz := 0, 0, 0
+	tx, ty, tz := 0, 0, 0
+	if window.GetKey(glfw.KeyW) == glfw.Press {
+		tz++
+	}
+	if window.GetKey(glfw.KeyS) == glfw.Press {
+		tz--
+	}
+	if window.GetKey(glfw.KeyA) == glfw.Press {
+		tx--
+	}
+	if window.GetKey(glfw.KeyD) == glfw.Press {
+		tx++
+	}
+	if window.GetKey(glfw.KeySpace) == glfw.Press {
+		ty--
+	}
+	if window.GetKey(glfw.KeyLeftShift) == glfw.Press {
+		ty++
+	}
+	if window.GetKey(glfw.KeyUp) == glfw.Press {
+		rx++
+	}
+	if window.GetKey(glfw.KeyDown) == glfw.Press {
+		rx--
+	}
+	if window.GetKey(glfw.KeyRight) == glfw.Press {
+		ry++
+	}
+	if window.GetKey(glfw.KeyLeft) == glfw.Press {
+		ry--
+	}
+	if window.GetKey(glfw.KeyQ) == glfw.Press {
+		rz--
+	}
+	if window.GetKey(glfw.KeyE) == glfw.Press {
+		rz++
+	}
+	cam.UpdateRotation(float32(rx), float32(ry), float32(rz), frame)
+	cam.UpdatePosition(float32(tx), float32(ty), float32(tz), frame)
+}
+
+func UpdateCamInputGLFWFPS(cam *render.Camera, window *glfw.Window, frame *render.FrameData) {
+	tx, ty, tz := 0, 0, 0
+	if window.GetKey(glfw.KeyW) == glfw.Press {
+		tz++
+	}
+	if window.GetKey(glfw.KeyS) == glfw.Press {
+		tz--
+	}
+	if window.GetKey(glfw.KeyA) == glfw.Press {
+		tx--
+	}
+	if window.GetKey(glfw.KeyD) == glfw.Press {
+		tx++
+	}
+	if window.GetKey(glfw.KeySpace) == glfw.Press {
+		ty--
+	}
+	if window.GetKey(glfw.KeyLeftShift) == glfw.Press {
+		ty++
+	}
+	if window.GetKey(glfw.KeyT) == glfw.Press {
+		window.SetInputMode(glfw.CursorMode, glfw.CursorNormal)
+	}
+	dx, dy := GetMouseDelta(frame, window)
+	cam.UpdateRotationFPS(dy, dx)
+	cam.UpdatePositionFPS(float32(tx), float32(ty), float32(tz), frame)
+}
@@ -0,0 +1,160 @@
+// Package window displays the renderer's Pixels in a GLFW window and handles its input
+package window
+
+import (
+	"runtime"
+
+	"github.com/go-gl/gl/v3.3-core/gl"
+	"github.com/go-gl/glfw/v3.3/glfw"
+	"github.com/zheskett/go-voxel/internal/render"
+)
+
+// Window info. The window opens at the texture size times WindowUpscale, after that
+// the render resolution follows the framebuffer divided by WindowUpscale and scaled by Scaler
+const (
+	TextureWidth  = 400
+	TextureHeight = 300
+	WindowUpscale = 4
+	WindowTitle   = "Go Voxel"
+)
+
+// RenderManager contains state for the rendering
+type RenderManager struct {
+	renderTexture uint32
+	fbo           uint32
+	Pixels        render.Pixels
+	Scaler        render.ResolutionScaler
+
+	fbWidth  int
+	fbHeight int
+}
+
+// RenderManagerInit initializes the render manager
+// and initializes the opengl context
+func RenderManagerInit() (*RenderManager, *glfw.Window) {
+	rm := RenderManager{}
+
+	// Initialize glfw
+	err := glfw.Init()
+	if err != nil {
+		panic(err)
+	}
+
+	// Window creation
+	switch runtime.GOOS {
+	case "darwin": // MacOS
+		glfw.WindowHint(glfw.ContextVersionMajor, 3)
+		glfw.WindowHint(glfw.ContextVersionMinor, 3)
+		glfw.WindowHint(glfw.OpenGLProfile, glfw.OpenGLCoreProfile)
+		glfw.WindowHint(glfw.OpenGLForwardCompatible, glfw.True)
+	case "windows": // Windows
+		glfw.WindowHint(glfw.ContextVersionMajor, 3)
+		glfw.WindowHint(glfw.ContextVersionMinor, 3)
+		glfw.WindowHint(glfw.OpenGLProfile, glfw.OpenGLCompatProfile)
+		glfw.WindowHint(glfw.OpenGLForwardCompatible, glfw.False)
+	default:
+		glfw.WindowHint(glfw.ContextVersionMajor, 3)
+		glfw.WindowHint(glfw.ContextVersionMinor, 1)
+		glfw.WindowHint(glfw.OpenGLProfile, glfw.OpenGLAnyProfile)
+		glfw.WindowHint(glfw.OpenGLForwardCompatible, glfw.False)
+	}
+	window, err := glfw.CreateWindow(TextureWidth*WindowUpscale, TextureHeight*WindowUpscale, WindowTitle, nil, nil)
+	if err != nil {
+		panic(err)
+	}
+	window.MakeContextCurrent()
+	window.SetInputMode(glfw.CursorMode, glfw.CursorDisabled)
+
+	// Initialize gl
+	err = gl.Init()
+	if err != nil {
+		panic(err)
+	}
+
+	gl.GenFramebuffers(1, &rm.fbo)
+	gl.BindFramebuffer(gl.FRAMEBUFFER, rm.fbo)
+	gl.GenTextures(1, &rm.renderTexture)
+	gl.BindTexture(gl.TEXTURE_2D, rm.renderTexture)
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA, TextureWidth, TextureHeight, 0, gl.RGBA, gl.UNSIGNED_BYTE, nil)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MIN_FILTER, gl.NEAREST)
+	gl.TexParameteri(gl.TEXTURE_2D, gl.TEXTURE_MAG_FILTER, gl.NEAREST)
+	gl.FramebufferTexture2D(gl.FRAMEBUFFER, gl.COLOR_ATTACHMENT0, gl.TEXTURE_2D, rm.renderTexture, 0)
+
+	rm.Pixels = render.PixelsInitHDR(TextureWidth, TextureHeight)
+
+	// Dynamic scaling is off until someone sets a target
+	rm.Scaler = render.ResolutionScalerInit(0)
+	rm.Resize(window.GetFramebufferSize())
+	window.SetFramebufferSizeCallback(func(_ *glfw.Window, width, height int) {
+		rm.Resize(width, height)
+	})
+
+	return &rm, window
+}
+
+// Resize sets the size of the framebuffer the render is stretched over
+// and reallocates the render at the matching resolution
+func (rm *RenderManager) Resize(fbWidth, fbHeight int) {
+	// Minimized windows report a zero size, keep the old render around
+	if fbWidth <= 0 || fbHeight <= 0 {
+		return
+	}
+	rm.fbWidth, rm.fbHeight = fbWidth, fbHeight
+	rm.applyScale()
+}
+
+// UpdateScale feeds the last frame time in seconds to the scaler,
+// changing the render resolution if it asks for it
+func (rm *RenderManager) UpdateScale(frametime float32) {
+	if rm.Scaler.Update(frametime) {
+		rm.applyScale()
+	}
+}
+
+// SetScale turns dynamic scaling off and renders at a fixed scale
+func (rm *RenderManager) SetScale(scale float32) {
+	rm.Scaler.Target = 0
+	rm.Scaler.Scale = min(max(scale, rm.Scaler.MinScale), rm.Scaler.MaxScale)
+	rm.applyScale()
+}
+
+// Aspect of the window, which the render is stretched to fill
+func (rm *RenderManager) Aspect() float32 {
+	if rm.fbHeight == 0 {
+		return float32(rm.Pixels.Width) / float32(rm.Pixels.Height)
+	}
+	return float32(rm.fbWidth) / float32(rm.fbHeight)
+}
+
+func (rm *RenderManager) applyScale() {
+	width, height := rm.Scaler.Resolution(rm.fbWidth/WindowUpscale, rm.fbHeight/WindowUpscale)
+	if width == rm.Pixels.Width && height == rm.Pixels.Height {
+		return
+	}
+	aovs := rm.Pixels.AOVs() != nil
+	rm.Pixels = render.PixelsInitHDR(width, height)
+	if aovs {
+		rm.Pixels.EnableAOVs()
+	}
+
+	gl.BindTexture(gl.TEXTURE_2D, rm.renderTexture)
+	gl.TexImage2D(gl.TEXTURE_2D, 0, gl.RGBA, int32(width), int32(height), 0, gl.RGBA, gl.UNSIGNED_BYTE, nil)
+}
+
+// Render renders the current state
+// It should be called each frame
+func (rm *RenderManager) Render(window *glfw.Window) {
+	gl.BindFramebuffer(gl.FRAMEBUFFER, rm.fbo)
+	width, height := int32(rm.Pixels.Width), int32(rm.Pixels.Height)
+	gl.Viewport(0, 0, width, height)
+
+	gl.BindTexture(gl.TEXTURE_2D, rm.renderTexture)
+	gl.TexSubImage2D(gl.TEXTURE_2D, 0, 0, 0, width, height, gl.RGBA, gl.UNSIGNED_BYTE, gl.Ptr(rm.Pixels.Data()))
+
+	gl.BindFramebuffer(gl.READ_FRAMEBUFFER, rm.fbo)
+	gl.BindFramebuffer(gl.DRAW_FRAMEBUFFER, 0)
+
+	gl.BlitFramebuffer(0, 0, width, height, 0, 0, int32(rm.fbWidth), int32(rm.fbHeight), gl.COLOR_BUFFER_BIT, gl.NEAREST)
+	window.SwapBuffers()
+	glfw.PollEvents()
+}
@@ -9,6 +9,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"os"
+	"strconv"
 )
 
 const (
@@ -18,6 +19,7 @@ const (
 	sizeTag        = "SIZE"
 	xyziTag        = "XYZI"
 	colorTag       = "RGBA"
+	materialTag    = "MATL"
 	paletteSize    = 256
 )
 
@@ -32,6 +34,25 @@ type Vox struct {
 	NumModels int        // The number of models
 	Models    []Model    // The model data
 	Palette   VoxPalette // The palette of the .vox file
+	// True when the file has no palette of its own and Palette is DefaultPalette
+	PaletteIsDefault bool
+	// Materials from MATL chunks, by the palette index they belong to
+	Materials map[int]Material
+}
+
+// Material is a MagicaVoxel material, which applies to every voxel with its palette index
+type Material struct {
+	Type       string            // _diffuse, _metal, _glass, _emit, _blend or _media
+	Properties map[string]string // The rest of the material's dictionary, like _rough, _ior or _emit
+}
+
+// Float reads a number property, or returns fallback if it's missing or not a number
+func (mat Material) Float(key string, fallback float64) float64 {
+	value, err := strconv.ParseFloat(mat.Properties[key], 64)
+	if err != nil {
+		return fallback
+	}
+	return value
 }
 
 // Models contains the size of a model and the model data
@@ -79,10 +100,16 @@ func Parse(path string) (Vox, error) {
 
 	vox.NumModels = len(vox.Models)
 
+	vox.Materials, err = fb.readMaterials()
+	if err != nil {
+		return Vox{}, err
+	}
+
 	rgbaSize, _, err := fb.findTag(colorTag)
 	// No color tag
 	if err != nil {
 		vox.Palette = DefaultPalette
+		vox.PaletteIsDefault = true
 		return vox, nil
 	}
 
@@ -222,3 +249,75 @@ func (fb *fileBytes) readPalette() (VoxPalette, error) {
 
 	return palette, nil
 }
+
+// readMaterials reads every MATL chunk among the children of MAIN, without moving fb.pos.
+// Chunks are walked one at a time by their sizes, so voxel data that happens to spell
+// out the tag isn't mistaken for one.
+// Returns the materials by id and an error if one has occurred
+func (fb *fileBytes) readMaterials() (map[int]Material, error) {
+	materials := make(map[int]Material)
+	// Magic string and version, then MAIN's id, sizes and (empty) content
+	pos := len(voxMagicString) + 4 + 12
+	for pos < len(fb.byteArr) {
+		if len(fb.byteArr)-pos < 12 {
+			return nil, fmt.Errorf("Malformed chunk header")
+		}
+		id := string(fb.byteArr[pos : pos+4])
+		contentSize := int(binary.LittleEndian.Uint32(fb.byteArr[pos+4:]))
+		childrenSize := int(binary.LittleEndian.Uint32(fb.byteArr[pos+8:]))
+		pos += 12
+		if contentSize > len(fb.byteArr)-pos || childrenSize > len(fb.byteArr)-pos-contentSize {
+			return nil, fmt.Errorf("Chunk %v data occurs passed file end", id)
+		}
+		if id == materialTag {
+			chunk := fileBytes{fb.byteArr[pos : pos+contentSize], 0}
+			matID, mat, err := chunk.readMaterial()
+			if err != nil {
+				return nil, err
+			}
+			materials[matID] = mat
+		}
+		pos += contentSize + childrenSize
+	}
+	return materials, nil
+}
+
+// readMaterial reads the id and dictionary of a MATL chunk's data.
+// Returns the id, the material and an error if one has occurred
+func (fb *fileBytes) readMaterial() (int, Material, error) {
+	readString := func() (string, bool) {
+		if len(fb.byteArr)-fb.pos < 4 {
+			return "", false
+		}
+		size := fb.readInt()
+		if size < 0 || size > len(fb.byteArr)-fb.pos {
+			return "", false
+		}
+		str := string(fb.byteArr[fb.pos : fb.pos+size])
+		fb.pos += size
+		return str, true
+	}
+
+	if len(fb.byteArr) < 8 {
+		return 0, Material{}, fmt.Errorf("Not enough MATL data")
+	}
+	id := fb.readInt()
+	pairs := fb.readInt()
+	mat := Material{Properties: make(map[string]string)}
+	for range pairs {
+		key, ok := readString()
+		if !ok {
+			return 0, Material{}, fmt.Errorf("Not enough MATL data")
+		}
+		value, ok := readString()
+		if !ok {
+			return 0, Material{}, fmt.Errorf("Not enough MATL data")
+		}
+		if key == "_type" {
+			mat.Type = value
+		} else {
+			mat.Properties[key] = value
+		}
+	}
+	return id, mat, nil
+}
@@ -3,6 +3,11 @@
 package voxparse
 
 import (
+	"encoding/binary"
+	"image/color"
+	"os"
+	"path/filepath"
+	"slices"
 	"testing"
 )
 
@@ -14,3 +19,84 @@ func TestRejectBadFileHeader(t *testing.T) {
 		t.Errorf("Expected error, got nil")
 	}
 }
+
+// chunk encodes a .vox chunk with no children
+func chunk(tag string, content []byte) []byte {
+	out := []byte(tag)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(content)))
+	out = binary.LittleEndian.AppendUint32(out, 0)
+	return append(out, content...)
+}
+
+func voxString(s string) []byte {
+	return append(binary.LittleEndian.AppendUint32(nil, uint32(len(s))), s...)
+}
+
+// writeVox writes a .vox file with the given chunks as the children of MAIN, and returns its path
+func writeVox(t *testing.T, children ...[]byte) string {
+	content := slices.Concat(children...)
+	file := []byte(voxMagicString)
+	file = binary.LittleEndian.AppendUint32(file, 150)
+	file = append(file, mainTag...)
+	file = binary.LittleEndian.AppendUint32(file, 0)
+	file = binary.LittleEndian.AppendUint32(file, uint32(len(content)))
+	file = append(file, content...)
+
+	path := filepath.Join(t.TempDir(), "test.vox")
+	if err := os.WriteFile(path, file, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestParseMaterials writes a .vox file with a glass material and a palette and
+// expects Parse to read both.
+func TestParseMaterials(t *testing.T) {
+	size := binary.LittleEndian.AppendUint32(nil, 2)
+	size = binary.LittleEndian.AppendUint32(size, 2)
+	size = binary.LittleEndian.AppendUint32(size, 2)
+	xyzi := binary.LittleEndian.AppendUint32(nil, 1)
+	xyzi = append(xyzi, 1, 0, 1, 5)
+	rgba := make([]byte, paletteSize*4)
+	copy(rgba[4*4:], []byte{10, 20, 30, 128})
+	matl := binary.LittleEndian.AppendUint32(nil, 5)
+	matl = binary.LittleEndian.AppendUint32(matl, 2)
+	matl = append(matl, voxString("_type")...)
+	matl = append(matl, voxString("_glass")...)
+	matl = append(matl, voxString("_ior")...)
+	matl = append(matl, voxString("0.3")...)
+
+	path := writeVox(t, chunk(sizeTag, size), chunk(xyziTag, xyzi), chunk(colorTag, rgba), chunk(materialTag, matl))
+	vox, err := Parse(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if vox.PaletteIsDefault || vox.Palette[5] != (color.RGBA{10, 20, 30, 128}) {
+		t.Errorf("Expected the file's palette, got %v", vox.Palette[5])
+	}
+	mat, ok := vox.Materials[5]
+	if !ok || mat.Type != "_glass" || mat.Float("_ior", 0) != 0.3 || mat.Float("_rough", 0.5) != 0.5 {
+		t.Errorf("Expected glass material 5, got %v", vox.Materials)
+	}
+}
+
+// TestVoxelsSpellingMATL expects a voxel whose bytes spell out "MATL" to be read as a
+// voxel, not as the start of a material chunk.
+func TestVoxelsSpellingMATL(t *testing.T) {
+	size := binary.LittleEndian.AppendUint32(nil, 128)
+	size = binary.LittleEndian.AppendUint32(size, 128)
+	size = binary.LittleEndian.AppendUint32(size, 128)
+	xyzi := binary.LittleEndian.AppendUint32(nil, 1)
+	xyzi = append(xyzi, 77, 65, 84, 76)
+
+	vox, err := Parse(writeVox(t, chunk(sizeTag, size), chunk(xyziTag, xyzi)))
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(vox.Models) != 1 || len(vox.Models[0].Voxels) != 1 || vox.Models[0].Voxels[0] != (XYZI{77, 65, 84, 76}) {
+		t.Errorf("Expected the voxel (77, 65, 84, 76), got %v", vox.Models)
+	}
+	if len(vox.Materials) != 0 {
+		t.Errorf("Expected no materials, got %v", vox.Materials)
+	}
+}